@@ -11,6 +11,32 @@ var (
 	ErrNotEnoughChildren  = errors.New("not enough children")
 )
 
+// An AttrError is an error caused by a specific attribute
+// of a block.
+//
+// Creators may return an AttrError so that the error can
+// be traced back to the attribute in the markup.
+type AttrError struct {
+	// Attr is the name of the attribute.
+	Attr string
+
+	Err error
+}
+
+func attrError(attr string, format string, args ...interface{}) *AttrError {
+	return &AttrError{Attr: attr, Err: fmt.Errorf(format, args...)}
+}
+
+// Error returns the message of the underlying error.
+func (a *AttrError) Error() string {
+	return a.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (a *AttrError) Unwrap() error {
+	return a.Err
+}
+
 // Dims defines the dimensions of a 3D tensor.
 type Dims struct {
 	Width  int
@@ -40,10 +66,13 @@ func DefaultCreators() map[string]Creator {
 		"Input":      CreateInput,
 		"Assert":     CreateAssert,
 		"Conv":       CreateConv,
+		"Deconv":     CreateDeconv,
 		"Padding":    CreatePadding,
 		"Resize":     CreateResize,
 		"Residual":   CreateResidual,
 		"Projection": CreateProjection,
+		"Concat":     CreateConcat,
+		"Branch":     CreateBranch,
 		"FC":         CreateFC,
 		"Repeat":     CreateRepeat,
 		"Linear":     CreateLinear,
@@ -159,6 +188,25 @@ type Conv struct {
 	StrideX int
 	StrideY int
 
+	// DilationX and DilationY specify the spacing between
+	// filter taps, where 1 means no dilation.
+	DilationX int
+	DilationY int
+
+	// Groups is the number of filter groups.
+	// The input channels and the filters are split into
+	// Groups equal parts, and each group of filters only
+	// sees the corresponding group of input channels.
+	// A value of 1 gives a regular convolution.
+	Groups int
+
+	// Zero padding which is applied to each side of the
+	// input before the convolution.
+	PadTop    int
+	PadRight  int
+	PadBottom int
+	PadLeft   int
+
 	Out Dims
 }
 
@@ -167,15 +215,27 @@ func CreateConv(in Dims, attr map[string]float64, children []Block) (Block, erro
 	if len(children) > 0 {
 		return nil, ErrUnexpectedChildren
 	}
-	if err := onlyTheseAttrs(attr, "w", "h", "n", "sx", "sy"); err != nil {
+	if err := onlyTheseAttrs(attr, "w", "h", "n", "sx", "sy", "dx", "dy", "p",
+		"same", "groups"); err != nil {
 		return nil, err
 	}
 	if err := hasAllAttrs(attr, "w", "h", "n"); err != nil {
 		return nil, err
 	}
-	if err := validInt(attr, 1, "w", "h", "n", "sx", "sy"); err != nil {
+	if err := validInt(attr, 1, "w", "h", "n", "sx", "sy", "dx", "dy",
+		"groups"); err != nil {
 		return nil, err
 	}
+	if err := validInt(attr, 0, "p", "same"); err != nil {
+		return nil, err
+	}
+	if attr["same"] > 1 {
+		return nil, attrError("same", "attribute same must be 0 or 1")
+	}
+	_, hasPadding := attr["p"]
+	if hasPadding && attr["same"] == 1 {
+		return nil, attrError("same", "attributes p and same are mutually exclusive")
+	}
 
 	res := &Conv{
 		FilterWidth:  int(attr["w"]),
@@ -183,6 +243,13 @@ func CreateConv(in Dims, attr map[string]float64, children []Block) (Block, erro
 		FilterCount:  int(attr["n"]),
 		StrideX:      int(attr["sx"]),
 		StrideY:      int(attr["sy"]),
+		DilationX:    int(attr["dx"]),
+		DilationY:    int(attr["dy"]),
+		Groups:       int(attr["groups"]),
+		PadTop:       int(attr["p"]),
+		PadRight:     int(attr["p"]),
+		PadBottom:    int(attr["p"]),
+		PadLeft:      int(attr["p"]),
 	}
 
 	if res.StrideX == 0 {
@@ -191,22 +258,69 @@ func CreateConv(in Dims, attr map[string]float64, children []Block) (Block, erro
 	if res.StrideY == 0 {
 		res.StrideY = 1
 	}
+	if res.DilationX == 0 {
+		res.DilationX = 1
+	}
+	if res.DilationY == 0 {
+		res.DilationY = 1
+	}
+	if res.Groups == 0 {
+		res.Groups = 1
+	}
+	if in.Depth%res.Groups != 0 {
+		return nil, attrError("groups", "input depth %d is not divisible by %d groups",
+			in.Depth, res.Groups)
+	}
+	if res.FilterCount%res.Groups != 0 {
+		return nil, attrError("groups", "filter count %d is not divisible by %d groups",
+			res.FilterCount, res.Groups)
+	}
+
+	spanX, spanY := res.FilterSpan()
+	if attr["same"] == 1 {
+		res.PadLeft, res.PadRight = samePadding(in.Width, spanX, res.StrideX)
+		res.PadTop, res.PadBottom = samePadding(in.Height, spanY, res.StrideY)
+	}
 
 	res.Out = Dims{
-		Width:  1 + (in.Width-res.FilterWidth)/res.StrideX,
-		Height: 1 + (in.Height-res.FilterHeight)/res.StrideY,
+		Width:  validOutSize(in.Width+res.PadLeft+res.PadRight, spanX, res.StrideX),
+		Height: validOutSize(in.Height+res.PadTop+res.PadBottom, spanY, res.StrideY),
 		Depth:  res.FilterCount,
 	}
-	if res.Out.Width < 0 {
-		res.Out.Width = 0
-	}
-	if res.Out.Height < 0 {
-		res.Out.Height = 0
-	}
 
 	return res, nil
 }
 
+// FilterSpan returns the width and height of the input
+// region covered by a single filter, taking dilation into
+// account.
+func (c *Conv) FilterSpan() (width, height int) {
+	return (c.FilterWidth-1)*c.DilationX + 1, (c.FilterHeight-1)*c.DilationY + 1
+}
+
+// samePadding computes the padding before and after an
+// input so that a sliding window produces ceil(in/stride)
+// outputs.
+// When the total padding is odd, the extra unit of padding
+// goes after the input.
+func samePadding(in, span, stride int) (before, after int) {
+	out := (in + stride - 1) / stride
+	total := (out-1)*stride + span - in
+	if total < 0 {
+		total = 0
+	}
+	return total / 2, total - total/2
+}
+
+// validOutSize computes the number of positions for a
+// sliding window that does not extend past the input.
+func validOutSize(in, span, stride int) int {
+	if in < span {
+		return 0
+	}
+	return 1 + (in-span)/stride
+}
+
 // Type returns "Conv".
 func (c *Conv) Type() string {
 	return "Conv"
@@ -217,6 +331,84 @@ func (c *Conv) OutDims() Dims {
 	return c.Out
 }
 
+// Deconv is a Block for a transposed convolutional layer,
+// which is typically used for upsampling.
+type Deconv struct {
+	FilterWidth  int
+	FilterHeight int
+	FilterCount  int
+
+	StrideX int
+	StrideY int
+
+	// OutPadX and OutPadY specify extra columns and rows
+	// which are added to the right and bottom of the output.
+	OutPadX int
+	OutPadY int
+
+	Out Dims
+}
+
+// CreateDeconv creates a *Deconv block.
+func CreateDeconv(in Dims, attr map[string]float64, children []Block) (Block, error) {
+	if len(children) > 0 {
+		return nil, ErrUnexpectedChildren
+	}
+	if err := onlyTheseAttrs(attr, "w", "h", "n", "sx", "sy", "ox", "oy"); err != nil {
+		return nil, err
+	}
+	if err := hasAllAttrs(attr, "w", "h", "n"); err != nil {
+		return nil, err
+	}
+	if err := validInt(attr, 1, "w", "h", "n", "sx", "sy"); err != nil {
+		return nil, err
+	}
+	if err := validInt(attr, 0, "ox", "oy"); err != nil {
+		return nil, err
+	}
+
+	res := &Deconv{
+		FilterWidth:  int(attr["w"]),
+		FilterHeight: int(attr["h"]),
+		FilterCount:  int(attr["n"]),
+		StrideX:      int(attr["sx"]),
+		StrideY:      int(attr["sy"]),
+		OutPadX:      int(attr["ox"]),
+		OutPadY:      int(attr["oy"]),
+	}
+
+	if res.StrideX == 0 {
+		res.StrideX = 1
+	}
+	if res.StrideY == 0 {
+		res.StrideY = 1
+	}
+	if res.OutPadX >= res.StrideX {
+		return nil, attrError("ox", "output padding must be smaller than stride")
+	}
+	if res.OutPadY >= res.StrideY {
+		return nil, attrError("oy", "output padding must be smaller than stride")
+	}
+
+	res.Out = Dims{Depth: res.FilterCount}
+	if in.Width > 0 && in.Height > 0 {
+		res.Out.Width = (in.Width-1)*res.StrideX + res.FilterWidth + res.OutPadX
+		res.Out.Height = (in.Height-1)*res.StrideY + res.FilterHeight + res.OutPadY
+	}
+
+	return res, nil
+}
+
+// Type returns "Deconv".
+func (d *Deconv) Type() string {
+	return "Deconv"
+}
+
+// OutDims returns the output dimensions.
+func (d *Deconv) OutDims() Dims {
+	return d.Out
+}
+
 // Pool is a pooling block.
 // The Name attribute will be "MaxPool" or "MeanPool".
 type Pool struct {
@@ -247,11 +439,20 @@ func PoolCreator(name string) Creator {
 			StrideX: int(attr["sx"]),
 			StrideY: int(attr["sy"]),
 		}
+		if in.Width == 0 || in.Height == 0 {
+			return nil, errors.New("input cannot be empty")
+		}
 		if res.Width == 0 {
 			res.Width = in.Width
+		} else if res.Width > in.Width {
+			return nil, attrError("w", "window width %d exceeds input width %d",
+				res.Width, in.Width)
 		}
 		if res.Height == 0 {
 			res.Height = in.Height
+		} else if res.Height > in.Height {
+			return nil, attrError("h", "window height %d exceeds input height %d",
+				res.Height, in.Height)
 		}
 		if res.StrideX == 0 {
 			res.StrideX = res.Width
@@ -260,16 +461,10 @@ func PoolCreator(name string) Creator {
 			res.StrideY = res.Height
 		}
 		res.Out = Dims{
-			Width:  1 + (in.Width-res.Width)/res.StrideX,
-			Height: 1 + (in.Height-res.Height)/res.StrideY,
+			Width:  validOutSize(in.Width, res.Width, res.StrideX),
+			Height: validOutSize(in.Height, res.Height, res.StrideY),
 			Depth:  in.Depth,
 		}
-		if res.Out.Width < 0 {
-			res.Out.Width = 0
-		}
-		if res.Out.Height < 0 {
-			res.Out.Height = 0
-		}
 		return res, nil
 	}
 }
@@ -442,6 +637,92 @@ func (p *Projection) OutDims() Dims {
 	return p.In
 }
 
+// Concat is a block which feeds its input to several
+// branches and concatenates the branches' outputs along
+// the depth dimension.
+type Concat struct {
+	// Branches contains the sub-blocks of every branch.
+	// An empty branch passes its input through unchanged.
+	Branches [][]Block
+
+	Out Dims
+}
+
+// CreateConcat creates a *Concat block.
+func CreateConcat(in Dims, attr map[string]float64, children []Block) (Block, error) {
+	if err := hasAllAndOnlyInts(attr, 0); err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, ErrNotEnoughChildren
+	}
+	res := &Concat{}
+	for i, child := range children {
+		branch, ok := child.(*Branch)
+		if !ok {
+			return nil, errors.New("unexpected child in Concat: " + child.Type())
+		}
+		out := branch.BranchOut()
+		if i == 0 {
+			res.Out = out
+		} else if out.Width != res.Out.Width || out.Height != res.Out.Height {
+			return nil, fmt.Errorf("branch output size mismatch: %dx%d vs %dx%d",
+				out.Width, out.Height, res.Out.Width, res.Out.Height)
+		} else {
+			res.Out.Depth += out.Depth
+		}
+		res.Branches = append(res.Branches, branch.Children)
+	}
+	return res, nil
+}
+
+// Type returns "Concat".
+func (c *Concat) Type() string {
+	return "Concat"
+}
+
+// OutDims returns the output dimensions.
+func (c *Concat) OutDims() Dims {
+	return c.Out
+}
+
+// Branch is a meta-block for Concat blocks.
+type Branch struct {
+	Children []Block
+	In       Dims
+}
+
+// CreateBranch creates a *Branch block.
+func CreateBranch(in Dims, attr map[string]float64, children []Block) (Block, error) {
+	if err := hasAllAndOnlyInts(attr, 0); err != nil {
+		return nil, err
+	}
+	return &Branch{
+		Children: children,
+		In:       in,
+	}, nil
+}
+
+// Type returns "Branch".
+func (b *Branch) Type() string {
+	return "Branch"
+}
+
+// OutDims returns the branch's input dimensions so that
+// every branch in a Concat receives the same input.
+func (b *Branch) OutDims() Dims {
+	return b.In
+}
+
+// BranchOut returns the output dimensions of the branch's
+// contents.
+func (b *Branch) BranchOut() Dims {
+	if len(b.Children) == 0 {
+		return b.In
+	}
+	return b.Children[len(b.Children)-1].OutDims()
+}
+
 // FC is a fully-connected layer.
 type FC struct {
 	OutCount int
@@ -559,7 +840,7 @@ func CreateDropout(in Dims, attr map[string]float64, children []Block) (Block, e
 		In:   in,
 	}
 	if res.Prob < 0 || res.Prob > 1 {
-		return nil, errors.New("attribute prob must be between 0 and 1")
+		return nil, attrError("prob", "attribute prob must be between 0 and 1")
 	}
 	return res, nil
 }
@@ -652,7 +933,7 @@ func onlyTheseAttrs(attrs map[string]float64, allowed ...string) error {
 			}
 		}
 		if !has {
-			return errors.New("unexpected attribute: " + a)
+			return attrError(a, "unexpected attribute: %s", a)
 		}
 	}
 	return nil
@@ -672,9 +953,9 @@ func validInt(attrs map[string]float64, min int, names ...string) error {
 		val, ok := attrs[name]
 		if ok {
 			if val != float64(int(val)) {
-				return errors.New("attribute " + name + " must be integer")
+				return attrError(name, "attribute %s must be integer", name)
 			} else if int(val) < min {
-				return fmt.Errorf("attribute %s cannot be %d", name, int(val))
+				return attrError(name, "attribute %s cannot be %d", name, int(val))
 			}
 		}
 	}
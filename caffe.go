@@ -0,0 +1,878 @@
+package convmarkup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadCaffe reads a Caffe network definition in prototxt
+// format and converts it into a root ASTNode of built-in
+// blocks.
+// The result can be validated with ASTNode.Block.
+//
+// The network must have a single 4D input, given either by
+// an Input layer or by the input fields of the network.
+// Layers which modify their input in place are supported.
+//
+// Convolution becomes a Conv, and its padding becomes a
+// Padding block before the Conv.
+// Pooling becomes a MaxPool or MeanPool, InnerProduct
+// becomes an FC, Deconvolution becomes a Deconv, Power
+// becomes a Linear, and a BatchNorm followed by a Scale
+// becomes a single BatchNorm.
+// Branches which are merged by an Eltwise sum become
+// Residual blocks.
+// If neither branch is the identity, the shorter branch
+// becomes the Projection.
+//
+// By default, Caffe rounds pooling output sizes up, while
+// MaxPool and MeanPool round down.
+// Pooling layers with round_mode CEIL (the default) are
+// therefore only supported when their windows tile the
+// input, and an error is returned otherwise.
+func ReadCaffe(r io.Reader) (*ASTNode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	net, err := parsePtxt(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Caffe import: %w", err)
+	}
+	c := newCaffeImporter(net)
+	root, err := c.root()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkCeilPools(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// WriteCaffe converts a root ASTNode into a Caffe network
+// definition in prototxt format.
+//
+// The tree must start with an Input block, and it may only
+// use built-in blocks.
+// Padding blocks must be symmetric and must come right
+// before a Conv, since they become the padding of the
+// Convolution layer.
+// Conv blocks with same=1 are only supported when the
+// padding is symmetric.
+// Pooling layers use round_mode FLOOR to match the output
+// sizes of MaxPool and MeanPool.
+// Residual becomes an Eltwise sum, and the contents of a
+// Repeat are written once per copy.
+// Assert and Debug blocks are omitted.
+//
+// This is the inverse of ReadCaffe.
+func WriteCaffe(w io.Writer, root *ASTNode) error {
+	c := &caffeWriter{names: nameCounter{}}
+	x, err := c.seq(root.Children, "")
+	if err != nil {
+		return err
+	}
+	if x == "" {
+		return errors.New("Caffe export: missing Input block")
+	}
+	_, err = io.WriteString(w, c.net.format(0))
+	return err
+}
+
+// A caffeLayer is a layer in a Caffe network.
+//
+// Since layers may operate in place, the bottoms and tops
+// are renamed so that every value has exactly one
+// producer.
+type caffeLayer struct {
+	Name    string
+	Type    string
+	Bottoms []string
+	Tops    []string
+	Params  *ptxtMessage
+}
+
+type caffeImporter struct {
+	net       *ptxtMessage
+	layers    []*caffeLayer
+	producers map[string]*caffeLayer
+	consumers map[string][]*caffeLayer
+	index     map[*caffeLayer]int
+
+	// ceilPools maps pooling nodes which round their output
+	// sizes up to the names of their layers.
+	ceilPools map[*ASTNode]string
+}
+
+func newCaffeImporter(net *ptxtMessage) *caffeImporter {
+	return &caffeImporter{
+		net:       net,
+		producers: map[string]*caffeLayer{},
+		consumers: map[string][]*caffeLayer{},
+		index:     map[*caffeLayer]int{},
+		ceilPools: map[*ASTNode]string{},
+	}
+}
+
+func (c *caffeImporter) root() (*ASTNode, error) {
+	if len(c.net.messages("layers")) > 0 {
+		return nil, errors.New("Caffe import: V1 layers are not supported")
+	}
+
+	// Maps blob names to the current value of each blob.
+	current := map[string]string{}
+
+	var inputName string
+	var inputShape []string
+	if inputs := c.net.getAll("input"); len(inputs) > 0 {
+		if len(inputs) > 1 {
+			return nil, fmt.Errorf("Caffe import: expected 1 input but got %d",
+				len(inputs))
+		}
+		inputName = inputs[0]
+		inputShape = c.net.getAll("input_dim")
+		if len(inputShape) == 0 {
+			inputShape = c.net.message("input_shape").getAll("dim")
+		}
+	}
+	for i, msg := range c.net.messages("layer") {
+		layer := &caffeLayer{
+			Name:   msg.get("name", ""),
+			Type:   msg.get("type", ""),
+			Params: msg,
+		}
+		if layer.Name == "" {
+			layer.Name = "#" + strconv.Itoa(i)
+		}
+		if layer.Type == "Input" {
+			tops := msg.getAll("top")
+			if inputName != "" || len(tops) != 1 {
+				return nil, errors.New("Caffe import: expected 1 input")
+			}
+			inputName = tops[0]
+			inputShape = msg.message("input_param").message("shape").getAll("dim")
+			continue
+		}
+		for _, bottom := range msg.getAll("bottom") {
+			value, ok := current[bottom]
+			if !ok && bottom == inputName {
+				value = bottom
+			} else if !ok {
+				return nil, fmt.Errorf("Caffe import: layer %s: unknown bottom: %s",
+					layer.Name, bottom)
+			}
+			layer.Bottoms = append(layer.Bottoms, value)
+			c.consumers[value] = append(c.consumers[value], layer)
+		}
+		for _, top := range msg.getAll("top") {
+			value := top + "#" + strconv.Itoa(i)
+			current[top] = value
+			layer.Tops = append(layer.Tops, value)
+			c.producers[value] = layer
+		}
+		c.index[layer] = len(c.layers)
+		c.layers = append(c.layers, layer)
+	}
+
+	if inputName == "" {
+		return nil, errors.New("Caffe import: missing input")
+	}
+	dims, err := caffeInts(inputShape)
+	if err != nil || len(dims) != 4 {
+		return nil, fmt.Errorf("Caffe import: input %s must have shape NxCxHxW",
+			inputName)
+	}
+	input := newASTNode("Input", map[string]float64{
+		"w": float64(dims[3]),
+		"h": float64(dims[2]),
+		"d": float64(dims[1]),
+	})
+	children, err := c.seq(inputName, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return &ASTNode{Children: append([]*ASTNode{input}, children...)}, nil
+}
+
+// checkCeilPools ensures that every pooling layer which
+// rounds its output size up has windows which tile its
+// input, so that the output size is the same when rounding
+// down.
+func (c *caffeImporter) checkCeilPools(root *ASTNode) error {
+	if len(c.ceilPools) == 0 {
+		return nil
+	}
+	block, src, err := root.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		return fmt.Errorf("Caffe import: cannot check pooling sizes: %w", err)
+	}
+	return Walk(block, Dims{}, func(step WalkStep) error {
+		pool, ok := step.Block.(*Pool)
+		if !ok {
+			return nil
+		}
+		name, ok := c.ceilPools[src.Node(pool)]
+		if !ok {
+			return nil
+		}
+		if step.In.Width < pool.Width || step.In.Height < pool.Height ||
+			(step.In.Width-pool.Width)%pool.StrideX != 0 ||
+			(step.In.Height-pool.Height)%pool.StrideY != 0 {
+			return fmt.Errorf("Caffe import: layer %s: pooling windows do not tile "+
+				"the input, so round_mode CEIL is not supported", name)
+		}
+		return nil
+	})
+}
+
+// seq converts the chain of layers which lead from the
+// value start to the value end.
+// If end is "", the chain continues until a value is not
+// used by any layer.
+//
+// If first is non-nil, it is the first layer in the chain.
+// Otherwise, start must be used by exactly one layer, or
+// by exactly two layers which merge at an Eltwise sum.
+func (c *caffeImporter) seq(start string, first *caffeLayer,
+	end string) ([]*ASTNode, error) {
+	var res []*ASTNode
+	for v := start; v != end; {
+		layer := first
+		first = nil
+		if layer == nil {
+			consumers := c.consumers[v]
+			switch len(consumers) {
+			case 0:
+				if end == "" {
+					return res, nil
+				}
+				return nil, fmt.Errorf("Caffe import: blob %s does not reach %s",
+					caffeBlob(v), caffeBlob(end))
+			case 1:
+				layer = consumers[0]
+			case 2:
+				residual, out, err := c.residual(v, consumers)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, residual)
+				v = out
+				continue
+			default:
+				return nil, fmt.Errorf("Caffe import: blob %s is used by %d layers",
+					caffeBlob(v), len(consumers))
+			}
+		}
+		if c.isMerge(layer) {
+			return nil, fmt.Errorf("Caffe import: layer %s: unexpected merge", layer.Name)
+		}
+		if len(layer.Bottoms) != 1 || len(layer.Tops) != 1 {
+			return nil, fmt.Errorf("Caffe import: layer %s: expected 1 bottom and 1 top",
+				layer.Name)
+		}
+		var err error
+		res, err = c.convert(layer, res)
+		if err != nil {
+			return nil, fmt.Errorf("Caffe import: layer %s: %w", layer.Name, err)
+		}
+		v = layer.Tops[0]
+	}
+	return res, nil
+}
+
+// residual converts two branches which split from the
+// value v and merge at an Eltwise sum.
+// It returns the Residual node and the output of the sum.
+func (c *caffeImporter) residual(v string, consumers []*caffeLayer) (*ASTNode,
+	string, error) {
+	merge := c.mergeLayer(consumers[0], consumers[1])
+	if merge == nil || !c.isMerge(merge) {
+		return nil, "", fmt.Errorf("Caffe import: blob %s splits into branches "+
+			"which are not merged by an Eltwise sum", caffeBlob(v))
+	}
+
+	var branches [2][]*ASTNode
+	var identity [2]bool
+	usedFirst := map[*caffeLayer]bool{}
+	for i, bottom := range merge.Bottoms {
+		if bottom == v {
+			identity[i] = true
+			continue
+		}
+		var first *caffeLayer
+		for _, layer := range consumers {
+			if layer != merge && c.reachable(layer)[c.producers[bottom]] {
+				if first != nil {
+					return nil, "", fmt.Errorf("Caffe import: layer %s: both bottoms "+
+						"depend on the same branch", merge.Name)
+				}
+				first = layer
+			}
+		}
+		if first == nil || usedFirst[first] {
+			return nil, "", fmt.Errorf("Caffe import: layer %s: bottoms do not come "+
+				"from separate branches", merge.Name)
+		}
+		usedFirst[first] = true
+		var err error
+		branches[i], err = c.seq(v, first, bottom)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var projection, body []*ASTNode
+	switch {
+	case identity[0] && identity[1]:
+		return nil, "", fmt.Errorf("Caffe import: layer %s: adding a blob to "+
+			"itself is not supported", merge.Name)
+	case identity[0]:
+		body = branches[1]
+	case identity[1]:
+		body = branches[0]
+	case len(branches[1]) < len(branches[0]):
+		projection, body = branches[1], branches[0]
+	default:
+		projection, body = branches[0], branches[1]
+	}
+
+	res := newASTNode("Residual", nil)
+	if projection != nil {
+		proj := newASTNode("Projection", nil)
+		proj.Children = projection
+		res.Children = append(res.Children, proj)
+	}
+	res.Children = append(res.Children, body...)
+	return res, merge.Tops[0], nil
+}
+
+// isMerge checks if a layer is an unweighted sum of two
+// blobs.
+func (c *caffeImporter) isMerge(layer *caffeLayer) bool {
+	if layer.Type != "Eltwise" || len(layer.Bottoms) != 2 || len(layer.Tops) != 1 {
+		return false
+	}
+	param := layer.Params.message("eltwise_param")
+	if param.get("operation", "SUM") != "SUM" {
+		return false
+	}
+	for _, coeff := range param.getAll("coeff") {
+		if x, err := strconv.ParseFloat(coeff, 64); err != nil || x != 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// reachable finds every layer which depends on a layer,
+// including the layer itself.
+func (c *caffeImporter) reachable(layer *caffeLayer) map[*caffeLayer]bool {
+	res := map[*caffeLayer]bool{}
+	queue := []*caffeLayer{layer}
+	for len(queue) > 0 {
+		l := queue[0]
+		queue = queue[1:]
+		if res[l] {
+			continue
+		}
+		res[l] = true
+		for _, top := range l.Tops {
+			queue = append(queue, c.consumers[top]...)
+		}
+	}
+	return res
+}
+
+// mergeLayer finds the first layer which depends on both
+// of the given layers.
+func (c *caffeImporter) mergeLayer(l1, l2 *caffeLayer) *caffeLayer {
+	reach1 := c.reachable(l1)
+	var res *caffeLayer
+	for l := range c.reachable(l2) {
+		if reach1[l] && (res == nil || c.index[l] < c.index[res]) {
+			res = l
+		}
+	}
+	return res
+}
+
+// convert converts a layer in a chain, appending any new
+// ASTNodes to res.
+func (c *caffeImporter) convert(layer *caffeLayer, res []*ASTNode) ([]*ASTNode,
+	error) {
+	switch layer.Type {
+	case "Convolution":
+		param := layer.Params.message("convolution_param")
+		attrs, err := caffeConvAttrs(param)
+		if err != nil {
+			return nil, err
+		}
+		dilation, err := caffeInts(param.getAll("dilation"))
+		if err != nil {
+			return nil, err
+		}
+		switch len(dilation) {
+		case 0:
+		case 1:
+			setIfNot(attrs, "dy", dilation[0], 1)
+			setIfNot(attrs, "dx", dilation[0], 1)
+		case 2:
+			setIfNot(attrs, "dy", dilation[0], 1)
+			setIfNot(attrs, "dx", dilation[1], 1)
+		default:
+			return nil, errors.New("only 2D dilation is supported")
+		}
+		group, err := caffeInt(param.get("group", "1"))
+		if err != nil {
+			return nil, err
+		}
+		setIfNot(attrs, "groups", group, 1)
+
+		padH, padW, err := caffeSize(param, "pad", 0)
+		if err != nil {
+			return nil, err
+		}
+		if padH != 0 || padW != 0 {
+			res = append(res, newASTNode("Padding", map[string]float64{
+				"t": float64(padH),
+				"r": float64(padW),
+				"b": float64(padH),
+				"l": float64(padW),
+			}))
+		}
+		return append(res, newASTNode("Conv", attrs)), nil
+	case "Deconvolution":
+		param := layer.Params.message("convolution_param")
+		if padH, padW, err := caffeSize(param, "pad", 0); err != nil {
+			return nil, err
+		} else if padH != 0 || padW != 0 {
+			return nil, errors.New("padding is not supported")
+		}
+		if param.get("group", "1") != "1" {
+			return nil, errors.New("groups are not supported")
+		}
+		for _, d := range param.getAll("dilation") {
+			if d != "1" {
+				return nil, errors.New("dilation is not supported")
+			}
+		}
+		attrs, err := caffeConvAttrs(param)
+		if err != nil {
+			return nil, err
+		}
+		return append(res, newASTNode("Deconv", attrs)), nil
+	case "Pooling":
+		param := layer.Params.message("pooling_param")
+		name := "MaxPool"
+		switch param.get("pool", "MAX") {
+		case "MAX":
+		case "AVE":
+			name = "MeanPool"
+		default:
+			return nil, fmt.Errorf("unsupported pooling method: %s", param.get("pool", ""))
+		}
+		if padH, padW, err := caffeSize(param, "pad", 0); err != nil {
+			return nil, err
+		} else if padH != 0 || padW != 0 {
+			return nil, errors.New("padding is not supported")
+		}
+		if param.get("global_pooling", "false") == "true" {
+			return append(res, newASTNode(name, nil)), nil
+		}
+		h, w, err := caffeSize(param, "kernel", 0)
+		if err != nil {
+			return nil, err
+		} else if h == 0 || w == 0 {
+			return nil, errors.New("missing kernel size")
+		}
+		sy, sx, err := caffeSize(param, "stride", 1)
+		if err != nil {
+			return nil, err
+		}
+		attrs := map[string]float64{"w": float64(w), "h": float64(h)}
+		setIfNot(attrs, "sx", sx, w)
+		setIfNot(attrs, "sy", sy, h)
+		node := newASTNode(name, attrs)
+		switch mode := param.get("round_mode", "CEIL"); mode {
+		case "FLOOR":
+		case "CEIL":
+			c.ceilPools[node] = layer.Name
+		default:
+			return nil, fmt.Errorf("unsupported round mode: %s", mode)
+		}
+		return append(res, node), nil
+	case "InnerProduct":
+		out, err := caffeInt(layer.Params.message("inner_product_param").
+			get("num_output", ""))
+		if err != nil {
+			return nil, err
+		}
+		return append(res, newASTNode("FC", map[string]float64{"out": float64(out)})),
+			nil
+	case "Power":
+		param := layer.Params.message("power_param")
+		var vals [3]float64
+		for i, name := range []string{"power", "scale", "shift"} {
+			def := "1"
+			if name == "shift" {
+				def = "0"
+			}
+			x, err := strconv.ParseFloat(param.get(name, def), 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s: %s", name, param.get(name, def))
+			}
+			vals[i] = x
+		}
+		if vals[0] != 1 {
+			return nil, errors.New("only power 1 is supported")
+		}
+		attrs := map[string]float64{}
+		setIfNot(attrs, "scale", vals[1], 1)
+		setIfNot(attrs, "bias", vals[2], 0)
+		return append(res, newASTNode("Linear", attrs)), nil
+	case "Dropout":
+		ratio := layer.Params.message("dropout_param").get("dropout_ratio", "0.5")
+		prob, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad dropout_ratio: %s", ratio)
+		}
+		return append(res, newASTNode("Dropout", map[string]float64{"prob": prob})),
+			nil
+	case "BatchNorm":
+		return append(res, newASTNode("BatchNorm", nil)), nil
+	case "Scale":
+		if len(res) == 0 || res[len(res)-1].BlockName != "BatchNorm" {
+			return nil, errors.New("Scale is only supported after BatchNorm")
+		}
+		return res, nil
+	case "ReLU":
+		slope := layer.Params.message("relu_param").get("negative_slope", "0")
+		if x, err := strconv.ParseFloat(slope, 64); err != nil || x != 0 {
+			return nil, errors.New("negative_slope is not supported")
+		}
+		return append(res, newASTNode("ReLU", nil)), nil
+	case "Sigmoid":
+		return append(res, newASTNode("Sigmoid", nil)), nil
+	case "TanH":
+		return append(res, newASTNode("Tanh", nil)), nil
+	case "Softmax":
+		if layer.Params.message("softmax_param").get("axis", "1") != "1" {
+			return nil, errors.New("softmax must use axis 1")
+		}
+		return append(res, newASTNode("Softmax", nil)), nil
+	}
+	return nil, fmt.Errorf("unsupported layer type: %s", layer.Type)
+}
+
+// caffeConvAttrs finds the Conv or Deconv attributes for
+// the kernel size, output count, and stride of a
+// convolution_param.
+func caffeConvAttrs(param *ptxtMessage) (map[string]float64, error) {
+	n, err := caffeInt(param.get("num_output", ""))
+	if err != nil {
+		return nil, err
+	}
+	h, w, err := caffeSize(param, "kernel", 0)
+	if err != nil {
+		return nil, err
+	} else if h == 0 || w == 0 {
+		return nil, errors.New("missing kernel size")
+	}
+	sy, sx, err := caffeSize(param, "stride", 1)
+	if err != nil {
+		return nil, err
+	}
+	attrs := map[string]float64{"w": float64(w), "h": float64(h), "n": float64(n)}
+	setIfNot(attrs, "sx", sx, 1)
+	setIfNot(attrs, "sy", sy, 1)
+	return attrs, nil
+}
+
+// caffeSize reads a 2D size from a layer parameter, where
+// name is "kernel", "stride", or "pad".
+//
+// The size may be given by the fields name_h and name_w,
+// or by one or two values of a repeated field.
+// The repeated field for the kernel is named kernel_size.
+func caffeSize(param *ptxtMessage, name string, def int) (h, w int, err error) {
+	if param.has(name+"_h") || param.has(name+"_w") {
+		h, err = caffeInt(param.get(name+"_h", strconv.Itoa(def)))
+		if err != nil {
+			return
+		}
+		w, err = caffeInt(param.get(name+"_w", strconv.Itoa(def)))
+		return
+	}
+	field := name
+	if name == "kernel" {
+		field = "kernel_size"
+	}
+	vals, err := caffeInts(param.getAll(field))
+	if err != nil {
+		return
+	}
+	switch len(vals) {
+	case 0:
+		return def, def, nil
+	case 1:
+		return vals[0], vals[0], nil
+	case 2:
+		return vals[0], vals[1], nil
+	}
+	return 0, 0, fmt.Errorf("only 2D %s is supported", field)
+}
+
+func caffeInt(s string) (int, error) {
+	x, err := strconv.Atoi(s)
+	if err != nil || x < 0 {
+		return 0, fmt.Errorf("bad integer: %q", s)
+	}
+	return x, nil
+}
+
+func caffeInts(strs []string) ([]int, error) {
+	res := make([]int, len(strs))
+	for i, s := range strs {
+		var err error
+		if res[i], err = caffeInt(s); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// caffeBlob gets the original name of a renamed value.
+func caffeBlob(v string) string {
+	if i := strings.LastIndexByte(v, '#'); i >= 0 {
+		return v[:i]
+	}
+	return v
+}
+
+// caffeWriter builds a Caffe network from an ASTNode.
+type caffeWriter struct {
+	net   ptxtMessage
+	names nameCounter
+}
+
+// layer adds a layer, returning its parameters and the
+// name of its top blob.
+func (c *caffeWriter) layer(prefix, layerType string, bottoms ...string) (*ptxtMessage,
+	string) {
+	name := c.names.next(prefix)
+	msg := c.net.add("layer")
+	msg.setString("name", name)
+	msg.setString("type", layerType)
+	for _, b := range bottoms {
+		msg.setString("bottom", b)
+	}
+	msg.setString("top", name)
+	return msg, name
+}
+
+// seq writes a sequence of nodes which receive the blob x,
+// returning the output blob.
+func (c *caffeWriter) seq(nodes []*ASTNode, x string) (string, error) {
+	var padH, padW int
+	for i, node := range nodes {
+		if node.BlockName == "Padding" {
+			a := node.Attrs
+			if a["t"] != a["b"] || a["l"] != a["r"] {
+				return "", fmt.Errorf("Caffe export: %s: padding must be symmetric",
+					node.position())
+			} else if i+1 == len(nodes) || nodes[i+1].BlockName != "Conv" {
+				return "", fmt.Errorf("Caffe export: %s: Padding must come before a Conv",
+					node.position())
+			}
+			padH += int(a["t"])
+			padW += int(a["l"])
+			continue
+		}
+		if x == "" && node.BlockName != "Input" {
+			return "", fmt.Errorf("Caffe export: %s: missing Input block before %s",
+				node.position(), node.BlockName)
+		}
+		var err error
+		x, err = c.node(node, x, padH, padW)
+		if err != nil {
+			return "", fmt.Errorf("Caffe export: %s: %w", node.position(), err)
+		}
+		padH, padW = 0, 0
+	}
+	return x, nil
+}
+
+// node writes a node which receives the blob x, returning
+// the output blob.
+// The padH and padW arguments are the padding from the
+// preceding Padding blocks.
+func (c *caffeWriter) node(node *ASTNode, x string, padH, padW int) (string, error) {
+	a := node.Attrs
+	switch node.BlockName {
+	case "Input":
+		if x != "" {
+			return "", errors.New("unexpected Input block")
+		}
+		msg, top := c.layer("input", "Input")
+		shape := msg.add("input_param").add("shape")
+		for _, d := range []float64{1, a["d"], a["h"], a["w"]} {
+			shape.setInt("dim", int(d))
+		}
+		return top, nil
+	case "Assert", "Debug":
+		return x, nil
+	case "Conv":
+		dx, dy := caffeAttr(a, "dx", 1), caffeAttr(a, "dy", 1)
+		padH += int(a["p"])
+		padW += int(a["p"])
+		if a["same"] != 0 {
+			if caffeAttr(a, "sx", 1) != 1 || caffeAttr(a, "sy", 1) != 1 {
+				return "", errors.New("same padding with strides is not supported")
+			}
+			spanH := dy * (int(a["h"]) - 1)
+			spanW := dx * (int(a["w"]) - 1)
+			if spanH%2 != 0 || spanW%2 != 0 {
+				return "", errors.New("same padding must be symmetric")
+			}
+			padH += spanH / 2
+			padW += spanW / 2
+		}
+		msg, top := c.layer("conv", "Convolution", x)
+		param := msg.add("convolution_param")
+		caffeConvParam(param, a)
+		caffeSizeParam(param, "pad", padH, padW, 0)
+		if dx != 1 || dy != 1 {
+			param.setInt("dilation", dy)
+			if dx != dy {
+				param.setInt("dilation", dx)
+			}
+		}
+		if groups := caffeAttr(a, "groups", 1); groups != 1 {
+			param.setInt("group", groups)
+		}
+		return top, nil
+	case "Deconv":
+		if a["ox"] != 0 || a["oy"] != 0 {
+			return "", errors.New("output padding is not supported")
+		}
+		msg, top := c.layer("deconv", "Deconvolution", x)
+		caffeConvParam(msg.add("convolution_param"), a)
+		return top, nil
+	case "MaxPool", "MeanPool":
+		msg, top := c.layer("pool", "Pooling", x)
+		param := msg.add("pooling_param")
+		if node.BlockName == "MaxPool" {
+			param.set("pool", "MAX")
+		} else {
+			param.set("pool", "AVE")
+		}
+		if a["w"] == 0 && a["h"] == 0 && a["sx"] == 0 && a["sy"] == 0 {
+			param.set("global_pooling", "true")
+			return top, nil
+		} else if a["w"] == 0 || a["h"] == 0 {
+			return "", errors.New("pool size must be specified for both axes")
+		}
+		w, h := int(a["w"]), int(a["h"])
+		caffeSizeParam(param, "kernel", h, w, -1)
+		caffeSizeParam(param, "stride", caffeAttr(a, "sy", h), caffeAttr(a, "sx", w), 1)
+		param.set("round_mode", "FLOOR")
+		return top, nil
+	case "FC":
+		msg, top := c.layer("fc", "InnerProduct", x)
+		msg.add("inner_product_param").setInt("num_output", int(a["out"]))
+		return top, nil
+	case "Residual":
+		skip := x
+		var body []*ASTNode
+		for _, child := range node.Children {
+			if child.BlockName == "Projection" {
+				var err error
+				if skip, err = c.seq(child.Children, x); err != nil {
+					return "", err
+				}
+			} else {
+				body = append(body, child)
+			}
+		}
+		out, err := c.seq(body, x)
+		if err != nil {
+			return "", err
+		}
+		msg, top := c.layer("sum", "Eltwise", out, skip)
+		msg.add("eltwise_param").set("operation", "SUM")
+		return top, nil
+	case "Repeat":
+		for i := 0; i < int(a["n"]); i++ {
+			var err error
+			if x, err = c.seq(node.Children, x); err != nil {
+				return "", err
+			}
+		}
+		return x, nil
+	case "Linear":
+		msg, top := c.layer("linear", "Power", x)
+		param := msg.add("power_param")
+		if scale, ok := a["scale"]; ok && scale != 1 {
+			param.set("scale", formatNumber(scale))
+		}
+		if a["bias"] != 0 {
+			param.set("shift", formatNumber(a["bias"]))
+		}
+		return top, nil
+	case "Dropout":
+		msg, top := c.layer("dropout", "Dropout", x)
+		msg.add("dropout_param").set("dropout_ratio", formatNumber(a["prob"]))
+		return top, nil
+	case "BatchNorm":
+		_, top := c.layer("bn", "BatchNorm", x)
+		msg, top := c.layer("scale", "Scale", top)
+		msg.add("scale_param").set("bias_term", "true")
+		return top, nil
+	case "ReLU", "Sigmoid", "Softmax":
+		_, top := c.layer(strings.ToLower(node.BlockName), node.BlockName, x)
+		return top, nil
+	case "Tanh":
+		_, top := c.layer("tanh", "TanH", x)
+		return top, nil
+	}
+	return "", fmt.Errorf("unsupported block: %s", node.BlockName)
+}
+
+// caffeConvParam sets the output count, kernel size, and
+// stride of a convolution_param.
+func caffeConvParam(param *ptxtMessage, a map[string]float64) {
+	param.setInt("num_output", int(a["n"]))
+	caffeSizeParam(param, "kernel", int(a["h"]), int(a["w"]), -1)
+	caffeSizeParam(param, "stride", caffeAttr(a, "sy", 1), caffeAttr(a, "sx", 1), 1)
+}
+
+// caffeSizeParam sets a 2D size in a layer parameter, as
+// read by caffeSize.
+// Nothing is set if both values are def.
+func caffeSizeParam(param *ptxtMessage, name string, h, w, def int) {
+	if h == def && w == def {
+		return
+	}
+	if h == w {
+		field := name
+		if name == "kernel" {
+			field = "kernel_size"
+		}
+		param.setInt(field, h)
+	} else {
+		param.setInt(name+"_h", h)
+		param.setInt(name+"_w", w)
+	}
+}
+
+// caffeAttr gets an integer attribute with a default.
+func caffeAttr(a map[string]float64, name string, def int) int {
+	if x, ok := a[name]; ok {
+		return int(x)
+	}
+	return def
+}
@@ -0,0 +1,345 @@
+package convmarkup
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCaffe(t *testing.T) {
+	prototxt := `name: "test"
+input: "data"
+input_shape { dim: 1 dim: 3 dim: 8 dim: 8 }
+layer {
+  name: "conv1"
+  type: "Convolution"
+  bottom: "data"
+  top: "conv1"
+  convolution_param { num_output: 4 kernel_size: 3 pad: 1 }
+}
+layer { name: "bn1" type: "BatchNorm" bottom: "conv1" top: "conv1" }
+layer {
+  name: "scale1" type: "Scale" bottom: "conv1" top: "conv1"
+  scale_param { bias_term: true }
+}
+layer { name: "relu1" type: "ReLU" bottom: "conv1" top: "conv1" }
+# A residual block with a projection.
+layer {
+  name: "proj"
+  type: "Convolution"
+  bottom: "conv1"
+  top: "proj"
+  convolution_param { num_output: 8 kernel_size: 1 stride: 2 }
+}
+layer {
+  name: "conv2"
+  type: "Convolution"
+  bottom: "conv1"
+  top: "conv2"
+  convolution_param {
+    num_output: 8
+    kernel_h: 3
+    kernel_w: 1
+    stride: 2
+    pad_h: 1
+    pad_w: 0
+    dilation: 1
+    group: 2
+  }
+}
+layer { name: "tanh2" type: "TanH" bottom: "conv2" top: "conv2" }
+layer {
+  name: "sum2" type: "Eltwise" bottom: "proj" bottom: "conv2" top: "sum2"
+  eltwise_param { operation: SUM }
+}
+# A residual block with an identity connection.
+layer { name: "sigmoid3" type: "Sigmoid" bottom: "sum2" top: "sig3" }
+layer { name: "sum3" type: "Eltwise" bottom: "sum2" bottom: "sig3" top: "sum3" }
+layer {
+  name: "pool4" type: "Pooling" bottom: "sum3" top: "pool4"
+  pooling_param { pool: MAX kernel_size: 2 stride: 1 }
+}
+layer {
+  name: "linear4" type: "Power" bottom: "pool4" top: "pool4"
+  power_param { scale: 2 shift: -1 }
+}
+layer {
+  name: "pool5" type: "Pooling" bottom: "pool4" top: "pool5"
+  pooling_param { pool: AVE global_pooling: true }
+}
+layer {
+  name: "drop5" type: "Dropout" bottom: "pool5" top: "pool5"
+  dropout_param { dropout_ratio: 0.25 }
+}
+layer {
+  name: "fc6" type: "InnerProduct" bottom: "pool5" top: "fc6"
+  inner_product_param { num_output: 10 }
+}
+layer { name: "prob" type: "Softmax" bottom: "fc6" top: "prob" }
+`
+	expected := `Input(w=8, h=8, d=3)
+Padding(t=1, r=1, b=1, l=1)
+Conv(w=3, h=3, n=4)
+BatchNorm
+ReLU
+Residual {
+    Projection {
+        Conv(w=1, h=1, n=8, sx=2, sy=2)
+    }
+    Padding(t=1, r=0, b=1, l=0)
+    Conv(w=1, h=3, n=8, sx=2, sy=2, groups=2)
+    Tanh
+}
+Residual {
+    Sigmoid
+}
+MaxPool(w=2, h=2, sx=1, sy=1)
+Linear(scale=2, bias=-1)
+MeanPool
+Dropout(prob=0.25)
+FC(out=10)
+Softmax
+`
+	root, err := ReadCaffe(strings.NewReader(prototxt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(root); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	if _, err := root.Block(Dims{}, DefaultCreators()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteCaffe(t *testing.T) {
+	markup := `Input(w=8, h=8, d=3)
+Conv(w=3, h=3, n=4, p=1)
+Repeat(n=2) {
+	Residual {
+		Conv(w=3, h=3, n=4, same=1)
+		BatchNorm
+	}
+}
+Padding(t=0, r=1, b=0, l=1)
+Conv(w=3, h=1, n=4, dx=2, dy=2, sy=2)
+Tanh
+MeanPool
+FC(out=2)
+`
+	expected := `layer {
+  name: "input0"
+  type: "Input"
+  top: "input0"
+  input_param {
+    shape {
+      dim: 1
+      dim: 3
+      dim: 8
+      dim: 8
+    }
+  }
+}
+layer {
+  name: "conv0"
+  type: "Convolution"
+  bottom: "input0"
+  top: "conv0"
+  convolution_param {
+    num_output: 4
+    kernel_size: 3
+    pad: 1
+  }
+}
+layer {
+  name: "conv1"
+  type: "Convolution"
+  bottom: "conv0"
+  top: "conv1"
+  convolution_param {
+    num_output: 4
+    kernel_size: 3
+    pad: 1
+  }
+}
+layer {
+  name: "bn0"
+  type: "BatchNorm"
+  bottom: "conv1"
+  top: "bn0"
+}
+layer {
+  name: "scale0"
+  type: "Scale"
+  bottom: "bn0"
+  top: "scale0"
+  scale_param {
+    bias_term: true
+  }
+}
+layer {
+  name: "sum0"
+  type: "Eltwise"
+  bottom: "scale0"
+  bottom: "conv0"
+  top: "sum0"
+  eltwise_param {
+    operation: SUM
+  }
+}
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCaffe(&buf, parsed); err != nil {
+		t.Fatal(err)
+	}
+	if actual := buf.String(); !strings.HasPrefix(actual, expected) {
+		t.Errorf("expected prefix:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	expectedRoundTrip := `Input(w=8, h=8, d=3)
+Padding(t=1, r=1, b=1, l=1)
+Conv(w=3, h=3, n=4)
+Residual {
+    Padding(t=1, r=1, b=1, l=1)
+    Conv(w=3, h=3, n=4)
+    BatchNorm
+}
+Residual {
+    Padding(t=1, r=1, b=1, l=1)
+    Conv(w=3, h=3, n=4)
+    BatchNorm
+}
+Padding(t=0, r=1, b=0, l=1)
+Conv(w=3, h=1, n=4, sy=2, dx=2, dy=2)
+Tanh
+MeanPool
+FC(out=2)
+`
+	root, err := ReadCaffe(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(root); actual != expectedRoundTrip {
+		t.Errorf("expected:\n%s\ngot:\n%s", expectedRoundTrip, actual)
+	}
+}
+
+func TestCaffeErrors(t *testing.T) {
+	exportTests := map[string]string{
+		"Input(w=2, h=2, d=1)\nPadding(t=1, r=0, b=0, l=0)\nConv(w=1, h=1, n=1)": "Caffe export: line 2: padding must be symmetric",
+		"Input(w=2, h=2, d=1)\nPadding(t=1, r=1, b=1, l=1)\nReLU":                "Caffe export: line 2: Padding must come before a Conv",
+		"Input(w=2, h=2, d=1)\nConv(w=2, h=2, n=1, same=1)":                      "Caffe export: line 2: same padding must be symmetric",
+		"Input(w=2, h=2, d=1)\nResize(w=4, h=4)":                                 "Caffe export: line 2: unsupported block: Resize",
+		"ReLU":                                                                   "Caffe export: line 1: missing Input block before ReLU",
+	}
+	for markup, expected := range exportTests {
+		parsed, err := Parse(markup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = WriteCaffe(&bytes.Buffer{}, parsed)
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q but got %v", expected, err)
+		}
+	}
+
+	input := `input: "data" input_dim: 1 input_dim: 1 input_dim: 4 input_dim: 4` + "\n"
+	importTests := map[string]string{
+		`layer { name: "lrn" type: "LRN" bottom: "data" top: "lrn" }`: "Caffe import: layer lrn: unsupported layer type: LRN",
+		`layer { name: "a" type: "ReLU" bottom: "data" top: "a" }
+layer { name: "b" type: "ReLU" bottom: "data" top: "b" }
+layer { name: "c" type: "Concat" bottom: "a" bottom: "b" top: "c" }`: "Caffe import: blob data splits into branches which are not merged by an Eltwise sum",
+		`layer { name: "r" type: "ReLU" bottom: "foo" top: "r" }`: "Caffe import: layer r: unknown bottom: foo",
+		`layer { name: "r" type: "ReLU" bottom: "data" top: "r"`:  "Caffe import: line 2: missing }",
+		`layer { name: "p" type: "Pooling" bottom: "data" top: "p"
+	pooling_param { pool: MAX kernel_size: 3 stride: 3 } }`: "Caffe import: layer p: pooling windows do not tile the input, so round_mode CEIL is not supported",
+		`layer { name: "p" type: "Pooling" bottom: "data" top: "p"
+	pooling_param { pool: MAX kernel_size: 2 round_mode: UP } }`: "Caffe import: layer p: unsupported round mode: UP",
+	}
+	for prototxt, expected := range importTests {
+		_, err := ReadCaffe(strings.NewReader(input + prototxt))
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q but got %v", expected, err)
+		}
+	}
+}
+
+func TestPtxtStrings(t *testing.T) {
+	names := []string{`a"b`, `a\b`, "a'b\n", "\u00e9"}
+	msg := &ptxtMessage{}
+	for _, name := range names {
+		msg.setString("name", name)
+	}
+	parsed, err := parsePtxt(msg.format(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := parsed.getAll("name"); !reflect.DeepEqual(actual, names) {
+		t.Errorf("expected %q but got %q", names, actual)
+	}
+
+	parsed, err = parsePtxt(`name: 'a\'b"' top: "\x41\101" # "comment`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := parsed.get("name", ""); name != `a'b"` {
+		t.Errorf("unexpected name: %q", name)
+	}
+	if top := parsed.get("top", ""); top != "AA" {
+		t.Errorf("unexpected top: %q", top)
+	}
+
+	input := `input: "da\"ta" input_dim: 1 input_dim: 1 input_dim: 4 input_dim: 4
+layer { name: "r" type: "ReLU" bottom: "da\"ta" top: "r\\" }
+layer { name: "s" type: "Sigmoid" bottom: "r\\" top: "s" }
+`
+	root, err := ReadCaffe(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(root); actual != "Input(w=4, h=4, d=1)\nReLU\nSigmoid\n" {
+		t.Errorf("unexpected markup:\n%s", actual)
+	}
+	_, err = ReadCaffe(strings.NewReader(`input: "a\q"`))
+	if err == nil || err.Error() != "Caffe import: line 1: invalid string for input" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCaffePoolRounding(t *testing.T) {
+	parsed, err := Parse("Input(w=8, h=8, d=1)\nMaxPool(w=3, h=3)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteCaffe(&buf, parsed); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "round_mode: FLOOR") {
+		t.Errorf("missing round mode:\n%s", buf.String())
+	}
+	root, err := ReadCaffe(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(root); actual != "Input(w=8, h=8, d=1)\nMaxPool(w=3, h=3)\n" {
+		t.Errorf("unexpected round trip:\n%s", actual)
+	}
+
+	tiled := `input: "data" input_dim: 1 input_dim: 1 input_dim: 7 input_dim: 7
+layer { name: "p" type: "Pooling" bottom: "data" top: "p"
+	pooling_param { pool: AVE kernel_size: 3 stride: 2 } }
+`
+	root, err = ReadCaffe(strings.NewReader(tiled))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(root); actual != "Input(w=7, h=7, d=1)\nMeanPool(w=3, h=3, sx=2, sy=2)\n" {
+		t.Errorf("unexpected import:\n%s", actual)
+	}
+}
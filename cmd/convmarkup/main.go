@@ -0,0 +1,324 @@
+// Command convmarkup checks, formats, and inspects markup
+// files.
+//
+// Usage:
+//
+//	convmarkup validate [-input WxHxD] file...
+//	convmarkup fmt [-l] [file...]
+//	convmarkup summary [-input WxHxD] [-markdown] file
+//	convmarkup graph [-input WxHxD] [-unroll] file
+//
+// The validate command prints every error in the given
+// files, one per line, as "file:line:col: message".
+//
+// The fmt command rewrites files in canonical form, as
+// produced by convmarkup.FormatSource.
+// With -l, it lists the files which are not formatted
+// instead of rewriting them.
+// Without files, it formats standard input.
+//
+// The summary command prints a table of the blocks in a
+// file, as produced by convmarkup.Summarize, in plain text
+// or (with -markdown) Markdown.
+// The graph command prints a Graphviz diagram.
+//
+// The -input flag specifies the input dimensions for files
+// which do not start with an Input block.
+//
+// The exit status is 0 on success, 1 if a file has errors
+// or (with fmt -l) is not formatted, and 2 for usage and
+// I/O errors, so that the tool can be used in pre-commit
+// hooks.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/unixpickle/convmarkup"
+)
+
+const (
+	exitOK      = 0
+	exitProblem = 1
+	exitUsage   = 2
+)
+
+const usage = `Usage: convmarkup <command> [arguments]
+
+Commands:
+  validate  report every error in markup files
+  fmt       rewrite markup files in canonical form
+  summary   print a table of blocks and their shapes
+  graph     print a Graphviz diagram of a network
+
+Run "convmarkup <command> -h" for the arguments of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the tool and returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	c := &command{name: args[0], stdin: stdin, stdout: stdout, stderr: stderr}
+	switch args[0] {
+	case "validate":
+		return c.validate(args[1:])
+	case "fmt":
+		return c.fmt(args[1:])
+	case "summary":
+		return c.summary(args[1:])
+	case "graph":
+		return c.graph(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "convmarkup: unknown command: %s\n\n%s", args[0], usage)
+	return exitUsage
+}
+
+type command struct {
+	name   string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// flags creates a flag set for the command.
+func (c *command) flags(args string) *flag.FlagSet {
+	f := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	f.SetOutput(c.stderr)
+	f.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: convmarkup %s [flags] %s\n", c.name, args)
+		f.PrintDefaults()
+	}
+	return f
+}
+
+// fail prints an error which prevented the command from
+// running.
+func (c *command) fail(err error) int {
+	fmt.Fprintf(c.stderr, "convmarkup %s: %s\n", c.name, err)
+	return exitUsage
+}
+
+func (c *command) validate(args []string) int {
+	f := c.flags("file...")
+	var in dimsFlag
+	f.Var(&in, "input", "input dimensions, as WxHxD")
+	if f.Parse(args) != nil {
+		return exitUsage
+	} else if f.NArg() == 0 {
+		f.Usage()
+		return exitUsage
+	}
+	status := exitOK
+	for _, path := range f.Args() {
+		_, _, errs, err := load(path, convmarkup.Dims(in))
+		if err != nil {
+			return c.fail(err)
+		}
+		for _, e := range errs {
+			fmt.Fprintln(c.stdout, formatError(path, e))
+			status = exitProblem
+		}
+	}
+	return status
+}
+
+func (c *command) fmt(args []string) int {
+	f := c.flags("[file...]")
+	list := f.Bool("l", false, "list files which are not formatted")
+	if f.Parse(args) != nil {
+		return exitUsage
+	}
+	if f.NArg() == 0 {
+		data, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return c.fail(err)
+		}
+		formatted, err := convmarkup.FormatSource(string(data))
+		if err != nil {
+			fmt.Fprintln(c.stderr, formatError("<stdin>", err.(*convmarkup.ParseError)))
+			return exitProblem
+		}
+		if *list {
+			if formatted != string(data) {
+				fmt.Fprintln(c.stdout, "<stdin>")
+				return exitProblem
+			}
+			return exitOK
+		}
+		io.WriteString(c.stdout, formatted)
+		return exitOK
+	}
+	status := exitOK
+	for _, path := range f.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c.fail(err)
+		}
+		formatted, err := convmarkup.FormatSource(string(data))
+		if err != nil {
+			fmt.Fprintln(c.stderr, formatError(path, err.(*convmarkup.ParseError)))
+			status = exitProblem
+			continue
+		}
+		if formatted == string(data) {
+			continue
+		}
+		if *list {
+			fmt.Fprintln(c.stdout, path)
+			status = exitProblem
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return c.fail(err)
+		}
+		if err := os.WriteFile(path, []byte(formatted), info.Mode().Perm()); err != nil {
+			return c.fail(err)
+		}
+	}
+	return status
+}
+
+func (c *command) summary(args []string) int {
+	f := c.flags("file")
+	var in dimsFlag
+	f.Var(&in, "input", "input dimensions, as WxHxD")
+	markdown := f.Bool("markdown", false, "print a Markdown table")
+	if f.Parse(args) != nil {
+		return exitUsage
+	} else if f.NArg() != 1 {
+		f.Usage()
+		return exitUsage
+	}
+	block, src, status := c.loadOne(f.Arg(0), convmarkup.Dims(in))
+	if block == nil {
+		return status
+	}
+	summary := convmarkup.Summarize(block, convmarkup.Dims(in), src)
+	if *markdown {
+		io.WriteString(c.stdout, summary.Markdown())
+	} else {
+		io.WriteString(c.stdout, summary.String())
+	}
+	return exitOK
+}
+
+func (c *command) graph(args []string) int {
+	f := c.flags("file")
+	var in dimsFlag
+	f.Var(&in, "input", "input dimensions, as WxHxD")
+	unroll := f.Bool("unroll", false, "draw every copy of repeated blocks")
+	if f.Parse(args) != nil {
+		return exitUsage
+	} else if f.NArg() != 1 {
+		f.Usage()
+		return exitUsage
+	}
+	block, src, status := c.loadOne(f.Arg(0), convmarkup.Dims(in))
+	if block == nil {
+		return status
+	}
+	io.WriteString(c.stdout, convmarkup.DOT(block, *unroll, src))
+	return exitOK
+}
+
+// loadOne loads a file for a command which cannot
+// continue if there are errors.
+// If the block is nil, the returned status should be used
+// as the exit status.
+func (c *command) loadOne(path string, in convmarkup.Dims) (convmarkup.Block,
+	convmarkup.SourceMap, int) {
+	block, src, errs, err := load(path, in)
+	if err != nil {
+		return nil, nil, c.fail(err)
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintln(c.stderr, formatError(path, e))
+		}
+		return nil, nil, exitProblem
+	}
+	return block, src, exitOK
+}
+
+// load parses a file and creates its block.
+//
+// Errors in the markup are returned as a list, and other
+// errors, such as I/O errors, are returned separately.
+func load(path string, in convmarkup.Dims) (convmarkup.Block, convmarkup.SourceMap,
+	[]*convmarkup.ParseError, error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	root, err := convmarkup.ParseAllFS(os.DirFS(dir), name)
+	if err != nil {
+		var errs convmarkup.ErrorList
+		if errors.As(err, &errs) {
+			return nil, nil, errs, nil
+		}
+		return nil, nil, nil, err
+	}
+	block, src, err := root.BlockSources(in, convmarkup.DefaultCreators())
+	if err != nil {
+		var errs convmarkup.ErrorList
+		if _, err := root.BlockAll(in, convmarkup.DefaultCreators()); errors.As(err,
+			&errs) {
+			return nil, nil, errs, nil
+		}
+		return nil, nil, nil, err
+	}
+	return block, src, nil, nil
+}
+
+// formatError formats an error in a file at the given
+// path, using the conventional file:line:col form.
+func formatError(path string, e *convmarkup.ParseError) string {
+	if e.File != "" {
+		path = filepath.Join(filepath.Dir(path), filepath.FromSlash(e.File))
+	}
+	pos := fmt.Sprintf("%s:%d", path, e.Line+1)
+	if e.Span != nil {
+		pos += fmt.Sprintf(":%d", e.Span.Start.Col+1)
+	}
+	return pos + ": " + e.Message
+}
+
+func formatDims(d convmarkup.Dims) string {
+	return fmt.Sprintf("%dx%dx%d", d.Width, d.Height, d.Depth)
+}
+
+// dimsFlag is a flag.Value for dimensions written as
+// WxHxD.
+type dimsFlag convmarkup.Dims
+
+func (d *dimsFlag) String() string {
+	if *d == (dimsFlag{}) {
+		return ""
+	}
+	return formatDims(convmarkup.Dims(*d))
+}
+
+func (d *dimsFlag) Set(s string) error {
+	var w, h, depth int
+	if n, err := fmt.Sscanf(s, "%dx%dx%d", &w, &h, &depth); err != nil || n != 3 ||
+		formatDims(convmarkup.Dims{Width: w, Height: h, Depth: depth}) != s {
+		return errors.New("expected dimensions of the form WxHxD")
+	}
+	*d = dimsFlag{Width: w, Height: h, Depth: depth}
+	return nil
+}
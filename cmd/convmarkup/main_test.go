@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func runTool(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestValidate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"good.cm":   "Input(w=4, h=4, d=1)\nInclude(\"unit.cm\")\n",
+		"unit.cm":   "Conv(w=3, h=3, n=2, same=1)\n",
+		"bad.cm":    "Input(w=4, h=4, d=1)\nReLU\n}\n",
+		"other.cm":  "Input(w=4, h=4, d=1)\nInclude(\"broken.cm\")\n",
+		"broken.cm": "ReLU\nConv(w=3, h=3, n=2, bad=1)\n",
+	})
+	good := filepath.Join(dir, "good.cm")
+	bad := filepath.Join(dir, "bad.cm")
+	other := filepath.Join(dir, "other.cm")
+	if status, out, _ := runTool("", "validate", good); status != exitOK || out != "" {
+		t.Errorf("unexpected result for valid file: %d %q", status, out)
+	}
+
+	status, out, _ := runTool("", "validate", good, bad, other)
+	if status != exitProblem {
+		t.Errorf("unexpected status: %d", status)
+	}
+	expected := bad + ":3: unexpected }\n" +
+		filepath.Join(dir, "broken.cm") + ":2:21: unexpected attribute: bad\n"
+	if out != expected {
+		t.Errorf("expected output:\n%s\ngot:\n%s", expected, out)
+	}
+
+	if status, _, _ := runTool("", "validate", filepath.Join(dir, "missing.cm")); status != exitUsage {
+		t.Errorf("unexpected status for missing file: %d", status)
+	}
+	if status, _, _ := runTool("", "validate"); status != exitUsage {
+		t.Errorf("unexpected status without files: %d", status)
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.cm": "# Model.\nInput(d=1,w=2,h=2)\nResidual {\nReLU\n}\n",
+		"b.cm": "Input(w=2, h=2, d=1)\n",
+	})
+	a := filepath.Join(dir, "a.cm")
+	b := filepath.Join(dir, "b.cm")
+
+	status, out, _ := runTool("", "fmt", "-l", a, b)
+	if status != exitProblem || out != a+"\n" {
+		t.Errorf("unexpected result for fmt -l: %d %q", status, out)
+	}
+	if status, _, _ := runTool("", "fmt", a, b); status != exitOK {
+		t.Errorf("unexpected status for fmt: %d", status)
+	}
+	data, _ := os.ReadFile(a)
+	expected := "# Model.\nInput(w=2, h=2, d=1)\nResidual {\n    ReLU\n}\n"
+	if string(data) != expected {
+		t.Errorf("unexpected formatted file: %q", data)
+	}
+	if status, out, _ := runTool("", "fmt", "-l", a, b); status != exitOK || out != "" {
+		t.Errorf("unexpected result after formatting: %d %q", status, out)
+	}
+
+	if status, out, _ := runTool("FC( out=1+2 )\n", "fmt"); status != exitOK ||
+		out != "FC(out=1 + 2)\n" {
+		t.Errorf("unexpected result for stdin: %d %q", status, out)
+	}
+	status, _, errOut := runTool("ReLU\n}\n", "fmt")
+	if status != exitProblem || errOut != "<stdin>:2: unexpected }\n" {
+		t.Errorf("unexpected result for bad stdin: %d %q", status, errOut)
+	}
+}
+
+func TestSummaryAndGraph(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"model.cm": "Conv(w=3, h=3, n=2)\nRepeat(n=2) {\n\tReLU\n}\n",
+	})
+	path := filepath.Join(dir, "model.cm")
+
+	if status, _, _ := runTool("", "summary", filepath.Join(dir, "x.cm")); status != exitUsage {
+		t.Errorf("unexpected status for missing file: %d", status)
+	}
+	status, out, _ := runTool("", "summary", "-input", "4x4x1", path)
+	expected := `LINE  BLOCK      ATTRS          IN     OUT    PARAMS
+1     Conv       w=3, h=3, n=2  4x4x1  2x2x2  20
+2     Repeat x2  n=2            2x2x2  2x2x2  0
+3       ReLU                    2x2x2  2x2x2  0
+      Total                                   20
+`
+	if status != exitOK || out != expected {
+		t.Errorf("unexpected summary: %d\n%s", status, out)
+	}
+	status, out, _ = runTool("", "summary", "-input", "4x4x1", "-markdown", path)
+	if status != exitOK || !strings.HasPrefix(out, "| Line | Block |") {
+		t.Errorf("unexpected Markdown summary: %d\n%s", status, out)
+	}
+
+	status, out, _ = runTool("", "graph", "-input", "4x4x1", "-unroll", path)
+	if status != exitOK || !strings.HasPrefix(out, "digraph G {") {
+		t.Errorf("unexpected graph: %d\n%s", status, out)
+	}
+	if status, _, _ := runTool("", "graph", "-input", "4x4", path); status != exitUsage {
+		t.Errorf("unexpected status for bad input flag: %d", status)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	if status, _, _ := runTool(""); status != exitUsage {
+		t.Errorf("unexpected status without command: %d", status)
+	}
+	if status, _, errOut := runTool("", "frobnicate"); status != exitUsage ||
+		!strings.Contains(errOut, "unknown command") {
+		t.Errorf("unexpected result for unknown command: %d %q", status, errOut)
+	}
+}
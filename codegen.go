@@ -0,0 +1,216 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A codeTarget emits Python statements for a particular
+// deep learning framework.
+//
+// Values are Python variables holding tensors.
+// Every method which takes a dst argument emits code that
+// stores its result in the variable dst, which may be the
+// same as one of its inputs.
+type codeTarget interface {
+	// input emits code to create the model input.
+	input(d Dims, dst string)
+
+	// assign emits code to copy one variable to another.
+	assign(dst, src string)
+
+	// layer emits code to apply a block without sub-blocks.
+	layer(b Block, in Dims, src, dst string) error
+
+	// add emits code to sum two values.
+	add(dst, x, y string)
+
+	// concat emits code to concatenate values along the
+	// channel axis.
+	concat(dst string, srcs []string)
+
+	// repeat emits a loop which runs n times.
+	//
+	// The body is emitted by calling body with the name of
+	// the variable that the loop updates in place.
+	// When the loop finishes, the result must be in x.
+	repeat(n int, x string, body func(x string) error) error
+}
+
+// codeGen traverses a block tree, using a codeTarget to
+// emit the code for each block.
+type codeGen struct {
+	target   codeTarget
+	hasInput bool
+	names    nameCounter
+}
+
+func newCodeGen(t codeTarget) *codeGen {
+	return &codeGen{target: t, names: nameCounter{}}
+}
+
+// generate emits the code for a root block, storing the
+// result in the variable x.
+//
+// If in is not zero, it specifies the input dimensions
+// and the root needn't start with an Input block.
+func (c *codeGen) generate(r *Root, in Dims, x string) error {
+	if in != (Dims{}) {
+		c.target.input(in, x)
+		c.hasInput = true
+	}
+	if err := c.seq(r.Children, in, x, x); err != nil {
+		return err
+	}
+	if !c.hasInput {
+		return fmt.Errorf("missing Input block")
+	}
+	return nil
+}
+
+// name creates a unique variable name.
+func (c *codeGen) name(prefix string) string {
+	return c.names.next(prefix)
+}
+
+// A nameCounter creates unique Python identifiers by
+// numbering prefixes.
+type nameCounter map[string]int
+
+func (n nameCounter) next(prefix string) string {
+	idx := n[prefix]
+	n[prefix]++
+	return prefix + strconv.Itoa(idx)
+}
+
+// seq emits code for a sequence of blocks, reading from
+// the variable src and writing to the variable dst.
+func (c *codeGen) seq(blocks []Block, in Dims, src, dst string) error {
+	if len(blocks) == 0 && src != dst {
+		c.target.assign(dst, src)
+	}
+	for _, b := range blocks {
+		if err := c.block(b, in, src, dst); err != nil {
+			return err
+		}
+		src = dst
+		in = b.OutDims()
+	}
+	return nil
+}
+
+func (c *codeGen) block(b Block, in Dims, src, dst string) error {
+	switch b := b.(type) {
+	case *Root:
+		return c.seq(b.Children, in, src, dst)
+	case *Projection:
+		return c.seq(b.Children, in, src, dst)
+	case *Branch:
+		return c.seq(b.Children, in, src, dst)
+	case *Input:
+		if c.hasInput {
+			return fmt.Errorf("unexpected Input block")
+		}
+		c.hasInput = true
+		c.target.input(b.Out, dst)
+		return nil
+	}
+	if !c.hasInput {
+		return fmt.Errorf("missing Input block before %s", b.Type())
+	}
+
+	switch b := b.(type) {
+	case *Assert, *Debug:
+		return c.seq(nil, in, src, dst)
+	case *Residual:
+		skip := src
+		if b.Projection != nil || src == dst {
+			skip = c.name("skip")
+			if err := c.seq(b.Projection, in, src, skip); err != nil {
+				return err
+			}
+		}
+		if err := c.seq(b.Residual, in, src, dst); err != nil {
+			return err
+		}
+		c.target.add(dst, dst, skip)
+		return nil
+	case *Concat:
+		var outs []string
+		for _, branch := range b.Branches {
+			out := c.name("branch")
+			if err := c.seq(branch, in, src, out); err != nil {
+				return err
+			}
+			outs = append(outs, out)
+		}
+		c.target.concat(dst, outs)
+		return nil
+	case *Repeat:
+		if src != dst {
+			c.target.assign(dst, src)
+		}
+		return c.target.repeat(b.N, dst, func(x string) error {
+			return c.seq(b.Children, in, x, x)
+		})
+	}
+	return c.target.layer(b, in, src, dst)
+}
+
+// codeWriter writes indented lines of Python code.
+type codeWriter struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (c *codeWriter) line(format string, args ...interface{}) {
+	if format == "" {
+		c.buf.WriteByte('\n')
+		return
+	}
+	c.buf.WriteString(strings.Repeat("    ", c.indent))
+	fmt.Fprintf(&c.buf, format, args...)
+	c.buf.WriteByte('\n')
+}
+
+func (c *codeWriter) String() string {
+	return c.buf.String()
+}
+
+// pyTuple formats a Python tuple of integers.
+func pyTuple(vals ...int) string {
+	strs := make([]string, len(vals))
+	for i, x := range vals {
+		strs[i] = strconv.Itoa(x)
+	}
+	if len(strs) == 1 {
+		return "(" + strs[0] + ",)"
+	}
+	return "(" + strings.Join(strs, ", ") + ")"
+}
+
+// pyAffine formats the Python expression x*scale + bias,
+// omitting the identity parts.
+func pyAffine(x string, scale, bias float64) string {
+	res := x
+	if scale != 1 {
+		res += " * " + pyNumber(scale)
+	}
+	if bias < 0 {
+		res += " - " + pyNumber(-bias)
+	} else if bias > 0 {
+		res += " + " + pyNumber(bias)
+	}
+	return res
+}
+
+// pyNumber formats a number as a Python literal.
+func pyNumber(x float64) string {
+	res := formatNumber(x)
+	if x < 0 {
+		return "(" + res + ")"
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package convmarkup
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// testGolden runs a code generator on every markup file in
+// a testdata directory, comparing the results to the
+// golden files with the given extension.
+func testGolden(t *testing.T, dir, ext string, gen func(r *Root) (string, error)) {
+	paths, err := filepath.Glob(filepath.Join("testdata", dir, "*.cm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no test files")
+	}
+	for _, path := range paths {
+		markup, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := Parse(string(markup))
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		block, err := parsed.Block(Dims{}, DefaultCreators())
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		actual, err := gen(block.(*Root))
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		goldenPath := strings.TrimSuffix(path, ".cm") + ext
+		if *updateGolden {
+			if err := os.WriteFile(goldenPath, []byte(actual), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := os.ReadFile(goldenPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != string(expected) {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", path, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+)
+
+// Cost measures the computation required to apply a
+// block.
+type Cost struct {
+	// MACs is the number of multiply-accumulate operations.
+	MACs int
+
+	// FLOPs is the number of floating-point operations.
+	// Every multiply-accumulate counts as two FLOPs.
+	FLOPs int
+}
+
+// Add returns the sum of two costs.
+func (c Cost) Add(c1 Cost) Cost {
+	return Cost{MACs: c.MACs + c1.MACs, FLOPs: c.FLOPs + c1.FLOPs}
+}
+
+// Scale returns the cost multiplied by n.
+func (c Cost) Scale(n int) Cost {
+	return Cost{MACs: c.MACs * n, FLOPs: c.FLOPs * n}
+}
+
+// BlockCost estimates the cost of applying a single block
+// at inference time, not including its sub-blocks.
+// The in argument specifies the block's input dimensions.
+//
+// Convolutions, transposed convolutions, and
+// fully-connected layers are counted exactly, ignoring
+// biases.
+// Pooling counts one FLOP per input in each pool.
+// Element-wise activations count one FLOP per value,
+// while BatchNorm and Linear count one multiply-accumulate
+// per value.
+// Resize assumes bilinear interpolation, which takes four
+// multiply-accumulates per output value.
+// A Residual counts one FLOP per output value for adding
+// the two branches together.
+func BlockCost(b Block, in Dims) Cost {
+	switch b := b.(type) {
+	case *Conv:
+		filterSize := b.FilterWidth * b.FilterHeight * in.Depth / b.Groups
+		return macCost(b.Out.Volume() * filterSize)
+	case *Deconv:
+		filterSize := b.FilterWidth * b.FilterHeight * b.FilterCount
+		return macCost(in.Volume() * filterSize)
+	case *FC:
+		return macCost(in.Volume() * b.OutCount)
+	case *Pool:
+		return Cost{FLOPs: b.Out.Volume() * b.Width * b.Height}
+	case *Resize:
+		return macCost(b.Out.Volume() * 4)
+	case *Linear:
+		return macCost(in.Volume())
+	case *Activation:
+		if b.Name == "BatchNorm" {
+			return macCost(in.Volume())
+		}
+		return Cost{FLOPs: in.Volume()}
+	case *Residual:
+		return Cost{FLOPs: b.OutDims().Volume()}
+	}
+	return Cost{}
+}
+
+func macCost(macs int) Cost {
+	return Cost{MACs: macs, FLOPs: macs * 2}
+}
+
+// A CostRow describes the cost of one block.
+type CostRow struct {
+	// Line is the line number (starting at 0) of the block,
+	// or -1 if it is unknown.
+	Line int
+
+	Depth int
+	Type  string
+
+	// Cost is the cost of one application of the block.
+	Cost Cost
+
+	// Count is the number of times the block is applied,
+	// which is greater than 1 inside of Repeat blocks.
+	Count int
+}
+
+// A CostReport breaks down the cost of a block tree.
+type CostReport struct {
+	// Rows contains a row for every block with a non-zero
+	// cost, in order of appearance.
+	Rows []CostRow
+
+	// Total is the total cost of one forward pass.
+	Total Cost
+}
+
+// EstimateCost produces a CostReport for a block and all
+// of its sub-blocks.
+// Every branch of a Residual is counted, and the contents
+// of a Repeat are counted once per copy.
+//
+// The in argument specifies the block's input dimensions.
+// For a Root, Dims{} suffices.
+//
+// The SourceMap is used to look up line numbers.
+// It may be nil.
+func EstimateCost(b Block, in Dims, src SourceMap) *CostReport {
+	res := &CostReport{}
+	Walk(b, in, func(step WalkStep) error {
+		cost := BlockCost(step.Block, step.In)
+		if cost == (Cost{}) {
+			return nil
+		}
+		res.Rows = append(res.Rows, CostRow{
+			Line:  src.Line(step.Block),
+			Depth: step.Depth,
+			Type:  step.Block.Type(),
+			Cost:  cost,
+			Count: step.Count,
+		})
+		res.Total = res.Total.Add(cost.Scale(step.Count))
+		return nil
+	})
+	return res
+}
+
+// String renders the report as a table.
+func (c *CostReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tBLOCK\tMACS\tFLOPS\tCOUNT")
+	for _, row := range c.Rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", formatLine(row.Line), row.Type,
+			row.Cost.MACs, row.Cost.FLOPs, row.Count)
+	}
+	fmt.Fprintf(w, "\tTotal\t%d\t%d\n", c.Total.MACs, c.Total.FLOPs)
+	w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,49 @@
+package convmarkup
+
+import "testing"
+
+func TestEstimateCost(t *testing.T) {
+	markup := `Input(w=6, h=6, d=2)
+Conv(w=3, h=3, n=4, sx=1, sy=1)
+ReLU
+Residual {
+	Repeat(n=2) {
+		Linear(scale=2)
+	}
+}
+MaxPool(w=2, h=2)
+FC(out=3)
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := EstimateCost(block, Dims{}, src)
+
+	convMACs := 4 * 4 * 4 * 3 * 3 * 2
+	expected := []CostRow{
+		{Line: 1, Depth: 1, Type: "Conv", Cost: Cost{convMACs, convMACs * 2}, Count: 1},
+		{Line: 2, Depth: 1, Type: "ReLU", Cost: Cost{0, 64}, Count: 1},
+		{Line: 3, Depth: 1, Type: "Residual", Cost: Cost{0, 64}, Count: 1},
+		{Line: 5, Depth: 3, Type: "Linear", Cost: Cost{64, 128}, Count: 2},
+		{Line: 8, Depth: 1, Type: "MaxPool", Cost: Cost{0, 64}, Count: 1},
+		{Line: 9, Depth: 1, Type: "FC", Cost: Cost{48, 96}, Count: 1},
+	}
+	if len(report.Rows) != len(expected) {
+		t.Fatalf("expected %d rows but got %d", len(expected), len(report.Rows))
+	}
+	var total Cost
+	for i, x := range expected {
+		if report.Rows[i] != x {
+			t.Errorf("row %d: expected %v but got %v", i, x, report.Rows[i])
+		}
+		total = total.Add(x.Cost.Scale(x.Count))
+	}
+	if report.Total != total {
+		t.Errorf("expected total %v but got %v", total, report.Total)
+	}
+}
@@ -0,0 +1,567 @@
+package convmarkup
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// batchNormEpsilon is added to variances by BatchNorm to
+// prevent division by zero.
+const batchNormEpsilon = 1e-5
+
+// A WeightSource supplies the values of block parameters.
+type WeightSource interface {
+	// Weights returns the values for the parameter p of the
+	// block b, in row-major order according to p.Shape.
+	Weights(b Block, p Param) ([]float32, error)
+}
+
+// RandomWeights is a WeightSource which produces randomly
+// initialized parameters.
+//
+// Parameters named "weights" are drawn from a normal
+// distribution with variance 2/fanIn.
+// BatchNorm scales and variances are 1.
+// All other parameters, such as biases, are 0.
+type RandomWeights struct {
+	// Rand is the source of randomness.
+	// If it is nil, the math/rand top-level functions are
+	// used.
+	Rand *rand.Rand
+}
+
+// Weights generates values for the parameter.
+func (r *RandomWeights) Weights(b Block, p Param) ([]float32, error) {
+	res := make([]float32, p.Size())
+	switch p.Name {
+	case "weights":
+		fanIn := 1
+		if len(p.Shape) > 0 && p.Shape[0] > 0 {
+			fanIn = len(res) / p.Shape[0]
+		}
+		stddev := math.Sqrt(2 / float64(fanIn))
+		for i := range res {
+			if r.Rand != nil {
+				res[i] = float32(r.Rand.NormFloat64() * stddev)
+			} else {
+				res[i] = float32(rand.NormFloat64() * stddev)
+			}
+		}
+	case "scales", "variances":
+		for i := range res {
+			res[i] = 1
+		}
+	}
+	return res, nil
+}
+
+// A CPULayer is a block which has been realized by a
+// CPURealizer.
+type CPULayer interface {
+	// Apply applies the layer to an input tensor.
+	//
+	// The input must have the dimensions which the block
+	// was realized with.
+	// Otherwise, Apply will panic.
+	Apply(in *Tensor) *Tensor
+}
+
+// CPURealizer is a Realizer which creates CPULayers for
+// running blocks on the CPU.
+//
+// It is a dependency-free reference implementation which
+// favors simplicity over speed.
+// It supports every built-in block except for Input and
+// Assert, which should be realized by a MetaRealizer:
+//
+//	chain := RealizerChain{MetaRealizer{}, &CPURealizer{...}}
+//
+// Every copy of the contents of a Repeat gets its own
+// parameters.
+// BatchNorm uses its mean and variance parameters, and
+// Dropout has no effect, as appropriate for inference.
+// Resize uses bilinear interpolation, and Softmax is taken
+// along the depth dimension at every spatial location.
+type CPURealizer struct {
+	Weights WeightSource
+}
+
+// Realize creates a CPULayer for the block.
+func (c *CPURealizer) Realize(chain RealizerChain, inDims Dims,
+	b Block) (interface{}, error) {
+	switch b := b.(type) {
+	case *Root:
+		return c.realizeSeq(chain, inDims, b.Children)
+	case *Projection:
+		return c.realizeSeq(chain, inDims, b.Children)
+	case *Branch:
+		return c.realizeSeq(chain, inDims, b.Children)
+	case *Repeat:
+		var res cpuSeq
+		for i := 0; i < b.N; i++ {
+			layer, err := c.realizeSeq(chain, inDims, b.Children)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, layer)
+		}
+		return res, nil
+	case *Residual:
+		res := &cpuResidual{}
+		if b.Projection != nil {
+			proj, err := c.realizeSeq(chain, inDims, b.Projection)
+			if err != nil {
+				return nil, err
+			}
+			res.Projection = proj
+		}
+		body, err := c.realizeSeq(chain, inDims, b.Residual)
+		if err != nil {
+			return nil, err
+		}
+		res.Residual = body
+		return res, nil
+	case *Concat:
+		res := &cpuConcat{Out: b.Out}
+		for _, branch := range b.Branches {
+			layer, err := c.realizeSeq(chain, inDims, branch)
+			if err != nil {
+				return nil, err
+			}
+			res.Branches = append(res.Branches, layer)
+		}
+		return res, nil
+	case *Conv:
+		params, err := c.params(b, inDims)
+		if err != nil {
+			return nil, err
+		}
+		return &cpuConv{Conv: b, In: inDims, Weights: params[0],
+			Biases: params[1]}, nil
+	case *Deconv:
+		params, err := c.params(b, inDims)
+		if err != nil {
+			return nil, err
+		}
+		return &cpuDeconv{Deconv: b, In: inDims, Weights: params[0],
+			Biases: params[1]}, nil
+	case *FC:
+		params, err := c.params(b, inDims)
+		if err != nil {
+			return nil, err
+		}
+		return &cpuFC{In: inDims, OutCount: b.OutCount, Weights: params[0],
+			Biases: params[1]}, nil
+	case *Pool:
+		if b.Name != "MaxPool" && b.Name != "MeanPool" {
+			return nil, ErrUnsupportedBlock
+		}
+		return &cpuPool{Pool: b, In: inDims}, nil
+	case *Padding:
+		return &cpuPadding{Padding: b, In: inDims}, nil
+	case *Resize:
+		return &cpuResize{In: inDims, Out: b.Out}, nil
+	case *Linear:
+		return &cpuLinear{In: inDims, Scale: float32(b.Scale),
+			Bias: float32(b.Bias)}, nil
+	case *Dropout, *Debug:
+		return cpuIdentity{In: inDims}, nil
+	case *Activation:
+		switch b.Name {
+		case "BatchNorm":
+			params, err := c.params(b, inDims)
+			if err != nil {
+				return nil, err
+			}
+			return &cpuBatchNorm{In: inDims, Scales: params[0], Biases: params[1],
+				Means: params[2], Variances: params[3]}, nil
+		case "ReLU", "Sigmoid", "Tanh", "Softmax":
+			return &cpuActivation{Name: b.Name, In: inDims}, nil
+		}
+	}
+	return nil, ErrUnsupportedBlock
+}
+
+// realizeSeq realizes a sequence of blocks, dropping any
+// blocks without a meaningful instantiation.
+func (c *CPURealizer) realizeSeq(chain RealizerChain, in Dims,
+	blocks []Block) (cpuSeq, error) {
+	res := cpuSeq{}
+	for _, b := range blocks {
+		obj, _, err := chain.Realize(in, b)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			layer, ok := obj.(CPULayer)
+			if !ok {
+				return nil, fmt.Errorf("realized %s is not a CPULayer: %T", b.Type(), obj)
+			}
+			res = append(res, layer)
+		}
+		in = b.OutDims()
+	}
+	return res, nil
+}
+
+// params fetches the values for every parameter of a
+// block from the WeightSource.
+func (c *CPURealizer) params(b Block, in Dims) ([][]float32, error) {
+	return loadParams(c.Weights, b, in)
+}
+
+// loadParams fetches the values for every parameter of a
+// block from a WeightSource, in the order given by Params.
+func loadParams(w WeightSource, b Block, in Dims) ([][]float32, error) {
+	var res [][]float32
+	for _, p := range Params(b, in) {
+		values, err := w.Weights(b, p)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != p.Size() {
+			return nil, fmt.Errorf("%s %s: expected %d values but got %d", b.Type(),
+				p.Name, p.Size(), len(values))
+		}
+		res = append(res, values)
+	}
+	return res, nil
+}
+
+func checkInput(in *Tensor, d Dims) {
+	if in.Dims != d {
+		panic(fmt.Sprintf("expected input dimensions %s but got %s", formatDims(d),
+			formatDims(in.Dims)))
+	}
+}
+
+type cpuSeq []CPULayer
+
+func (c cpuSeq) Apply(in *Tensor) *Tensor {
+	for _, layer := range c {
+		in = layer.Apply(in)
+	}
+	return in
+}
+
+type cpuIdentity struct {
+	In Dims
+}
+
+func (c cpuIdentity) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	return in
+}
+
+type cpuResidual struct {
+	// Projection may be nil.
+	Projection CPULayer
+	Residual   CPULayer
+}
+
+func (c *cpuResidual) Apply(in *Tensor) *Tensor {
+	skip := in
+	if c.Projection != nil {
+		skip = c.Projection.Apply(in)
+	}
+	out := c.Residual.Apply(in)
+	res := NewTensor(out.Dims)
+	for i, x := range out.Data {
+		res.Data[i] = x + skip.Data[i]
+	}
+	return res
+}
+
+type cpuConcat struct {
+	Branches []CPULayer
+	Out      Dims
+}
+
+func (c *cpuConcat) Apply(in *Tensor) *Tensor {
+	res := NewTensor(c.Out)
+	var offset int
+	for _, branch := range c.Branches {
+		out := branch.Apply(in)
+		for y := 0; y < c.Out.Height; y++ {
+			for x := 0; x < c.Out.Width; x++ {
+				for z := 0; z < out.Dims.Depth; z++ {
+					res.Set(x, y, z+offset, out.At(x, y, z))
+				}
+			}
+		}
+		offset += out.Dims.Depth
+	}
+	return res
+}
+
+type cpuConv struct {
+	Conv    *Conv
+	In      Dims
+	Weights []float32
+	Biases  []float32
+}
+
+func (c *cpuConv) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	conv := c.Conv
+	res := NewTensor(conv.Out)
+	groupIn := c.In.Depth / conv.Groups
+	groupOut := conv.FilterCount / conv.Groups
+	for oy := 0; oy < conv.Out.Height; oy++ {
+		for ox := 0; ox < conv.Out.Width; ox++ {
+			for f := 0; f < conv.FilterCount; f++ {
+				startZ := (f / groupOut) * groupIn
+				sum := c.Biases[f]
+				for ky := 0; ky < conv.FilterHeight; ky++ {
+					iy := oy*conv.StrideY - conv.PadTop + ky*conv.DilationY
+					if iy < 0 || iy >= c.In.Height {
+						continue
+					}
+					for kx := 0; kx < conv.FilterWidth; kx++ {
+						ix := ox*conv.StrideX - conv.PadLeft + kx*conv.DilationX
+						if ix < 0 || ix >= c.In.Width {
+							continue
+						}
+						for z := 0; z < groupIn; z++ {
+							w := c.Weights[((f*groupIn+z)*conv.FilterHeight+ky)*
+								conv.FilterWidth+kx]
+							sum += w * in.At(ix, iy, startZ+z)
+						}
+					}
+				}
+				res.Set(ox, oy, f, sum)
+			}
+		}
+	}
+	return res
+}
+
+type cpuDeconv struct {
+	Deconv  *Deconv
+	In      Dims
+	Weights []float32
+	Biases  []float32
+}
+
+func (c *cpuDeconv) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	d := c.Deconv
+	res := NewTensor(d.Out)
+	for i := range res.Data {
+		res.Data[i] = c.Biases[i%d.FilterCount]
+	}
+	for iy := 0; iy < c.In.Height; iy++ {
+		for ix := 0; ix < c.In.Width; ix++ {
+			for z := 0; z < c.In.Depth; z++ {
+				inVal := in.At(ix, iy, z)
+				for f := 0; f < d.FilterCount; f++ {
+					for ky := 0; ky < d.FilterHeight; ky++ {
+						oy := iy*d.StrideY + ky
+						for kx := 0; kx < d.FilterWidth; kx++ {
+							ox := ix*d.StrideX + kx
+							w := c.Weights[((z*d.FilterCount+f)*d.FilterHeight+ky)*
+								d.FilterWidth+kx]
+							res.Data[res.Index(ox, oy, f)] += w * inVal
+						}
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+type cpuFC struct {
+	In       Dims
+	OutCount int
+	Weights  []float32
+	Biases   []float32
+}
+
+func (c *cpuFC) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	res := NewTensor(Dims{Width: 1, Height: 1, Depth: c.OutCount})
+	inCount := len(in.Data)
+	for i := range res.Data {
+		sum := c.Biases[i]
+		for j, x := range in.Data {
+			sum += c.Weights[i*inCount+j] * x
+		}
+		res.Data[i] = sum
+	}
+	return res
+}
+
+type cpuPool struct {
+	Pool *Pool
+	In   Dims
+}
+
+func (c *cpuPool) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	p := c.Pool
+	res := NewTensor(p.Out)
+	for oy := 0; oy < p.Out.Height; oy++ {
+		for ox := 0; ox < p.Out.Width; ox++ {
+			for z := 0; z < p.Out.Depth; z++ {
+				var sum float32
+				max := float32(math.Inf(-1))
+				for y := oy * p.StrideY; y < oy*p.StrideY+p.Height; y++ {
+					for x := ox * p.StrideX; x < ox*p.StrideX+p.Width; x++ {
+						val := in.At(x, y, z)
+						sum += val
+						if val > max {
+							max = val
+						}
+					}
+				}
+				if p.Name == "MaxPool" {
+					res.Set(ox, oy, z, max)
+				} else {
+					res.Set(ox, oy, z, sum/float32(p.Width*p.Height))
+				}
+			}
+		}
+	}
+	return res
+}
+
+type cpuPadding struct {
+	Padding *Padding
+	In      Dims
+}
+
+func (c *cpuPadding) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	res := NewTensor(c.Padding.Out)
+	for y := 0; y < c.In.Height; y++ {
+		for x := 0; x < c.In.Width; x++ {
+			for z := 0; z < c.In.Depth; z++ {
+				res.Set(x+c.Padding.Left, y+c.Padding.Top, z, in.At(x, y, z))
+			}
+		}
+	}
+	return res
+}
+
+type cpuResize struct {
+	In  Dims
+	Out Dims
+}
+
+func (c *cpuResize) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	res := NewTensor(c.Out)
+	for oy := 0; oy < c.Out.Height; oy++ {
+		y0, y1, fy := resizeCoord(oy, c.In.Height, c.Out.Height)
+		for ox := 0; ox < c.Out.Width; ox++ {
+			x0, x1, fx := resizeCoord(ox, c.In.Width, c.Out.Width)
+			for z := 0; z < c.Out.Depth; z++ {
+				top := in.At(x0, y0, z)*(1-fx) + in.At(x1, y0, z)*fx
+				bottom := in.At(x0, y1, z)*(1-fx) + in.At(x1, y1, z)*fx
+				res.Set(ox, oy, z, top*(1-fy)+bottom*fy)
+			}
+		}
+	}
+	return res
+}
+
+// resizeCoord maps an output coordinate to the two input
+// coordinates it is interpolated from, along with the
+// weight of the second one.
+// Coordinates are scaled so that the outer edges of the
+// input and output pixels line up.
+func resizeCoord(out, inSize, outSize int) (int, int, float32) {
+	pos := (float64(out)+0.5)*float64(inSize)/float64(outSize) - 0.5
+	if pos < 0 {
+		pos = 0
+	}
+	i0 := int(pos)
+	if i0 >= inSize-1 {
+		return inSize - 1, inSize - 1, 0
+	}
+	return i0, i0 + 1, float32(pos - float64(i0))
+}
+
+type cpuLinear struct {
+	In    Dims
+	Scale float32
+	Bias  float32
+}
+
+func (c *cpuLinear) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	res := NewTensor(c.In)
+	for i, x := range in.Data {
+		res.Data[i] = x*c.Scale + c.Bias
+	}
+	return res
+}
+
+type cpuBatchNorm struct {
+	In        Dims
+	Scales    []float32
+	Biases    []float32
+	Means     []float32
+	Variances []float32
+}
+
+func (c *cpuBatchNorm) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	res := NewTensor(c.In)
+	for i, x := range in.Data {
+		z := i % c.In.Depth
+		norm := (x - c.Means[z]) / float32(math.Sqrt(float64(c.Variances[z])+
+			batchNormEpsilon))
+		res.Data[i] = norm*c.Scales[z] + c.Biases[z]
+	}
+	return res
+}
+
+type cpuActivation struct {
+	Name string
+	In   Dims
+}
+
+func (c *cpuActivation) Apply(in *Tensor) *Tensor {
+	checkInput(in, c.In)
+	res := NewTensor(c.In)
+	switch c.Name {
+	case "ReLU":
+		for i, x := range in.Data {
+			if x > 0 {
+				res.Data[i] = x
+			}
+		}
+	case "Sigmoid":
+		for i, x := range in.Data {
+			res.Data[i] = float32(1 / (1 + math.Exp(-float64(x))))
+		}
+	case "Tanh":
+		for i, x := range in.Data {
+			res.Data[i] = float32(math.Tanh(float64(x)))
+		}
+	case "Softmax":
+		// The softmax is computed along the depth dimension
+		// at every spatial location.
+		depth := c.In.Depth
+		for start := 0; start < len(in.Data); start += depth {
+			vec := in.Data[start : start+depth]
+			max := math.Inf(-1)
+			for _, x := range vec {
+				max = math.Max(max, float64(x))
+			}
+			var sum float64
+			for i, x := range vec {
+				e := math.Exp(float64(x) - max)
+				res.Data[start+i] = float32(e)
+				sum += e
+			}
+			for i := range vec {
+				res.Data[start+i] /= float32(sum)
+			}
+		}
+	}
+	return res
+}
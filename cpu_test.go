@@ -0,0 +1,162 @@
+package convmarkup
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+type testWeights func(b Block, p Param) ([]float32, error)
+
+func (t testWeights) Weights(b Block, p Param) ([]float32, error) {
+	return t(b, p)
+}
+
+func realizeCPU(t *testing.T, markup string, w WeightSource) CPULayer {
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := RealizerChain{MetaRealizer{}, &CPURealizer{Weights: w}}
+	obj, _, err := chain.Realize(Dims{}, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj.(CPULayer)
+}
+
+func rangeTensor(d Dims) *Tensor {
+	res := NewTensor(d)
+	for i := range res.Data {
+		res.Data[i] = float32(i + 1)
+	}
+	return res
+}
+
+func checkTensor(t *testing.T, name string, actual *Tensor, d Dims, expected []float32) {
+	if actual.Dims != d {
+		t.Errorf("%s: expected dims %v but got %v", name, d, actual.Dims)
+		return
+	} else if len(actual.Data) != len(expected) {
+		t.Errorf("%s: expected %d values but got %d", name, len(expected),
+			len(actual.Data))
+		return
+	}
+	for i, x := range expected {
+		if math.Abs(float64(actual.Data[i]-x)) > 1e-4 {
+			t.Errorf("%s: expected %v but got %v", name, expected, actual.Data)
+			return
+		}
+	}
+}
+
+func TestCPURealizerLayers(t *testing.T) {
+	weights := testWeights(func(b Block, p Param) ([]float32, error) {
+		res := make([]float32, p.Size())
+		for i := range res {
+			if p.Name == "weights" {
+				res[i] = float32(i + 1)
+			} else if p.Name == "biases" {
+				res[i] = 0.5
+			} else if p.Name == "variances" {
+				res[i] = 4
+			} else {
+				res[i] = 1
+			}
+		}
+		return res, nil
+	})
+	in := rangeTensor(Dims{Width: 3, Height: 3, Depth: 1})
+	tests := []struct {
+		block    string
+		dims     Dims
+		expected []float32
+	}{
+		{"Conv(w=2, h=2, n=1)", Dims{2, 2, 1},
+			[]float32{37.5, 47.5, 67.5, 77.5}},
+		{"Conv(w=2, h=1, n=1, dx=2, p=1)", Dims{3, 5, 1},
+			[]float32{0.5, 0.5, 0.5, 4.5, 7.5, 2.5, 10.5, 16.5, 5.5, 16.5, 25.5, 8.5,
+				0.5, 0.5, 0.5}},
+		{"Deconv(w=2, h=1, n=1, sx=2, ox=1)", Dims{7, 3, 1},
+			[]float32{1.5, 2.5, 2.5, 4.5, 3.5, 6.5, 0.5, 4.5, 8.5, 5.5, 10.5, 6.5, 12.5,
+				0.5, 7.5, 14.5, 8.5, 16.5, 9.5, 18.5, 0.5}},
+		{"MaxPool(w=2, h=2, sx=1, sy=1)", Dims{2, 2, 1}, []float32{5, 6, 8, 9}},
+		{"MeanPool(w=3, h=2, sy=1)", Dims{1, 2, 1}, []float32{3.5, 6.5}},
+		{"MaxPool(w=2, h=2)", Dims{1, 1, 1}, []float32{5}},
+		{"MaxPool(w=3, h=2, sx=2, sy=2)", Dims{1, 1, 1}, []float32{6}},
+		{"Padding(t=1, r=0, b=0, l=1)", Dims{4, 4, 1},
+			[]float32{0, 0, 0, 0, 0, 1, 2, 3, 0, 4, 5, 6, 0, 7, 8, 9}},
+		{"Resize(w=6, h=1)", Dims{6, 1, 1}, []float32{4, 4.25, 4.75, 5.25, 5.75, 6}},
+		{"FC(out=2)", Dims{1, 1, 2}, []float32{285.5, 690.5}},
+		{"Linear(scale=2, bias=-1)", Dims{3, 3, 1},
+			[]float32{1, 3, 5, 7, 9, 11, 13, 15, 17}},
+		{"BatchNorm", Dims{3, 3, 1},
+			[]float32{0.5, 1, 1.5, 2, 2.5, 3, 3.5, 4, 4.5}},
+		{"Dropout(prob=0.5)", Dims{3, 3, 1}, []float32{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"Residual {\nLinear(scale=2)\n}", Dims{3, 3, 1},
+			[]float32{3, 6, 9, 12, 15, 18, 21, 24, 27}},
+		{"Concat {\nBranch {\n}\nBranch {\nLinear(bias=1)\n}\n}", Dims{3, 3, 2},
+			[]float32{1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10}},
+		{"Repeat(n=3) {\nLinear(scale=2)\n}", Dims{3, 3, 1},
+			[]float32{8, 16, 24, 32, 40, 48, 56, 64, 72}},
+	}
+	for _, test := range tests {
+		layer := realizeCPU(t, "Input(w=3, h=3, d=1)\n"+test.block, weights)
+		checkTensor(t, test.block, layer.Apply(in), test.dims, test.expected)
+	}
+}
+
+func TestCPURealizerActivations(t *testing.T) {
+	in := &Tensor{Dims: Dims{1, 2, 2}, Data: []float32{-1, 2, 0, 0}}
+	tests := map[string][]float32{
+		"ReLU":    {0, 2, 0, 0},
+		"Sigmoid": {0.26894142, 0.88079708, 0.5, 0.5},
+		"Tanh":    {-0.76159416, 0.96402758, 0, 0},
+		"Softmax": {0.04742587, 0.95257413, 0.5, 0.5},
+	}
+	for name, expected := range tests {
+		layer := realizeCPU(t, "Input(w=1, h=2, d=2)\n"+name, &RandomWeights{})
+		checkTensor(t, name, layer.Apply(in), in.Dims, expected)
+	}
+}
+
+func TestCPURealizerEquivalence(t *testing.T) {
+	pairs := [][2]string{
+		{
+			"Conv(w=3, h=3, n=4, same=1)",
+			"Padding(t=1, r=1, b=1, l=1)\nConv(w=3, h=3, n=4)",
+		},
+		{
+			"Conv(w=2, h=2, n=3, sx=2, sy=2, same=1)",
+			"Padding(t=0, r=1, b=0, l=0)\nConv(w=2, h=2, n=3, sx=2, sy=2)",
+		},
+	}
+	in := rangeTensor(Dims{Width: 5, Height: 4, Depth: 2})
+	for _, pair := range pairs {
+		var outs []*Tensor
+		for _, block := range pair {
+			weights := &RandomWeights{Rand: rand.New(rand.NewSource(1))}
+			layer := realizeCPU(t, "Input(w=5, h=4, d=2)\n"+block, weights)
+			outs = append(outs, layer.Apply(in))
+		}
+		checkTensor(t, pair[1], outs[1], outs[0].Dims, outs[0].Data)
+	}
+}
+
+func TestCPURealizerGroups(t *testing.T) {
+	weights := testWeights(func(b Block, p Param) ([]float32, error) {
+		if p.Name == "weights" {
+			return []float32{1, 2, 3, 4}, nil
+		}
+		return []float32{0.5, -0.5}, nil
+	})
+	layer := realizeCPU(t, "Input(w=1, h=1, d=4)\nConv(w=1, h=1, n=2, groups=2)",
+		weights)
+	in := &Tensor{Dims: Dims{1, 1, 4}, Data: []float32{1, 2, 3, 4}}
+	checkTensor(t, "groups", layer.Apply(in), Dims{1, 1, 2},
+		[]float32{1*1 + 2*2 + 0.5, 3*3 + 4*4 - 0.5})
+}
@@ -40,6 +40,66 @@
 //         Conv(w=3, h=3, n=64)
 //     }
 //
+// Attribute values can be arithmetic expressions, which
+// are evaluated while parsing.
+// Expressions may use numbers, parentheses, the binary
+// operators +, -, *, /, and %, and unary minus.
+// They may also refer to constants, which are defined at
+// the top level of a file with let statements:
+//
+//     let width = 64
+//     let k = 3
+//     Padding(l=(k-1)/2, r=(k-1)/2, t=(k-1)/2, b=(k-1)/2)
+//     Conv(w=k, h=k, n=width*2)
+//
+// A let statement may refer to previously defined
+// constants, and each constant may only be defined once.
+//
+// Macros
+//
+// A sequence of blocks can be packaged into a reusable,
+// parameterized block with a Define statement at the top
+// level of a file:
+//
+//     Define Bottleneck(n, s) {
+//         Conv(w=1, h=1, n=n/4, sx=s, sy=s)
+//         ReLU
+//         Conv(w=3, h=3, n=n/4, same=1)
+//         ReLU
+//         Conv(w=1, h=1, n=n)
+//     }
+//
+// A macro is used like any other block, with one attribute
+// per parameter:
+//
+//     Bottleneck(n=256, s=2)
+//
+// When blocks are created, every use of a macro is
+// replaced by the contents of its definition.
+// Macros may use other macros, but not recursively.
+// A macro's name may not be the name of an existing block
+// type, and its parameters may not share names with
+// constants from let statements.
+//
+// Includes
+//
+// When markup is parsed from a file system with ParseFS,
+// other files can be included like so:
+//
+//     Include("stems/resnet.cm")
+//
+// The included file is parsed as if its contents appeared
+// in place of the Include line, so its let constants and
+// macros become available to the including file.
+// File names are relative to the directory of the
+// including file.
+// An Include may appear inside of a block, in which case
+// the included file may only contain blocks.
+// A file may not include itself, directly or indirectly,
+// but it may be included more than once, in which case
+// its let constants and macros are only defined the first
+// time.
+//
 // Every block takes a tensor as input and produces a
 // tensor as output.
 // Blocks must be aware of how they manipulate tensor
@@ -66,6 +126,32 @@
 // Optional sx and sy attributes determine the x and y
 // strides.
 // Absent strides are assumed to be 1.
+// Optional dx and dy attributes set the x and y dilation
+// of the filters, which also default to 1.
+// By default, a convolution is "valid", meaning that the
+// filters never extend past the input.
+// The optional p attribute pads every side of the input
+// with p zeros before the convolution.
+// Alternatively, setting the same attribute to 1 pads the
+// input just enough to produce ceil(w/sx) by ceil(h/sy)
+// outputs, putting any odd unit of padding on the bottom
+// or right.
+// The optional groups attribute splits the input channels
+// and the filters into groups, where each group of filters
+// only sees one group of channels.
+// Both the input depth and n must be divisible by groups.
+// A depthwise convolution can be achieved by setting
+// groups to the input depth.
+//
+// The Deconv block defines a transposed convolutional
+// layer, which is typically used for upsampling.
+// Like Conv, it has w, h, and n attributes, as well as
+// optional sx and sy attributes which default to 1.
+// The output width is (w_in-1)*sx + w, and similarly for
+// the height.
+// Optional ox and oy attributes add extra columns and rows
+// to the right and bottom of the output, and must be less
+// than the corresponding stride.
 //
 // The MaxPool block defines a max-pooling layer.
 // The w and h attributes set the pool width and height,
@@ -75,6 +161,8 @@
 // If the width or height is not specified, it defaults to
 // the corresponding dimension of the input.
 // Max-pooling layers drop partial pools.
+// The pool cannot be larger than the input, and the input
+// cannot be empty.
 //
 // The MeanPool block defines a mean-pooling layer and
 // works the same way as MaxPool.
@@ -115,6 +203,27 @@
 //         Conv(w=3, h=3, n=64)
 //     }
 //
+// The Concat block runs several branches on the same
+// input and concatenates their outputs along the depth
+// dimension.
+// Every child of a Concat must be a Branch block, and
+// every branch must produce the same width and height.
+// Branch blocks may not be used anywhere else.
+// A Branch with no children passes its input through
+// unchanged, which is useful for skip connections:
+//
+//     Concat {
+//         Branch {
+//         }
+//         Branch {
+//             Conv(w=1, h=1, n=32)
+//         }
+//         Branch {
+//             Padding(l=1, r=1, t=1, b=1)
+//             Conv(w=3, h=3, n=32)
+//         }
+//     }
+//
 // The Assert block has no effect besides ensuring that
 // the input dimensions are specific values.
 // Like Input, it has three attributes: w, h, and d.
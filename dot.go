@@ -0,0 +1,137 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DOT produces a Graphviz DOT graph of a block tree.
+//
+// Every block is drawn as a node labelled with its type,
+// its attributes, and its output dimensions.
+// A Residual is drawn as a merge node which receives both
+// the residual mapping and the skip connection (or the
+// projection, if there is one).
+// A Concat is drawn as a merge node which receives the
+// output of every branch.
+//
+// If unroll is true, the contents of a Repeat block are
+// drawn once per copy.
+// Otherwise, they are drawn once, inside of a cluster
+// which is labelled with the repeat count.
+//
+// The SourceMap is used to label blocks with the
+// attributes they were written with, such as same=1.
+// It may be nil.
+func DOT(b Block, unroll bool, src SourceMap) string {
+	d := &dotWriter{unroll: unroll, src: src, indent: 1}
+	d.line("node [shape=box];")
+	d.block(b, "")
+	for _, e := range d.edges {
+		d.line("%s", e)
+	}
+	return "digraph G {\n" + d.buf.String() + "}\n"
+}
+
+type dotWriter struct {
+	buf      bytes.Buffer
+	edges    []string
+	unroll   bool
+	src      SourceMap
+	indent   int
+	nodes    int
+	clusters int
+}
+
+func (d *dotWriter) line(format string, args ...interface{}) {
+	d.buf.WriteString(strings.Repeat("\t", d.indent))
+	fmt.Fprintf(&d.buf, format, args...)
+	d.buf.WriteByte('\n')
+}
+
+// node emits a node and an edge from prev to the node.
+//
+// Edges are emitted after all of the nodes, since an edge
+// inside of a cluster would pull both of its endpoints
+// into the cluster.
+func (d *dotWriter) node(prev string, attrs string, label ...string) string {
+	name := "n" + strconv.Itoa(d.nodes)
+	d.nodes++
+	d.line("%s [%slabel=%s];", name, attrs, strconv.Quote(strings.Join(label, "\n")))
+	d.edge(prev, name, "")
+	return name
+}
+
+func (d *dotWriter) edge(from, to, attrs string) {
+	if from == "" {
+		return
+	}
+	if attrs != "" {
+		d.edges = append(d.edges, fmt.Sprintf("%s -> %s [%s];", from, to, attrs))
+	} else {
+		d.edges = append(d.edges, fmt.Sprintf("%s -> %s;", from, to))
+	}
+}
+
+// block draws a block which receives its input from the
+// node prev, and returns the node producing its output.
+func (d *dotWriter) block(b Block, prev string) string {
+	switch b := b.(type) {
+	case *Root:
+		return d.seq(b.Children, prev)
+	case *Projection:
+		return d.seq(b.Children, prev)
+	case *Residual:
+		skip := prev
+		if b.Projection != nil {
+			skip = d.seq(b.Projection, prev)
+		}
+		out := d.seq(b.Residual, prev)
+		merge := d.node(out, "shape=ellipse, ", "Residual", "+",
+			formatDims(b.OutDims()))
+		d.edge(skip, merge, "style=dashed")
+		return merge
+	case *Branch:
+		return d.seq(b.Children, prev)
+	case *Concat:
+		var outs []string
+		for _, branch := range b.Branches {
+			outs = append(outs, d.seq(branch, prev))
+		}
+		merge := d.node("", "shape=ellipse, ", "Concat", formatDims(b.Out))
+		for _, out := range outs {
+			d.edge(out, merge, "")
+		}
+		return merge
+	case *Repeat:
+		if d.unroll {
+			for i := 0; i < b.N; i++ {
+				prev = d.seq(b.Children, prev)
+			}
+			return prev
+		}
+		d.line("subgraph cluster_%d {", d.clusters)
+		d.clusters++
+		d.indent++
+		d.line("label=%s;", strconv.Quote(fmt.Sprintf("Repeat x%d", b.N)))
+		prev = d.seq(b.Children, prev)
+		d.indent--
+		d.line("}")
+		return prev
+	}
+	label := []string{b.Type()}
+	if attrs := blockAttrs(b, d.src.Node(b)); len(attrs) > 0 {
+		label = append(label, formatAttrs(b.Type(), attrs))
+	}
+	label = append(label, formatDims(b.OutDims()))
+	return d.node(prev, "", label...)
+}
+
+func (d *dotWriter) seq(blocks []Block, prev string) string {
+	for _, b := range blocks {
+		prev = d.block(b, prev)
+	}
+	return prev
+}
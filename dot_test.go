@@ -0,0 +1,83 @@
+package convmarkup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDOT(t *testing.T) {
+	markup := `Input(w=4, h=4, d=1)
+Repeat(n=2) {
+	Residual {
+		Projection {
+			Conv(w=1, h=1, n=1)
+		}
+		ReLU
+	}
+}
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `digraph G {
+	node [shape=box];
+	n0 [label="Input\nw=4, h=4, d=1\n4x4x1"];
+	subgraph cluster_0 {
+		label="Repeat x2";
+		n1 [label="Conv\nw=1, h=1, n=1, sx=1, sy=1\n4x4x1"];
+		n2 [label="ReLU\n4x4x1"];
+		n3 [shape=ellipse, label="Residual\n+\n4x4x1"];
+	}
+	n0 -> n1;
+	n0 -> n2;
+	n2 -> n3;
+	n1 -> n3 [style=dashed];
+}
+`
+	if actual := DOT(block, false, nil); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	unrolled := DOT(block, true, nil)
+	expectedEnd := `	n1 -> n3 [style=dashed];
+	n3 -> n4;
+	n3 -> n5;
+	n5 -> n6;
+	n4 -> n6 [style=dashed];
+}
+`
+	if len(unrolled) < len(expectedEnd) ||
+		unrolled[len(unrolled)-len(expectedEnd):] != expectedEnd {
+		t.Errorf("unexpected unrolled graph:\n%s", unrolled)
+	}
+}
+
+func TestDOTSources(t *testing.T) {
+	parsed, err := Parse("Input(w=4, h=4, d=1)\nConv(w=3, h=3, n=1, same=1)\n" +
+		"Conv(w=3, h=3, n=1, p=1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph := DOT(block, false, src)
+	for _, label := range []string{
+		`"Conv\nw=3, h=3, n=1, sx=1, sy=1, same=1\n4x4x1"`,
+		`"Conv\nw=3, h=3, n=1, sx=1, sy=1, p=1\n4x4x1"`,
+	} {
+		if !strings.Contains(graph, label) {
+			t.Errorf("missing label %s in graph:\n%s", label, graph)
+		}
+	}
+	if graph := DOT(block, false, nil); strings.Contains(graph, "same=1") {
+		t.Errorf("unexpected same=1 without sources:\n%s", graph)
+	}
+}
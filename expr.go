@@ -0,0 +1,332 @@
+package convmarkup
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An Expr is an arithmetic expression, as found in
+// attribute values and let statements.
+type Expr interface {
+	// Eval evaluates the expression, looking up names in
+	// the environment.
+	Eval(env map[string]float64) (float64, error)
+
+	// String formats the expression in canonical form.
+	String() string
+}
+
+// Operator precedences, from loosest to tightest.
+const (
+	precSum = iota + 1
+	precProduct
+	precUnary
+	precAtom
+)
+
+type numberExpr float64
+
+func (n numberExpr) Eval(env map[string]float64) (float64, error) {
+	return float64(n), nil
+}
+
+func (n numberExpr) String() string {
+	return formatNumber(float64(n))
+}
+
+type nameExpr string
+
+func (n nameExpr) Eval(env map[string]float64) (float64, error) {
+	if val, ok := env[string(n)]; ok {
+		return val, nil
+	}
+	return 0, errors.New("undefined name: " + string(n))
+}
+
+func (n nameExpr) String() string {
+	return string(n)
+}
+
+type negExpr struct {
+	X Expr
+}
+
+func (n *negExpr) Eval(env map[string]float64) (float64, error) {
+	x, err := n.X.Eval(env)
+	if err != nil {
+		return 0, err
+	}
+	return -x, nil
+}
+
+func (n *negExpr) String() string {
+	return "-" + parenthesize(n.X, precUnary)
+}
+
+type binaryExpr struct {
+	Op byte
+	X  Expr
+	Y  Expr
+}
+
+func (b *binaryExpr) Eval(env map[string]float64) (float64, error) {
+	x, err := b.X.Eval(env)
+	if err != nil {
+		return 0, err
+	}
+	y, err := b.Y.Eval(env)
+	if err != nil {
+		return 0, err
+	}
+	switch b.Op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	if b.Op == '/' {
+		return x / y, nil
+	}
+	return math.Mod(x, y), nil
+}
+
+func (b *binaryExpr) String() string {
+	prec := exprPrec(b)
+	// Every operator is left-associative, so the right
+	// operand needs parentheses at equal precedence.
+	return parenthesize(b.X, prec) + " " + string(b.Op) + " " +
+		parenthesize(b.Y, prec+1)
+}
+
+func exprPrec(e Expr) int {
+	switch e := e.(type) {
+	case *binaryExpr:
+		if e.Op == '+' || e.Op == '-' {
+			return precSum
+		}
+		return precProduct
+	case *negExpr:
+		return precUnary
+	case numberExpr:
+		if e < 0 {
+			return precUnary
+		}
+	}
+	return precAtom
+}
+
+// parenthesize formats e, wrapping it in parentheses if it
+// binds more loosely than minPrec.
+func parenthesize(e Expr, minPrec int) string {
+	if exprPrec(e) < minPrec {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// parseExpr parses an arithmetic expression.
+//
+// Expressions may contain numbers, names, parentheses, the
+// binary operators +, -, *, /, and %, and unary minus.
+func parseExpr(s string) (Expr, error) {
+	p := &exprParser{tokens: tokenizeExpr(s)}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("missing expression")
+	}
+	res, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.New("unexpected " + strconv.Quote(p.tokens[p.pos]))
+	}
+	return res, nil
+}
+
+// tokenizeExpr splits an expression into tokens.
+// Numbers and names are single tokens, and every other
+// non-space character is a token of its own.
+func tokenizeExpr(s string) []string {
+	var res []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		i++
+		if c == ' ' || c == '\t' {
+			continue
+		} else if isDigit(c) || c == '.' {
+			for i < len(s) && (isDigit(s[i]) || s[i] == '.') {
+				i++
+			}
+		} else if isNameStart(c) {
+			for i < len(s) && (isNameStart(s[i]) || isDigit(s[i])) {
+				i++
+			}
+		}
+		res = append(res, s[start:i])
+	}
+	return res
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isName checks if a string is a valid name for a let
+// statement.
+func isName(s string) bool {
+	if s == "" || !isNameStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isNameStart(s[i]) && !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type exprParser struct {
+	tokens []string
+	pos    int
+}
+
+func (e *exprParser) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+func (e *exprParser) sum() (Expr, error) {
+	res, err := e.product()
+	if err != nil {
+		return nil, err
+	}
+	for e.peek() == "+" || e.peek() == "-" {
+		op := e.peek()[0]
+		e.pos++
+		y, err := e.product()
+		if err != nil {
+			return nil, err
+		}
+		res = &binaryExpr{Op: op, X: res, Y: y}
+	}
+	return res, nil
+}
+
+func (e *exprParser) product() (Expr, error) {
+	res, err := e.unary()
+	if err != nil {
+		return nil, err
+	}
+	for e.peek() == "*" || e.peek() == "/" || e.peek() == "%" {
+		op := e.peek()[0]
+		e.pos++
+		y, err := e.unary()
+		if err != nil {
+			return nil, err
+		}
+		res = &binaryExpr{Op: op, X: res, Y: y}
+	}
+	return res, nil
+}
+
+func (e *exprParser) unary() (Expr, error) {
+	if e.peek() != "-" {
+		return e.atom()
+	}
+	e.pos++
+	x, err := e.unary()
+	if err != nil {
+		return nil, err
+	}
+	if num, ok := x.(numberExpr); ok && num >= 0 {
+		// Fold negative literals, such as -1, into numbers.
+		return -num, nil
+	}
+	return &negExpr{X: x}, nil
+}
+
+func (e *exprParser) atom() (Expr, error) {
+	tok := e.peek()
+	e.pos++
+	switch {
+	case tok == "":
+		return nil, errors.New("unexpected end of expression")
+	case tok == "(":
+		res, err := e.sum()
+		if err != nil {
+			return nil, err
+		}
+		if e.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		e.pos++
+		return res, nil
+	case isDigit(tok[0]) || tok[0] == '.':
+		val, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number: %s", tok)
+		}
+		return numberExpr(val), nil
+	case isNameStart(tok[0]):
+		return nameExpr(tok), nil
+	}
+	return nil, errors.New("unexpected " + strconv.Quote(tok))
+}
+
+// evalExpr parses and evaluates an expression.
+func evalExpr(s string, env map[string]float64) (float64, error) {
+	e, err := parseExpr(strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return e.Eval(env)
+}
+
+// substituteExpr replaces every name in an expression
+// which is defined in env with its value.
+func substituteExpr(e Expr, env map[string]float64) Expr {
+	switch e := e.(type) {
+	case nameExpr:
+		if val, ok := env[string(e)]; ok {
+			return numberExpr(val)
+		}
+	case *negExpr:
+		return &negExpr{X: substituteExpr(e.X, env)}
+	case *binaryExpr:
+		return &binaryExpr{
+			Op: e.Op,
+			X:  substituteExpr(e.X, env),
+			Y:  substituteExpr(e.Y, env),
+		}
+	}
+	return e
+}
+
+// exprNames returns the names referenced by an expression,
+// in order of appearance.
+func exprNames(e Expr) []string {
+	switch e := e.(type) {
+	case nameExpr:
+		return []string{string(e)}
+	case *negExpr:
+		return exprNames(e.X)
+	case *binaryExpr:
+		return append(exprNames(e.X), exprNames(e.Y)...)
+	}
+	return nil
+}
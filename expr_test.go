@@ -0,0 +1,34 @@
+package convmarkup
+
+import "testing"
+
+func TestExprString(t *testing.T) {
+	tests := map[string]string{
+		"1":           "1",
+		"-1.50":       "-1.5",
+		"a+b*c":       "a + b * c",
+		"(a+b)*c":     "(a + b) * c",
+		"a-(b-c)":     "a - (b - c)",
+		"(a-b)-c":     "a - b - c",
+		"a/(b*c)":     "a / (b * c)",
+		"-(a+1)%2":    "-(a + 1) % 2",
+		"2*-3":        "2 * -3",
+		"--x":         "--x",
+		"((width))":   "width",
+		"x_1 * 0.25 ": "x_1 * 0.25",
+	}
+	for in, expected := range tests {
+		e, err := parseExpr(in)
+		if err != nil {
+			t.Errorf("%s: %s", in, err)
+			continue
+		}
+		if actual := e.String(); actual != expected {
+			t.Errorf("%s: expected %s but got %s", in, expected, actual)
+		}
+		reparsed, err := parseExpr(e.String())
+		if err != nil || reparsed.String() != expected {
+			t.Errorf("%s: formatted expression does not round-trip", in)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package convmarkup
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formatIndent is the indentation used for each level of
+// nesting in formatted markup.
+const formatIndent = "    "
+
+// attrOrders specifies the canonical attribute order for
+// built-in blocks.
+// Attributes which are not listed come after the listed
+// ones, in alphabetical order.
+var attrOrders = map[string][]string{
+	"Input":    {"w", "h", "d"},
+	"Assert":   {"w", "h", "d"},
+	"Conv":     {"w", "h", "n", "sx", "sy", "dx", "dy", "p", "same", "groups"},
+	"Deconv":   {"w", "h", "n", "sx", "sy", "ox", "oy"},
+	"MaxPool":  {"w", "h", "sx", "sy"},
+	"MeanPool": {"w", "h", "sx", "sy"},
+	"Padding":  {"t", "r", "b", "l"},
+	"Resize":   {"w", "h"},
+	"FC":       {"out"},
+	"Repeat":   {"n"},
+	"Linear":   {"scale", "bias"},
+	"Dropout":  {"prob"},
+}
+
+// Format converts an ASTNode back into markup.
+//
+// The result is in a canonical form: every block is on its
+// own line, nested blocks are indented by four spaces,
+// attributes appear in a consistent order, and blocks
+// without attributes omit their parentheses.
+// Macro definitions come first, each followed by a blank
+// line.
+// Comments, let statements, and other blank lines are not
+// preserved, since constants are substituted while
+// parsing.
+// Likewise, the contents of included files appear in
+// place of their Include directives.
+//
+// If the node is a root node, its children are formatted
+// at the top level.
+// Otherwise, the node itself is formatted.
+func Format(node *ASTNode) string {
+	var lines []string
+	if node.BlockName == "" {
+		for _, m := range node.Macros {
+			lines = formatMacro(lines, m)
+		}
+		for _, ch := range node.Children {
+			lines = formatNode(lines, ch, 0)
+		}
+	} else {
+		lines = formatNode(lines, node, 0)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func formatMacro(lines []string, m *Macro) []string {
+	header := "Define " + m.Name
+	if len(m.Params) > 0 {
+		header += "(" + strings.Join(m.Params, ", ") + ")"
+	}
+	lines = append(lines, header+" {")
+	for _, ch := range m.Body {
+		lines = formatNode(lines, ch, 1)
+	}
+	return append(lines, "}", "")
+}
+
+func formatNode(lines []string, node *ASTNode, depth int) []string {
+	indent := strings.Repeat(formatIndent, depth)
+	values := map[string]string{}
+	for key, val := range node.Attrs {
+		values[key] = formatNumber(val)
+	}
+	for key, e := range node.Exprs {
+		values[key] = e.String()
+	}
+	decl := indent + formatDecl(node.BlockName, values)
+	if len(node.Children) == 0 {
+		return append(lines, decl)
+	}
+	lines = append(lines, decl+" {")
+	for _, ch := range node.Children {
+		lines = formatNode(lines, ch, depth+1)
+	}
+	return append(lines, indent+"}")
+}
+
+// formatDecl formats a block name and its formatted
+// attribute values, without any indentation or curly
+// braces.
+func formatDecl(name string, values map[string]string) string {
+	if len(values) == 0 {
+		return name
+	}
+	return name + "(" + formatValues(name, values) + ")"
+}
+
+// formatAttrs formats the attributes of a block as a
+// comma-separated list in canonical order.
+func formatAttrs(blockName string, attrs map[string]float64) string {
+	values := map[string]string{}
+	for key, val := range attrs {
+		values[key] = formatNumber(val)
+	}
+	return formatValues(blockName, values)
+}
+
+// formatValues is like formatAttrs, but for attribute
+// values which have already been formatted.
+func formatValues(blockName string, values map[string]string) string {
+	var names []string
+	for key := range values {
+		names = append(names, key)
+	}
+	var parts []string
+	for _, key := range sortedAttrs(blockName, names) {
+		parts = append(parts, key+"="+values[key])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sortedAttrs sorts the attribute names of a block into
+// canonical order.
+func sortedAttrs(blockName string, names []string) []string {
+	present := map[string]bool{}
+	for _, key := range names {
+		present[key] = true
+	}
+	var res []string
+	for _, key := range attrOrders[blockName] {
+		if present[key] {
+			res = append(res, key)
+			delete(present, key)
+		}
+	}
+	var rest []string
+	for key := range present {
+		rest = append(rest, key)
+	}
+	sort.Strings(rest)
+	return append(res, rest...)
+}
+
+// formatNumber formats an attribute value so that it can
+// be parsed back without loss of precision.
+func formatNumber(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+// blockAttrs reconstructs the markup attributes for a
+// realized block.
+// Attributes which were left to their defaults are
+// included with their default values, except for the
+// padding, dilation, and groups of a Conv, which are only
+// included when they are used.
+//
+// The node that created the block may be nil.
+// If it is known, a Conv keeps same=1 instead of listing
+// the padding it produced.
+func blockAttrs(b Block, node *ASTNode) map[string]float64 {
+	switch b := b.(type) {
+	case *Input:
+		return dimsAttrs(b.Out)
+	case *Assert:
+		return dimsAttrs(b.In)
+	case *Conv:
+		res := map[string]float64{
+			"w":  float64(b.FilterWidth),
+			"h":  float64(b.FilterHeight),
+			"n":  float64(b.FilterCount),
+			"sx": float64(b.StrideX),
+			"sy": float64(b.StrideY),
+		}
+		if b.DilationX != 1 || b.DilationY != 1 {
+			res["dx"] = float64(b.DilationX)
+			res["dy"] = float64(b.DilationY)
+		}
+		if b.Groups != 1 {
+			res["groups"] = float64(b.Groups)
+		}
+		if node != nil && node.Attrs["same"] == 1 {
+			res["same"] = 1
+		} else if b.PadTop == b.PadRight && b.PadTop == b.PadBottom &&
+			b.PadTop == b.PadLeft {
+			if b.PadTop != 0 {
+				res["p"] = float64(b.PadTop)
+			}
+		} else {
+			// Asymmetric padding only comes from same=1.
+			res["same"] = 1
+		}
+		return res
+	case *Deconv:
+		return map[string]float64{
+			"w":  float64(b.FilterWidth),
+			"h":  float64(b.FilterHeight),
+			"n":  float64(b.FilterCount),
+			"sx": float64(b.StrideX),
+			"sy": float64(b.StrideY),
+			"ox": float64(b.OutPadX),
+			"oy": float64(b.OutPadY),
+		}
+	case *Pool:
+		return map[string]float64{
+			"w":  float64(b.Width),
+			"h":  float64(b.Height),
+			"sx": float64(b.StrideX),
+			"sy": float64(b.StrideY),
+		}
+	case *Padding:
+		return map[string]float64{
+			"t": float64(b.Top),
+			"r": float64(b.Right),
+			"b": float64(b.Bottom),
+			"l": float64(b.Left),
+		}
+	case *Resize:
+		return map[string]float64{
+			"w": float64(b.Out.Width),
+			"h": float64(b.Out.Height),
+		}
+	case *FC:
+		return map[string]float64{"out": float64(b.OutCount)}
+	case *Repeat:
+		return map[string]float64{"n": float64(b.N)}
+	case *Linear:
+		return map[string]float64{"scale": b.Scale, "bias": b.Bias}
+	case *Dropout:
+		return map[string]float64{"prob": b.Prob}
+	case *Debug:
+		res := map[string]float64{}
+		for k, v := range b.Attrs {
+			res[k] = v
+		}
+		return res
+	}
+	return map[string]float64{}
+}
+
+func dimsAttrs(d Dims) map[string]float64 {
+	return map[string]float64{
+		"w": float64(d.Width),
+		"h": float64(d.Height),
+		"d": float64(d.Depth),
+	}
+}
+
+// formatDims formats tensor dimensions as WxHxD.
+func formatDims(d Dims) string {
+	return fmt.Sprintf("%dx%dx%d", d.Width, d.Height, d.Depth)
+}
+
+// FormatSource reformats markup code in canonical form,
+// preserving comments, let statements, macro definitions,
+// and Include directives.
+//
+// Lines are indented according to their nesting depth,
+// block declarations are formatted as they are by Format,
+// and expressions are written in canonical form.
+// Runs of blank lines are collapsed into a single blank
+// line, and blank lines at the start and end of the code
+// are removed.
+//
+// The code is only checked for syntax errors, which are
+// returned as a *ParseError.
+// Errors in attribute values, such as undefined names, are
+// not detected.
+func FormatSource(contents string) (string, error) {
+	var lines []string
+	var depth int
+	var blank bool
+	for i, rawLine := range strings.Split(contents, "\n") {
+		line := strings.TrimSpace(rawLine)
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if line == "}" {
+			if depth == 0 {
+				return "", &ParseError{Message: "unexpected }", Line: i}
+			}
+			depth--
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		formatted, open, err := formatSourceLine(line)
+		if err != nil {
+			return "", &ParseError{Message: err.Error(), Line: i, Err: err}
+		}
+		lines = append(lines, strings.Repeat(formatIndent, depth)+formatted)
+		if open {
+			depth++
+		}
+	}
+	if depth > 0 {
+		return "", &ParseError{Message: "no matching }", Line: strings.Count(contents,
+			"\n")}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// formatSourceLine formats a non-empty line of code,
+// without indentation, and indicates whether the line
+// opens a curly brace.
+func formatSourceLine(line string) (string, bool, error) {
+	if line == "}" || strings.HasPrefix(line, "#") {
+		return line, false, nil
+	}
+	if parsed := defineExpr.FindStringSubmatch(line); parsed != nil {
+		res := "Define " + parsed[1]
+		if parsed[2] != "" {
+			var params []string
+			for _, p := range strings.Split(parsed[3], ",") {
+				params = append(params, strings.TrimSpace(p))
+			}
+			res += "(" + strings.Join(params, ", ") + ")"
+		}
+		return res + " {", true, nil
+	}
+	if parsed := letExpr.FindStringSubmatch(line); parsed != nil {
+		e, err := parseExpr(parsed[2])
+		if err != nil {
+			return "", false, err
+		}
+		return "let " + parsed[1] + " = " + e.String(), false, nil
+	}
+	if parsed := includeExpr.FindStringSubmatch(line); parsed != nil {
+		return "Include(" + strings.TrimSpace(parsed[1]) + ")", false, nil
+	}
+	parsed := commandExpr.FindStringSubmatch(line)
+	if parsed == nil {
+		return "", false, errors.New("invalid block declaration")
+	}
+	values := map[string]string{}
+	if parsed[3] != "" {
+		for i, x := range strings.Split(parsed[3], ",") {
+			arg := argExpr.FindStringSubmatch(x)
+			if arg == nil {
+				return "", false, fmt.Errorf("bad format for attribute %d", i)
+			}
+			if _, ok := values[arg[1]]; ok {
+				return "", false, fmt.Errorf("duplicate attribute: %s", arg[1])
+			}
+			e, err := parseExpr(arg[2])
+			if err != nil {
+				return "", false, fmt.Errorf("bad value for attribute %s: %s", arg[1], err)
+			}
+			values[arg[1]] = e.String()
+		}
+	}
+	res := formatDecl(parsed[1], values)
+	if parsed[4] != "" {
+		return res + " {", true, nil
+	}
+	return res, false, nil
+}
@@ -0,0 +1,118 @@
+package convmarkup
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	code := `# A comment.
+		Input(d=3.0, w=224,h=224)
+
+		Padding(l=1, r=1, b=1, t=1)
+		Conv(sy=2, n=64, w=3, h=3)
+		ReLU()
+		Residual {
+			Projection {
+				Conv(w=1, h=1, n=64)
+			}
+			Repeat(n=2) {
+				Linear(bias=-0.5, scale=1.25)
+			}
+		}
+		MyBlock(zeta=1, alpha=2)
+	`
+	expected := `Input(w=224, h=224, d=3)
+Padding(t=1, r=1, b=1, l=1)
+Conv(w=3, h=3, n=64, sy=2)
+ReLU
+Residual {
+    Projection {
+        Conv(w=1, h=1, n=64)
+    }
+    Repeat(n=2) {
+        Linear(scale=1.25, bias=-0.5)
+    }
+}
+MyBlock(alpha=2, zeta=1)
+`
+	parsed, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := Format(parsed)
+	if actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	reparsed, err := Parse(actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again := Format(reparsed); again != actual {
+		t.Errorf("formatting is not idempotent:\n%s", again)
+	}
+
+	child := Format(parsed.Children[4].Children[0])
+	if child != "Projection {\n    Conv(w=1, h=1, n=64)\n}\n" {
+		t.Errorf("unexpected child formatting: %q", child)
+	}
+}
+
+func TestFormatSource(t *testing.T) {
+	code := `
+
+# Constants.
+let  base=8*2
+
+Define Unit( n,k ) {
+  Conv(n=n*2,w=k,h=k, same=1)
+    ReLU
+}
+Include( "common.cm" )
+
+
+Input(d=3,w=32,h=32)
+Residual {
+	# The residual branch.
+	Unit(k=3, n=base)
+}
+`
+	expected := `# Constants.
+let base = 8 * 2
+
+Define Unit(n, k) {
+    Conv(w=k, h=k, n=n * 2, same=1)
+    ReLU
+}
+Include("common.cm")
+
+Input(w=32, h=32, d=3)
+Residual {
+    # The residual branch.
+    Unit(k=3, n=base)
+}
+`
+	actual, err := FormatSource(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	if again, err := FormatSource(actual); err != nil || again != actual {
+		t.Errorf("formatting is not idempotent:\n%s", again)
+	}
+
+	errorTests := map[string]string{
+		"ReLU\n}":                   "line 2: unexpected }",
+		"Residual {\nReLU":          "line 2: no matching }",
+		"Conv(w=3,, h=2)":           "line 1: bad format for attribute 1",
+		"Linear(scale=2 *)":         "line 1: bad value for attribute scale: unexpected end of expression",
+		"ReLU\nConv[w=3]":           "line 2: invalid block declaration",
+		"Input(w=1, w=1, h=1, d=1)": "line 1: duplicate attribute: w",
+	}
+	for code, expected := range errorTests {
+		_, err := FormatSource(code)
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q but got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package convmarkup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"nets/main.cm": &fstest.MapFile{Data: []byte(`Include("common/stem.cm")
+Residual {
+	Include("common/body.cm")
+}
+FC(out=classes)`)},
+		"nets/common/stem.cm": &fstest.MapFile{Data: []byte(`let classes = 10
+Input(w=8, h=8, d=3)
+Define Unit(n) {
+	Conv(w=3, h=3, n=n, same=1)
+}
+Unit(n=3)`)},
+		"nets/common/body.cm": &fstest.MapFile{Data: []byte(`# body
+Unit(n=3)
+ReLU`)},
+	}
+	root, err := ParseFS(fsys, "nets/main.cm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Define Unit(n) {
+    Conv(w=3, h=3, n=n, same=1)
+}
+
+Input(w=8, h=8, d=3)
+Unit(n=3)
+Residual {
+    Unit(n=3)
+    ReLU
+}
+FC(out=10)
+`
+	if actual := Format(root); actual != expected {
+		t.Errorf("unexpected result:\n%s", actual)
+	}
+
+	var locations []string
+	for _, ch := range root.Children {
+		locations = append(locations, position(ch.File, ch.Line))
+	}
+	for _, ch := range root.Children[2].Children {
+		locations = append(locations, position(ch.File, ch.Line))
+	}
+	expectedLocs := []string{
+		"nets/common/stem.cm: line 2",
+		"nets/common/stem.cm: line 6",
+		"nets/main.cm: line 2",
+		"nets/main.cm: line 5",
+		"nets/common/body.cm: line 2",
+		"nets/common/body.cm: line 3",
+	}
+	if !reflect.DeepEqual(locations, expectedLocs) {
+		t.Errorf("expected locations %v but got %v", expectedLocs, locations)
+	}
+	if root.Macros[0].File != "nets/common/stem.cm" {
+		t.Errorf("unexpected macro file: %s", root.Macros[0].File)
+	}
+
+	if _, err := root.Block(Dims{}, DefaultCreators()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseFSErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.cm":       &fstest.MapFile{Data: []byte("Input(w=1, h=1, d=1)\nInclude(\"b.cm\")")},
+		"b.cm":       &fstest.MapFile{Data: []byte("ReLU\nInclude(\"a.cm\")")},
+		"self.cm":    &fstest.MapFile{Data: []byte("Include(\"./self.cm\")")},
+		"missing.cm": &fstest.MapFile{Data: []byte("\nInclude(\"nothing.cm\")")},
+		"bad.cm":     &fstest.MapFile{Data: []byte("Include(nothing.cm)")},
+		"escape.cm":  &fstest.MapFile{Data: []byte("Include(\"../x.cm\")")},
+		"nested.cm":  &fstest.MapFile{Data: []byte("Residual {\nInclude(\"let.cm\")\n}")},
+		"let.cm":     &fstest.MapFile{Data: []byte("ReLU\nlet x = 3")},
+		"syntax.cm":  &fstest.MapFile{Data: []byte("Include(\"brace.cm\")")},
+		"brace.cm":   &fstest.MapFile{Data: []byte("ReLU\nResidual {\n")},
+		"block.cm":   &fstest.MapFile{Data: []byte("Input(w=1, h=1, d=1)\nInclude(\"fc.cm\")")},
+		"fc.cm":      &fstest.MapFile{Data: []byte("\n\nFC(out=0)")},
+	}
+	expected := map[string]*ParseError{
+		"a.cm": {File: "b.cm", Line: 1,
+			Message: "include cycle: a.cm -> b.cm -> a.cm"},
+		"self.cm": {File: "self.cm", Line: 0,
+			Message: "include cycle: self.cm -> self.cm"},
+		"bad.cm": {File: "bad.cm", Line: 0,
+			Message: "invalid file name: nothing.cm"},
+		"escape.cm": {File: "escape.cm", Line: 0,
+			Message: "invalid file name: \"../x.cm\""},
+		"nested.cm": {File: "let.cm", Line: 1,
+			Message: "let statements must be at the top level"},
+		"syntax.cm": {File: "brace.cm", Line: 1, Message: "no matching }"},
+	}
+	for name, expectedErr := range expected {
+		_, err := ParseFS(fsys, name)
+		if !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("%s: expected %v but got %v", name, expectedErr, err)
+		}
+	}
+
+	_, err := ParseFS(fsys, "missing.cm")
+	if pe, ok := err.(*ParseError); !ok || pe.File != "missing.cm" || pe.Line != 1 {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := ParseFS(fsys, "nonexistent.cm"); err == nil {
+		t.Error("expected error for nonexistent file")
+	}
+
+	root, err := ParseFS(fsys, "block.cm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.Block(Dims{}, DefaultCreators())
+	if err == nil || !strings.HasPrefix(err.Error(), "fc.cm: line 3: ") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = Parse("Include(\"a.cm\")")
+	expectedErr := "line 1: Include is not supported without a file system"
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("expected %q but got %v", expectedErr, err)
+	}
+}
+
+func TestParseAllFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.cm":  &fstest.MapFile{Data: []byte("Bad=1\nInclude(\"head.cm\")\nInclude(\"none.cm\")")},
+		"head.cm":  &fstest.MapFile{Data: []byte("ReLU\nFC(out=)\nInclude(\"main.cm\")")},
+		"other.cm": &fstest.MapFile{Data: []byte("ReLU")},
+	}
+	_, err := ParseAllFS(fsys, "main.cm")
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList but got %T", err)
+	}
+	var locations []string
+	for _, e := range errs {
+		locations = append(locations, position(e.File, e.Line))
+	}
+	expected := []string{
+		"main.cm: line 1",
+		"head.cm: line 2",
+		"head.cm: line 3",
+		"main.cm: line 3",
+	}
+	if !reflect.DeepEqual(locations, expected) {
+		t.Errorf("expected %v but got %v", expected, locations)
+	}
+	if root, err := ParseAllFS(fsys, "other.cm"); err != nil || len(root.Children) != 1 {
+		t.Errorf("unexpected result: %v, %v", root, err)
+	}
+}
+
+func TestParseFSDiamond(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.cm": &fstest.MapFile{Data: []byte(`Input(w=4, h=4, d=2)
+Include("a.cm")
+Include("b.cm")`)},
+		"a.cm": &fstest.MapFile{Data: []byte(`Include("common.cm")
+ReLU`)},
+		"b.cm": &fstest.MapFile{Data: []byte(`Include("common.cm")
+Tanh`)},
+		"common.cm": &fstest.MapFile{Data: []byte(`let depth = 2
+Define Unit(n) {
+	Conv(w=1, h=1, n=n)
+}
+Unit(n=depth)`)},
+	}
+	root, err := ParseFS(fsys, "main.cm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Define Unit(n) {
+    Conv(w=1, h=1, n=n)
+}
+
+Input(w=4, h=4, d=2)
+Unit(n=2)
+ReLU
+Unit(n=2)
+Tanh
+`
+	if actual := Format(root); actual != expected {
+		t.Errorf("unexpected result:\n%s", actual)
+	}
+
+	// Definitions in the main file may still not be
+	// repeated.
+	fsys["dup.cm"] = &fstest.MapFile{Data: []byte("Include(\"common.cm\")\nlet depth = 2")}
+	_, err = ParseFS(fsys, "dup.cm")
+	if err == nil || err.Error() != "dup.cm: line 2: duplicate definition: depth" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseFSUnclosedDefine(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.cm": &fstest.MapFile{Data: []byte(`Include("open.cm")
+Conv(w=1, h=1, n=x)`)},
+		"open.cm": &fstest.MapFile{Data: []byte(`Define Unit(x) {
+	ReLU`)},
+	}
+	root, err := ParseAllFS(fsys, "main.cm")
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList but got %T", err)
+	}
+	var messages []string
+	for _, e := range errs {
+		messages = append(messages, position(e.File, e.Line)+": "+e.Message)
+	}
+	expected := []string{
+		"open.cm: line 1: no matching }",
+		"main.cm: line 2: bad value for attribute n: undefined name: x",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %v but got %v", expected, messages)
+	}
+	if len(root.Macros) != 1 || len(root.Macros[0].Body) != 1 {
+		t.Errorf("unexpected macros: %v", root.Macros)
+	}
+}
@@ -0,0 +1,314 @@
+package convmarkup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// JSONVersion is the version of the JSON schema produced by
+// MarshalAST and MarshalBlock.
+//
+// The version is increased whenever the schema changes in
+// a way that older readers cannot handle.
+const JSONVersion = 2
+
+type jsonASTDoc struct {
+	Version int          `json:"version"`
+	Root    *jsonASTNode `json:"root"`
+}
+
+type jsonASTNode struct {
+	Block    string             `json:"block,omitempty"`
+	Attrs    map[string]float64 `json:"attrs,omitempty"`
+	Exprs    map[string]string  `json:"exprs,omitempty"`
+	Line     int                `json:"line"`
+	File     string             `json:"file,omitempty"`
+	Children []*jsonASTNode     `json:"children,omitempty"`
+	Macros   []*jsonMacro       `json:"macros,omitempty"`
+}
+
+type jsonMacro struct {
+	Name   string         `json:"name"`
+	Params []string       `json:"params,omitempty"`
+	Line   int            `json:"line"`
+	File   string         `json:"file,omitempty"`
+	Body   []*jsonASTNode `json:"body,omitempty"`
+}
+
+type jsonBlockDoc struct {
+	Version int        `json:"version"`
+	Block   *jsonBlock `json:"block"`
+}
+
+type jsonBlock struct {
+	Type       string                 `json:"type"`
+	Fields     map[string]interface{} `json:"fields,omitempty"`
+	Out        jsonDims               `json:"out"`
+	Children   []*jsonBlock           `json:"children,omitempty"`
+	Projection []*jsonBlock           `json:"projection,omitempty"`
+	Residual   []*jsonBlock           `json:"residual,omitempty"`
+	Branches   [][]*jsonBlock         `json:"branches,omitempty"`
+}
+
+type jsonDims struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Depth  int `json:"depth"`
+}
+
+// MarshalAST encodes an ASTNode and its children as JSON.
+//
+// The document is an object with a "version" field, which
+// is JSONVersion, and a "root" field containing the node.
+// Each node is an object with the fields:
+//
+//	block     the block name (omitted for the root)
+//	attrs     an object mapping attribute names to numbers
+//	exprs     an object mapping attribute names to
+//	          unevaluated expressions (in macro bodies)
+//	line      the line number, starting at 0
+//	file      the file name, if there is one
+//	children  an array of child nodes
+//	macros    an array of macros (on the root node)
+//
+// Each macro is an object with the fields "name",
+// "params", "line", "file", and "body", where body is an
+// array of nodes.
+//
+// Source spans are not encoded.
+func MarshalAST(a *ASTNode) ([]byte, error) {
+	return json.Marshal(&jsonASTDoc{Version: JSONVersion, Root: astToJSON(a)})
+}
+
+// UnmarshalAST decodes an ASTNode which was encoded with
+// MarshalAST.
+func UnmarshalAST(data []byte) (*ASTNode, error) {
+	var doc jsonASTDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal AST: %w", err)
+	}
+	if doc.Version == 0 {
+		return nil, errors.New("unmarshal AST: missing version")
+	} else if doc.Version > JSONVersion {
+		return nil, fmt.Errorf("unmarshal AST: unsupported version: %d", doc.Version)
+	} else if doc.Root == nil {
+		return nil, errors.New("unmarshal AST: missing root")
+	}
+	res, err := astFromJSON(doc.Root)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal AST: %w", err)
+	}
+	return res, nil
+}
+
+func astToJSON(a *ASTNode) *jsonASTNode {
+	res := &jsonASTNode{
+		Block: a.BlockName,
+		Attrs: a.Attrs,
+		Line:  a.Line,
+		File:  a.File,
+	}
+	if len(a.Exprs) > 0 {
+		res.Exprs = map[string]string{}
+		for name, e := range a.Exprs {
+			res.Exprs[name] = e.String()
+		}
+	}
+	for _, ch := range a.Children {
+		res.Children = append(res.Children, astToJSON(ch))
+	}
+	for _, m := range a.Macros {
+		jm := &jsonMacro{Name: m.Name, Params: m.Params, Line: m.Line, File: m.File}
+		for _, node := range m.Body {
+			jm.Body = append(jm.Body, astToJSON(node))
+		}
+		res.Macros = append(res.Macros, jm)
+	}
+	return res
+}
+
+func astFromJSON(j *jsonASTNode) (*ASTNode, error) {
+	res := newASTNode(j.Block, j.Attrs)
+	res.Line = j.Line
+	res.File = j.File
+	for name, str := range j.Exprs {
+		e, err := parseExpr(str)
+		if err != nil {
+			return nil, fmt.Errorf("%s: attribute %s: %w", position(j.File, j.Line),
+				name, err)
+		}
+		if res.Exprs == nil {
+			res.Exprs = map[string]Expr{}
+		}
+		res.Exprs[name] = e
+	}
+	for _, ch := range j.Children {
+		if ch == nil {
+			return nil, fmt.Errorf("%s: null child", position(j.File, j.Line))
+		}
+		node, err := astFromJSON(ch)
+		if err != nil {
+			return nil, err
+		}
+		res.Children = append(res.Children, node)
+	}
+	for _, jm := range j.Macros {
+		if jm == nil || !isName(jm.Name) {
+			return nil, errors.New("invalid macro")
+		}
+		m := &Macro{Name: jm.Name, Params: jm.Params, Line: jm.Line, File: jm.File}
+		for _, ch := range jm.Body {
+			if ch == nil {
+				return nil, fmt.Errorf("%s: null node in macro %s",
+					position(jm.File, jm.Line), jm.Name)
+			}
+			node, err := astFromJSON(ch)
+			if err != nil {
+				return nil, err
+			}
+			m.Body = append(m.Body, node)
+		}
+		res.Macros = append(res.Macros, m)
+	}
+	return res, nil
+}
+
+// MarshalBlock encodes a realized block tree as JSON, so
+// that it can be read by other tools.
+// There is no way to decode the result into a Block.
+//
+// The document is an object with a "version" field, which
+// is JSONVersion, and a "block" field containing the
+// block.
+// Each block is an object with the fields:
+//
+//	type        the result of Type
+//	fields      an object containing the block's settings
+//	out         the output dimensions
+//	children    the sub-blocks of a Root, Repeat,
+//	            Projection, or Branch
+//	projection  the projection of a Residual, if it has one
+//	residual    the residual mapping of a Residual
+//	branches    an array with the sub-blocks of each
+//	            branch of a Concat
+//
+// Dimensions are objects with the fields "width",
+// "height", and "depth".
+//
+// The fields of each block type are:
+//
+//	Conv      filter_width, filter_height, filter_count,
+//	          stride_x, stride_y, dilation_x, dilation_y,
+//	          groups, pad_top, pad_right, pad_bottom,
+//	          pad_left
+//	Deconv    filter_width, filter_height, filter_count,
+//	          stride_x, stride_y, out_pad_x, out_pad_y
+//	MaxPool   width, height, stride_x, stride_y
+//	MeanPool  width, height, stride_x, stride_y
+//	Padding   top, right, bottom, left
+//	FC        out_count
+//	Repeat    n
+//	Linear    scale, bias
+//	Dropout   prob
+//	Debug     attrs, an object with the block's attributes
+//
+// Other blocks, including custom blocks, have no fields.
+func MarshalBlock(b Block) ([]byte, error) {
+	return json.Marshal(&jsonBlockDoc{Version: JSONVersion, Block: blockToJSON(b)})
+}
+
+func blockToJSON(b Block) *jsonBlock {
+	res := &jsonBlock{
+		Type:   b.Type(),
+		Fields: blockFields(b),
+		Out:    dimsToJSON(b.OutDims()),
+	}
+	switch b := b.(type) {
+	case *Residual:
+		res.Projection = seqToJSON(b.Projection)
+		res.Residual = seqToJSON(b.Residual)
+	case *Concat:
+		for _, branch := range b.Branches {
+			res.Branches = append(res.Branches, seqToJSON(branch))
+		}
+	default:
+		for _, seq := range SubBlocks(b) {
+			res.Children = append(res.Children, seqToJSON(seq)...)
+		}
+	}
+	return res
+}
+
+func seqToJSON(blocks []Block) []*jsonBlock {
+	res := []*jsonBlock{}
+	for _, b := range blocks {
+		res = append(res, blockToJSON(b))
+	}
+	return res
+}
+
+// blockFields gets the fields of a built-in block, as
+// listed in the documentation for MarshalBlock.
+func blockFields(b Block) map[string]interface{} {
+	switch b := b.(type) {
+	case *Conv:
+		return map[string]interface{}{
+			"filter_width":  b.FilterWidth,
+			"filter_height": b.FilterHeight,
+			"filter_count":  b.FilterCount,
+			"stride_x":      b.StrideX,
+			"stride_y":      b.StrideY,
+			"dilation_x":    b.DilationX,
+			"dilation_y":    b.DilationY,
+			"groups":        b.Groups,
+			"pad_top":       b.PadTop,
+			"pad_right":     b.PadRight,
+			"pad_bottom":    b.PadBottom,
+			"pad_left":      b.PadLeft,
+		}
+	case *Deconv:
+		return map[string]interface{}{
+			"filter_width":  b.FilterWidth,
+			"filter_height": b.FilterHeight,
+			"filter_count":  b.FilterCount,
+			"stride_x":      b.StrideX,
+			"stride_y":      b.StrideY,
+			"out_pad_x":     b.OutPadX,
+			"out_pad_y":     b.OutPadY,
+		}
+	case *Pool:
+		return map[string]interface{}{
+			"width":    b.Width,
+			"height":   b.Height,
+			"stride_x": b.StrideX,
+			"stride_y": b.StrideY,
+		}
+	case *Padding:
+		return map[string]interface{}{
+			"top":    b.Top,
+			"right":  b.Right,
+			"bottom": b.Bottom,
+			"left":   b.Left,
+		}
+	case *FC:
+		return map[string]interface{}{"out_count": b.OutCount}
+	case *Repeat:
+		return map[string]interface{}{"n": b.N}
+	case *Linear:
+		return map[string]interface{}{"scale": b.Scale, "bias": b.Bias}
+	case *Dropout:
+		return map[string]interface{}{"prob": b.Prob}
+	case *Debug:
+		attrs := b.Attrs
+		if attrs == nil {
+			attrs = map[string]float64{}
+		}
+		return map[string]interface{}{"attrs": attrs}
+	}
+	return nil
+}
+
+func dimsToJSON(d Dims) jsonDims {
+	return jsonDims{Width: d.Width, Height: d.Height, Depth: d.Depth}
+}
@@ -0,0 +1,109 @@
+package convmarkup
+
+import (
+	"testing"
+)
+
+func TestMarshalAST(t *testing.T) {
+	markup := `Define Unit(n) {
+    Conv(w=3, h=3, n=n * 2, same=1)
+    ReLU
+}
+
+Input(w=4, h=4, d=2)
+Residual {
+    Unit(n=1)
+}
+FC(out=3)
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalAST(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := UnmarshalAST(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(decoded); actual != markup {
+		t.Errorf("expected:\n%s\ngot:\n%s", markup, actual)
+	}
+	if decoded.Children[1].Children[0].Line != 7 {
+		t.Errorf("unexpected line: %d", decoded.Children[1].Children[0].Line)
+	}
+	if _, err := decoded.Block(Dims{}, DefaultCreators()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnmarshalASTErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"root": {}}`:               "unmarshal AST: missing version",
+		`{"version": 3, "root": {}}`: "unmarshal AST: unsupported version: 3",
+		`{"version": 1}`:             "unmarshal AST: missing root",
+		`{"version": 1, "root": {"children": [{"block": "Conv", "line": 3, ` +
+			`"exprs": {"n": "n +"}}]}}`: "unmarshal AST: line 4: attribute n: " +
+			"unexpected end of expression",
+	}
+	for data, expected := range tests {
+		_, err := UnmarshalAST([]byte(data))
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q but got %v", expected, err)
+		}
+	}
+}
+
+func TestMarshalBlock(t *testing.T) {
+	parsed, err := Parse(`Input(w=2, h=2, d=1)
+Residual {
+	Projection {
+		Linear(scale=2)
+	}
+	ReLU
+}
+Concat {
+	Branch {
+	}
+	Branch {
+		Conv(w=1, h=1, n=2)
+	}
+}
+Repeat(n=2) {
+	MaxPool(w=1, h=1)
+}
+FC(out=3)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":2,"block":{"type":"","out":{"width":1,"height":1,"depth":3},` +
+		`"children":[` +
+		`{"type":"Input","out":{"width":2,"height":2,"depth":1}},` +
+		`{"type":"Residual","out":{"width":2,"height":2,"depth":1},` +
+		`"projection":[{"type":"Linear","fields":{"bias":0,"scale":2},` +
+		`"out":{"width":2,"height":2,"depth":1}}],` +
+		`"residual":[{"type":"ReLU","out":{"width":2,"height":2,"depth":1}}]},` +
+		`{"type":"Concat","out":{"width":2,"height":2,"depth":3},"branches":[[],` +
+		`[{"type":"Conv","fields":{"dilation_x":1,"dilation_y":1,"filter_count":2,` +
+		`"filter_height":1,"filter_width":1,"groups":1,"pad_bottom":0,"pad_left":0,` +
+		`"pad_right":0,"pad_top":0,"stride_x":1,"stride_y":1},` +
+		`"out":{"width":2,"height":2,"depth":2}}]]},` +
+		`{"type":"Repeat","fields":{"n":2},"out":{"width":2,"height":2,"depth":3},` +
+		`"children":[{"type":"MaxPool","fields":{"height":1,"stride_x":1,"stride_y":1,` +
+		`"width":1},"out":{"width":2,"height":2,"depth":3}}]},` +
+		`{"type":"FC","fields":{"out_count":3},` +
+		`"out":{"width":1,"height":1,"depth":3}}]}}`
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
@@ -0,0 +1,171 @@
+package convmarkup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keras generates the source code of a Python file which
+// defines a function that builds a Keras model equivalent
+// to r using the functional API.
+//
+// If in is not zero, it specifies the input dimensions of
+// r, and r needn't start with an Input block.
+// The model takes NHWC inputs.
+//
+// Padding blocks and Conv padding become ZeroPadding2D
+// layers.
+// Pooling layers always specify the pool size, so that
+// global pooling works as it does in PoolCreator, and
+// only specify strides when they differ from the pool
+// size.
+// FC becomes a Flatten and a Dense, and its output is
+// reshaped to 1x1x(out) to match the output dimensions of
+// the block.
+// Residual becomes an Add, and Linear becomes a Lambda.
+// The contents of each Repeat are built in a loop, so that
+// every iteration creates new layers.
+// Assert and Debug blocks are omitted.
+// Other blocks are not supported.
+func Keras(r *Root, in Dims, funcName string) (string, error) {
+	k := &kerasTarget{}
+	k.body.indent = 1
+	gen := newCodeGen(k)
+	if err := gen.generate(r, in, "x"); err != nil {
+		return "", fmt.Errorf("Keras export: %w", err)
+	}
+
+	var w codeWriter
+	w.line("from tensorflow import keras")
+	w.line("from tensorflow.keras import layers")
+	w.line("")
+	w.line("")
+	w.line("def %s():", funcName)
+	w.indent++
+	w.line(`"""Builds a model for inputs of shape (N, %d, %d, %d)."""`, k.in.Height,
+		k.in.Width, k.in.Depth)
+	w.buf.WriteString(k.body.String())
+	w.line("return keras.Model(inputs, x)")
+	return w.String(), nil
+}
+
+type kerasTarget struct {
+	in   Dims
+	body codeWriter
+}
+
+// apply emits code to apply a layer.
+func (k *kerasTarget) apply(dst, src, format string, args ...interface{}) {
+	k.body.line("%s = layers.%s(%s)", dst, fmt.Sprintf(format, args...), src)
+}
+
+func (k *kerasTarget) input(d Dims, dst string) {
+	k.in = d
+	k.body.line("inputs = keras.Input(shape=%s)", pyTuple(d.Height, d.Width, d.Depth))
+	k.body.line("%s = inputs", dst)
+}
+
+func (k *kerasTarget) assign(dst, src string) {
+	k.body.line("%s = %s", dst, src)
+}
+
+func (k *kerasTarget) add(dst, x, y string) {
+	k.apply(dst, "["+x+", "+y+"]", "Add()")
+}
+
+func (k *kerasTarget) concat(dst string, srcs []string) {
+	k.apply(dst, "["+strings.Join(srcs, ", ")+"]", "Concatenate()")
+}
+
+func (k *kerasTarget) repeat(n int, x string, body func(x string) error) error {
+	k.body.line("for _ in range(%d):", n)
+	k.body.indent++
+	defer func() {
+		k.body.indent--
+	}()
+	start := k.body.buf.Len()
+	if err := body(x); err != nil {
+		return err
+	}
+	if k.body.buf.Len() == start {
+		// The body only contained blocks without code.
+		k.body.line("pass")
+	}
+	return nil
+}
+
+func (k *kerasTarget) layer(b Block, in Dims, src, dst string) error {
+	switch b := b.(type) {
+	case *Conv:
+		if b.PadTop != 0 || b.PadRight != 0 || b.PadBottom != 0 || b.PadLeft != 0 {
+			k.apply(dst, src, "ZeroPadding2D(padding=(%s, %s))",
+				pyTuple(b.PadTop, b.PadBottom), pyTuple(b.PadLeft, b.PadRight))
+			src = dst
+		}
+		args := fmt.Sprintf("%d, %s", b.FilterCount, pyTuple(b.FilterHeight,
+			b.FilterWidth))
+		if b.StrideX != 1 || b.StrideY != 1 {
+			args += ", strides=" + pyTuple(b.StrideY, b.StrideX)
+		}
+		if b.DilationX != 1 || b.DilationY != 1 {
+			args += ", dilation_rate=" + pyTuple(b.DilationY, b.DilationX)
+		}
+		if b.Groups != 1 {
+			args += fmt.Sprintf(", groups=%d", b.Groups)
+		}
+		k.apply(dst, src, "Conv2D(%s)", args)
+	case *Deconv:
+		args := fmt.Sprintf("%d, %s", b.FilterCount, pyTuple(b.FilterHeight,
+			b.FilterWidth))
+		if b.StrideX != 1 || b.StrideY != 1 {
+			args += ", strides=" + pyTuple(b.StrideY, b.StrideX)
+		}
+		if b.OutPadX != 0 || b.OutPadY != 0 {
+			args += ", output_padding=" + pyTuple(b.OutPadY, b.OutPadX)
+		}
+		k.apply(dst, src, "Conv2DTranspose(%s)", args)
+	case *Pool:
+		op := "MaxPooling2D"
+		if b.Name == "MeanPool" {
+			op = "AveragePooling2D"
+		}
+		args := "pool_size=" + pyTuple(b.Height, b.Width)
+		if b.StrideX != b.Width || b.StrideY != b.Height {
+			args += ", strides=" + pyTuple(b.StrideY, b.StrideX)
+		}
+		k.apply(dst, src, "%s(%s)", op, args)
+	case *Padding:
+		k.apply(dst, src, "ZeroPadding2D(padding=(%s, %s))",
+			pyTuple(b.Top, b.Bottom), pyTuple(b.Left, b.Right))
+	case *Resize:
+		k.apply(dst, src, `Resizing(%d, %d, interpolation="bilinear")`, b.Out.Height,
+			b.Out.Width)
+	case *FC:
+		k.apply(dst, src, "Flatten()")
+		k.apply(dst, dst, "Dense(%d)", b.OutCount)
+		k.apply(dst, dst, "Reshape(%s)", pyTuple(1, 1, b.OutCount))
+	case *Linear:
+		k.apply(dst, src, "Lambda(lambda t: %s)", pyAffine("t", b.Scale, b.Bias))
+	case *Dropout:
+		k.apply(dst, src, "Dropout(%s)", formatNumber(b.Prob))
+	case *Activation:
+		switch b.Name {
+		case "BatchNorm":
+			k.apply(dst, src, "BatchNormalization(epsilon=%s)",
+				formatNumber(batchNormEpsilon))
+		case "ReLU":
+			k.apply(dst, src, "ReLU()")
+		case "Sigmoid":
+			k.apply(dst, src, `Activation("sigmoid")`)
+		case "Tanh":
+			k.apply(dst, src, `Activation("tanh")`)
+		case "Softmax":
+			k.apply(dst, src, "Softmax()")
+		default:
+			return fmt.Errorf("unsupported activation: %s", b.Name)
+		}
+	default:
+		return fmt.Errorf("unsupported block: %s", b.Type())
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package convmarkup
+
+import "testing"
+
+func TestKeras(t *testing.T) {
+	testGolden(t, "keras", ".py", func(r *Root) (string, error) {
+		return Keras(r, Dims{}, "build_model")
+	})
+}
+
+func TestKerasInputDims(t *testing.T) {
+	root := &Root{Children: []Block{&Activation{Name: "ReLU", Out: Dims{2, 3, 4}}}}
+	actual, err := Keras(root, Dims{2, 3, 4}, "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `from tensorflow import keras
+from tensorflow.keras import layers
+
+
+def build():
+    """Builds a model for inputs of shape (N, 3, 2, 4)."""
+    inputs = keras.Input(shape=(3, 2, 4))
+    x = inputs
+    x = layers.ReLU()(x)
+    return keras.Model(inputs, x)
+`
+	if actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	if _, err := Keras(root, Dims{}, "build"); err == nil {
+		t.Error("expected error without input")
+	}
+}
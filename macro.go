@@ -0,0 +1,93 @@
+package convmarkup
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A Macro is a user-defined block, which is declared with
+// a Define statement and expands to a sequence of blocks.
+type Macro struct {
+	// Line is the line number of the Define statement,
+	// starting at 0.
+	Line int
+
+	// File is the name of the file containing the Define
+	// statement, or "" if it was not parsed from a file.
+	File string
+
+	Name   string
+	Params []string
+
+	// Body contains the nodes which the macro expands to.
+	// Attributes which depend on the parameters are stored
+	// in the nodes' Exprs fields.
+	Body []*ASTNode
+}
+
+// parseDefine creates a Macro from the name and parameter
+// list of a Define statement.
+//
+// Parameter names may not be the names of let constants,
+// since constants are substituted into the body.
+func parseDefine(root *ASTNode, env map[string]float64, name,
+	params string) (*Macro, error) {
+	if name == "" {
+		return nil, errors.New("missing macro name")
+	}
+	for _, m := range root.Macros {
+		if m.Name == name {
+			return nil, errors.New("duplicate definition: " + name)
+		}
+	}
+	res := &Macro{Name: name}
+	if strings.TrimSpace(params) == "" {
+		return res, nil
+	}
+	for _, param := range strings.Split(params, ",") {
+		param = strings.TrimSpace(param)
+		if !isName(param) {
+			return nil, errors.New("invalid parameter name: " + strconv.Quote(param))
+		}
+		if _, ok := env[param]; ok {
+			return nil, errors.New("parameter conflicts with let constant: " + param)
+		}
+		if res.hasParam(param) {
+			return nil, errors.New("duplicate parameter: " + param)
+		}
+		res.Params = append(res.Params, param)
+	}
+	return res, nil
+}
+
+func (m *Macro) hasParam(name string) bool {
+	for _, p := range m.Params {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkParams ensures that every name is a parameter.
+func (m *Macro) checkParams(names []string) error {
+	for _, name := range names {
+		if !m.hasParam(name) {
+			return errors.New("undefined name: " + name)
+		}
+	}
+	return nil
+}
+
+// checkArgs ensures that a macro invocation provides
+// exactly the macro's parameters and has no children.
+func (m *Macro) checkArgs(args map[string]float64, children []*ASTNode) error {
+	if len(children) > 0 {
+		return ErrUnexpectedChildren
+	}
+	if err := onlyTheseAttrs(args, m.Params...); err != nil {
+		return err
+	}
+	return hasAllAttrs(args, m.Params...)
+}
@@ -0,0 +1,181 @@
+package convmarkup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMacroExpansion(t *testing.T) {
+	markup := `let base = 4
+Input(w=8, h=8, d=4)
+Define Block(n, s) {
+	Conv(w=1, h=1, n=n, sx=s, sy=s)
+	Scale(k=n/base)
+}
+Define Scale(k) {
+	Linear(scale=k*2)
+}
+Block(n=8, s=2)
+Residual {
+	Block(n=8, s=1)
+}
+Scale(k=base)
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Macros) != 2 {
+		t.Fatalf("expected 2 macros but got %d", len(parsed.Macros))
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Dims{Width: 4, Height: 4, Depth: 8}
+	expected := &Root{
+		Children: []Block{
+			&Input{Out: Dims{Width: 8, Height: 8, Depth: 4}},
+			&Conv{FilterWidth: 1, FilterHeight: 1, FilterCount: 8, StrideX: 2,
+				StrideY: 2, DilationX: 1, DilationY: 1, Groups: 1, Out: out},
+			&Linear{Scale: 4, In: out},
+			&Residual{
+				Residual: []Block{
+					&Conv{FilterWidth: 1, FilterHeight: 1, FilterCount: 8, StrideX: 1,
+						StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1, Out: out},
+					&Linear{Scale: 4, In: out},
+				},
+			},
+			&Linear{Scale: 8, In: out},
+		},
+	}
+	if !reflect.DeepEqual(block, expected) {
+		t.Errorf("expected %#v but got %#v", expected, block)
+	}
+	if line := src.Line(block.(*Root).Children[2]); line != 7 {
+		t.Errorf("expected line 7 but got %d", line)
+	}
+
+	formatted := Format(parsed)
+	expectedFormat := `Define Block(n, s) {
+    Conv(w=1, h=1, n=n, sx=s, sy=s)
+    Scale(k=n / 4)
+}
+
+Define Scale(k) {
+    Linear(scale=k * 2)
+}
+
+Input(w=8, h=8, d=4)
+Block(n=8, s=2)
+Residual {
+    Block(n=8, s=1)
+}
+Scale(k=4)
+`
+	if formatted != expectedFormat {
+		t.Errorf("unexpected formatting:\n%s", formatted)
+	}
+	reparsed, err := Parse(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block1, err := reparsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(block1, block) {
+		t.Error("formatted markup produced a different block")
+	}
+}
+
+func TestMacroSubtree(t *testing.T) {
+	parsed, err := Parse(`Define Unit(n) {
+	Conv(w=1, h=1, n=n)
+}
+Input(w=2, h=2, d=3)
+Residual {
+	Unit(n=3)
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := Dims{Width: 2, Height: 2, Depth: 3}
+	residual := parsed.Children[1]
+	_, err = residual.Block(in, DefaultCreators())
+	if err == nil || !strings.Contains(err.Error(), "missing creator for Unit") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	withMacros := *residual
+	withMacros.Macros = parsed.Macros
+	block, err := withMacros.Block(in, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := block.OutDims(); out != in {
+		t.Errorf("unexpected output: %v", out)
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	input := "Input(w=8, h=8, d=4)\n"
+	parseErrors := []string{
+		"Define Foo(a, a) {\n}",
+		"Define Foo(1a) {\n}",
+		"Define Foo {\n}\nDefine Foo {\n}",
+		"Define Foo(a) {\nConv(w=b, h=1, n=1)\n}",
+		"Define Foo(a) {\nlet x = 3\n}",
+		"Define Foo(a) {\nDefine Bar {\n}\n}",
+		"Define Foo(a) {\n",
+		"let a = 3\nDefine Foo(a) {\n}",
+	}
+	for i, x := range parseErrors {
+		if _, err := Parse(x); err == nil {
+			t.Errorf("parse test %d should have failed", i)
+		}
+	}
+
+	blockErrors := map[string]string{
+		"Define Foo(n) {\nConv(w=1, h=1, n=n)\n}\n" + input + "Foo":                 "line 5: missing attribute: n",
+		"Define Foo(n) {\nConv(w=1, h=1, n=n)\n}\n" + input + "Foo(n=1, m=2)":       "line 5: unexpected attribute: m",
+		"Define Foo(n) {\nConv(w=1, h=1, n=n)\n}\n" + input + "Foo(n=1) {\nReLU\n}": "line 5: unexpected children",
+		"Define Foo(n) {\nConv(w=9, h=1, n=n)\nFC(out=0)\n}\n" + input + "Foo(n=1)": "line 6: in Foo: line 3: attribute out cannot be 0",
+		"Define Foo(n) {\nConv(w=1, h=1, n=n/0)\n}\n" + input + "Foo(n=1)":          "line 5: in Foo: line 2: bad value for attribute n: division by zero",
+		"Define Foo {\nBar\n}\nDefine Bar {\nFoo\n}\n" + input + "Foo":              "line 8: in Foo: line 2: in Bar: line 5: recursive macro: Foo",
+		"Define ReLU {\n}\n" + input + "ReLU":                                       "line 1: macro ReLU conflicts with an existing block",
+	}
+	for markup, expected := range blockErrors {
+		parsed, err := Parse(markup)
+		if err != nil {
+			t.Errorf("%q: %s", markup, err)
+			continue
+		}
+		_, err = parsed.Block(Dims{}, DefaultCreators())
+		if err == nil {
+			t.Errorf("%q: expected error", markup)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q: expected error %q but got %q", markup, expected, err)
+		}
+	}
+}
+
+func TestMacroErrorSpans(t *testing.T) {
+	markup := "Define Foo(n) {\nConv(w=1, h=1, n=n)\n}\nInput(w=8, h=8, d=4)\n"
+	spans := map[string]Span{
+		"Foo(n=0)":      {Start: Pos{Line: 4, Col: 0}, End: Pos{Line: 4, Col: 3}},
+		"Foo(n=1, m=2)": {Start: Pos{Line: 4, Col: 9}, End: Pos{Line: 4, Col: 12}},
+	}
+	for call, expected := range spans {
+		parsed, err := Parse(markup + call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = parsed.Block(Dims{}, DefaultCreators())
+		if pe, ok := err.(*ParseError); !ok || pe.Span == nil || *pe.Span != expected {
+			t.Errorf("%s: expected span %v but got error %#v", call, expected, err)
+		}
+	}
+}
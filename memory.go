@@ -0,0 +1,244 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+)
+
+// A MemoryRow describes the activation memory of one
+// block.
+type MemoryRow struct {
+	// Line is the line number (starting at 0) of the block,
+	// or -1 if it is unknown.
+	Line int
+
+	// Depth is the nesting depth of the block, where the
+	// top-level blocks have depth 0, as in SummaryRow.
+	Depth int
+
+	Type string
+
+	// Bytes is the size of the block's output for the whole
+	// batch.
+	Bytes int
+
+	// Count is the number of times the block is applied,
+	// which is greater than 1 inside of Repeat blocks.
+	Count int
+}
+
+// A MemoryReport estimates the memory needed to run a
+// block tree.
+// All sizes are in bytes.
+type MemoryReport struct {
+	// Rows contains a row for every block that produces a
+	// new tensor, in order of appearance.
+	Rows []MemoryRow
+
+	// Activations is the total size of every tensor
+	// produced in a forward pass, including the input.
+	// If there is no Input block, the input is not listed
+	// in Rows, but it is still counted here.
+	Activations int
+
+	// Peak is the largest amount of memory used by tensors
+	// at any one time during inference.
+	Peak int
+
+	// Params is the size of the parameters, including
+	// non-trainable ones.
+	Params int
+
+	// Training estimates the memory needed for a training
+	// step, where every activation is kept for the backward
+	// pass, and every trainable parameter has a gradient.
+	// Optimizer state and temporary buffers are not
+	// included.
+	Training int
+}
+
+// EstimateMemory produces a MemoryReport for a block and
+// all of its sub-blocks, given the batch size and the
+// number of bytes per tensor element.
+//
+// Every block which computes a new tensor is assumed to
+// allocate its output, so in-place operations are not
+// taken into account.
+// Assert, Debug, and Dropout blocks pass their inputs
+// through, as they do at inference time.
+// During inference, a tensor is freed as soon as it is no
+// longer needed, except that the input of a Residual (or
+// the output of its projection) stays alive until it is
+// added to the output of the residual mapping.
+//
+// If b is a Root, its children are the top-level blocks.
+//
+// The in argument specifies the block's input dimensions.
+// For a Root, Dims{} suffices.
+//
+// The SourceMap is used to look up line numbers.
+// It may be nil.
+func EstimateMemory(b Block, in Dims, batch, elemSize int, src SourceMap) *MemoryReport {
+	sim := &memorySim{batch: batch, elemSize: elemSize}
+	res := &MemoryReport{}
+	var gradients int
+	var hasInput bool
+	_, isRoot := b.(*Root)
+	Walk(b, in, func(step WalkStep) error {
+		for _, p := range Params(step.Block, step.In) {
+			size := p.Size() * elemSize * step.Count
+			res.Params += size
+			if !p.NonTrainable {
+				gradients += size
+			}
+		}
+		if !allocatesOutput(step.Block) {
+			return nil
+		}
+		if _, ok := step.Block.(*Input); ok {
+			hasInput = true
+		}
+		row := MemoryRow{
+			Line:  src.Line(step.Block),
+			Depth: step.Depth,
+			Type:  step.Block.Type(),
+			Bytes: sim.size(step.Block.OutDims()),
+			Count: step.Count,
+		}
+		if isRoot {
+			// The children of the Root are the top-level
+			// blocks.
+			row.Depth--
+		}
+		res.Rows = append(res.Rows, row)
+		res.Activations += row.Bytes * row.Count
+		return nil
+	})
+	if !hasInput {
+		res.Activations += sim.size(in)
+	}
+	sim.use(sim.size(in))
+	sim.block(b, in, 0, sim.size(in))
+	res.Peak = sim.peak
+	res.Training = res.Activations + res.Params + gradients
+	return res
+}
+
+// String renders the report as a table.
+func (m *MemoryReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tBLOCK\tBYTES\tCOUNT")
+	for _, row := range m.Rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", formatLine(row.Line), row.Type, row.Bytes,
+			row.Count)
+	}
+	fmt.Fprintf(w, "\tActivations\t%d\n", m.Activations)
+	fmt.Fprintf(w, "\tPeak\t%d\n", m.Peak)
+	fmt.Fprintf(w, "\tParams\t%d\n", m.Params)
+	fmt.Fprintf(w, "\tTraining\t%d\n", m.Training)
+	w.Flush()
+	return buf.String()
+}
+
+// allocatesOutput checks if a block produces a new tensor,
+// rather than passing through its input or the output of
+// its sub-blocks.
+func allocatesOutput(b Block) bool {
+	switch b.(type) {
+	case *Root, *Assert, *Debug, *Dropout, *Projection, *Branch, *Repeat:
+		return false
+	}
+	return true
+}
+
+// memorySim tracks the peak memory usage of inference.
+type memorySim struct {
+	batch    int
+	elemSize int
+	peak     int
+}
+
+func (m *memorySim) size(d Dims) int {
+	return d.Volume() * m.batch * m.elemSize
+}
+
+func (m *memorySim) use(live int) {
+	if live > m.peak {
+		m.peak = live
+	}
+}
+
+// seq simulates a sequence of blocks.
+//
+// The held argument is the size of the tensors kept alive
+// by enclosing blocks, and cur is the size of the input,
+// which is 0 if the input is one of the held tensors.
+//
+// The result is the size of the output, which is 0 if the
+// output is the input itself.
+func (m *memorySim) seq(blocks []Block, in Dims, held, cur int) int {
+	for _, b := range blocks {
+		cur = m.block(b, in, held, cur)
+		in = b.OutDims()
+	}
+	return cur
+}
+
+// block is like seq, but for a single block.
+func (m *memorySim) block(b Block, in Dims, held, cur int) int {
+	switch b := b.(type) {
+	case *Input:
+		out := m.size(b.OutDims())
+		m.use(held + out)
+		return out
+	case *Assert, *Debug, *Dropout:
+		return cur
+	case *Root:
+		return m.seq(b.Children, in, held, cur)
+	case *Projection:
+		return m.seq(b.Children, in, held, cur)
+	case *Branch:
+		return m.seq(b.Children, in, held, cur)
+	case *Repeat:
+		// Every iteration after the first one starts in the
+		// same state, so two iterations give the peak.
+		for i := 0; i < b.N && i < 2; i++ {
+			cur = m.seq(b.Children, in, held, cur)
+		}
+		return cur
+	case *Residual:
+		skip, bodyIn := cur, 0
+		if len(b.Projection) > 0 {
+			if proj := m.seq(b.Projection, in, held+cur, 0); proj != 0 {
+				skip, bodyIn = proj, cur
+			}
+		}
+		body := m.seq(b.Residual, in, held+skip, bodyIn)
+		if body == 0 {
+			body = bodyIn
+		}
+		out := m.size(b.OutDims())
+		m.use(held + skip + body + out)
+		return out
+	case *Concat:
+		var outs int
+		var keepInput bool
+		for _, branch := range b.Branches {
+			out := m.seq(branch, in, held+cur+outs, 0)
+			keepInput = keepInput || out == 0
+			outs += out
+		}
+		out := m.size(b.OutDims())
+		if keepInput {
+			m.use(held + cur + outs + out)
+		} else {
+			m.use(held + outs + out)
+		}
+		return out
+	}
+	out := m.size(b.OutDims())
+	m.use(held + cur + out)
+	return out
+}
@@ -0,0 +1,94 @@
+package convmarkup
+
+import "testing"
+
+func TestEstimateMemory(t *testing.T) {
+	markup := `Input(w=4, h=4, d=2)
+Conv(w=1, h=1, n=4)
+BatchNorm
+Residual {
+	ReLU
+	Repeat(n=3) {
+		Linear(scale=2)
+	}
+}
+Dropout(prob=0.5)
+Concat {
+	Branch {
+	}
+	Branch {
+		Conv(w=1, h=1, n=4)
+	}
+}
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := EstimateMemory(block, Dims{}, 2, 4, src)
+
+	expected := []MemoryRow{
+		{Line: 0, Depth: 0, Type: "Input", Bytes: 256, Count: 1},
+		{Line: 1, Depth: 0, Type: "Conv", Bytes: 512, Count: 1},
+		{Line: 2, Depth: 0, Type: "BatchNorm", Bytes: 512, Count: 1},
+		{Line: 3, Depth: 0, Type: "Residual", Bytes: 512, Count: 1},
+		{Line: 4, Depth: 1, Type: "ReLU", Bytes: 512, Count: 1},
+		{Line: 6, Depth: 2, Type: "Linear", Bytes: 512, Count: 3},
+		{Line: 10, Depth: 0, Type: "Concat", Bytes: 1024, Count: 1},
+		{Line: 14, Depth: 1, Type: "Conv", Bytes: 512, Count: 1},
+	}
+	if len(report.Rows) != len(expected) {
+		t.Fatalf("expected %d rows but got %d", len(expected), len(report.Rows))
+	}
+	for i, x := range expected {
+		if report.Rows[i] != x {
+			t.Errorf("row %d: expected %v but got %v", i, x, report.Rows[i])
+		}
+	}
+	if report.Activations != 5376 {
+		t.Errorf("expected activations 5376 but got %d", report.Activations)
+	}
+	// The Concat holds its input, the output of the second
+	// branch, and its own output.
+	if report.Peak != 2048 {
+		t.Errorf("expected peak 2048 but got %d", report.Peak)
+	}
+	if report.Params != 192 {
+		t.Errorf("expected params 192 but got %d", report.Params)
+	}
+	if report.Training != 5376+192+160 {
+		t.Errorf("expected training %d but got %d", 5376+192+160, report.Training)
+	}
+}
+
+func TestEstimateMemoryProjection(t *testing.T) {
+	markup := `Residual {
+	Projection {
+		Conv(w=1, h=1, n=4)
+	}
+	Conv(w=1, h=1, n=4)
+}
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := Dims{Width: 4, Height: 4, Depth: 2}
+	block, err := parsed.Block(in, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := EstimateMemory(block, in, 2, 4, nil)
+	if report.Activations != 256+512*3 {
+		t.Errorf("expected activations %d but got %d", 256+512*3, report.Activations)
+	}
+	// The projection output and the residual output are
+	// both alive while the sum is computed.
+	if report.Peak != 512*3 {
+		t.Errorf("expected peak %d but got %d", 512*3, report.Peak)
+	}
+}
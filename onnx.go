@@ -0,0 +1,885 @@
+package convmarkup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ONNX versions produced by WriteONNX.
+const (
+	onnxIRVersion = 7
+	onnxOpset     = 13
+)
+
+// Field numbers from the ONNX protocol buffer definitions.
+const (
+	onnxModelIRVersion    = 1
+	onnxModelProducerName = 2
+	onnxModelGraph        = 7
+	onnxModelOpsetImport  = 8
+
+	onnxOpsetDomain  = 1
+	onnxOpsetVersion = 2
+
+	onnxGraphNode        = 1
+	onnxGraphName        = 2
+	onnxGraphInitializer = 5
+	onnxGraphInput       = 11
+	onnxGraphOutput      = 12
+
+	onnxNodeInput     = 1
+	onnxNodeOutput    = 2
+	onnxNodeName      = 3
+	onnxNodeOpType    = 4
+	onnxNodeAttribute = 5
+
+	onnxAttrName   = 1
+	onnxAttrFloat  = 2
+	onnxAttrInt    = 3
+	onnxAttrString = 4
+	onnxAttrFloats = 7
+	onnxAttrInts   = 8
+	onnxAttrType   = 20
+
+	onnxTensorDims      = 1
+	onnxTensorDataType  = 2
+	onnxTensorFloatData = 4
+	onnxTensorInt64Data = 7
+	onnxTensorName      = 8
+	onnxTensorRawData   = 9
+
+	onnxValueName = 1
+	onnxValueType = 2
+
+	onnxTypeTensor     = 1
+	onnxTensorElemType = 1
+	onnxTensorShape    = 2
+	onnxShapeDim       = 1
+	onnxDimValue       = 1
+	onnxDimParam       = 2
+)
+
+// Enum values from the ONNX protocol buffer definitions.
+const (
+	onnxTypeFloat  = 1
+	onnxTypeInt    = 2
+	onnxTypeString = 3
+	onnxTypeFloats = 6
+	onnxTypeInts   = 7
+
+	onnxDataFloat = 1
+	onnxDataInt64 = 7
+)
+
+// onnxActivations maps activation names to ONNX operators.
+var onnxActivations = map[string]string{
+	"ReLU":    "Relu",
+	"Sigmoid": "Sigmoid",
+	"Tanh":    "Tanh",
+	"Softmax": "Softmax",
+}
+
+// onnxGraph is an ONNX graph.
+type onnxGraph struct {
+	Nodes        []*onnxNode
+	Initializers []*onnxTensor
+	Inputs       []*onnxValueInfo
+	Outputs      []*onnxValueInfo
+
+	// Opset is the version of the default operator set.
+	// When encoding, 0 means onnxOpset.
+	Opset int
+}
+
+type onnxNode struct {
+	Name    string
+	OpType  string
+	Inputs  []string
+	Outputs []string
+	Attrs   []*onnxAttr
+}
+
+// attr finds an attribute by name, or returns nil.
+func (o *onnxNode) attr(name string) *onnxAttr {
+	for _, a := range o.Attrs {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+type onnxAttr struct {
+	Name   string
+	Type   int
+	Float  float32
+	Int    int64
+	Ints   []int64
+	String string
+}
+
+type onnxTensor struct {
+	Name     string
+	Dims     []int64
+	DataType int
+	Floats   []float32
+	Int64s   []int64
+}
+
+// onnxValueInfo describes a graph input or output.
+// A dimension of -1 indicates the batch dimension.
+type onnxValueInfo struct {
+	Name string
+	Dims []int64
+}
+
+func (g *onnxGraph) encodeModel() []byte {
+	opset := g.Opset
+	if opset == 0 {
+		opset = onnxOpset
+	}
+	var w protoWriter
+	w.int64(onnxModelIRVersion, onnxIRVersion)
+	w.string(onnxModelProducerName, "convmarkup")
+	w.message(onnxModelGraph, g.encode)
+	w.message(onnxModelOpsetImport, func(w *protoWriter) {
+		w.string(onnxOpsetDomain, "")
+		w.int64(onnxOpsetVersion, int64(opset))
+	})
+	return w.buf
+}
+
+func (g *onnxGraph) encode(w *protoWriter) {
+	for _, node := range g.Nodes {
+		w.message(onnxGraphNode, node.encode)
+	}
+	w.string(onnxGraphName, "convmarkup")
+	for _, t := range g.Initializers {
+		w.message(onnxGraphInitializer, t.encode)
+	}
+	for _, v := range g.Inputs {
+		w.message(onnxGraphInput, v.encode)
+	}
+	for _, v := range g.Outputs {
+		w.message(onnxGraphOutput, v.encode)
+	}
+}
+
+func (o *onnxNode) encode(w *protoWriter) {
+	for _, in := range o.Inputs {
+		w.string(onnxNodeInput, in)
+	}
+	for _, out := range o.Outputs {
+		w.string(onnxNodeOutput, out)
+	}
+	w.string(onnxNodeName, o.Name)
+	w.string(onnxNodeOpType, o.OpType)
+	for _, a := range o.Attrs {
+		w.message(onnxNodeAttribute, a.encode)
+	}
+}
+
+func (o *onnxAttr) encode(w *protoWriter) {
+	w.string(onnxAttrName, o.Name)
+	switch o.Type {
+	case onnxTypeFloat:
+		w.float32(onnxAttrFloat, o.Float)
+	case onnxTypeInt:
+		w.int64(onnxAttrInt, o.Int)
+	case onnxTypeString:
+		w.string(onnxAttrString, o.String)
+	case onnxTypeInts:
+		w.packedInt64s(onnxAttrInts, o.Ints)
+	}
+	w.int64(onnxAttrType, int64(o.Type))
+}
+
+func (o *onnxTensor) encode(w *protoWriter) {
+	w.packedInt64s(onnxTensorDims, o.Dims)
+	w.int64(onnxTensorDataType, int64(o.DataType))
+	w.string(onnxTensorName, o.Name)
+	var raw []byte
+	if o.DataType == onnxDataFloat {
+		for _, x := range o.Floats {
+			raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(x))
+		}
+	} else {
+		for _, x := range o.Int64s {
+			raw = binary.LittleEndian.AppendUint64(raw, uint64(x))
+		}
+	}
+	w.bytes(onnxTensorRawData, raw)
+}
+
+func (o *onnxValueInfo) encode(w *protoWriter) {
+	w.string(onnxValueName, o.Name)
+	w.message(onnxValueType, func(w *protoWriter) {
+		w.message(onnxTypeTensor, func(w *protoWriter) {
+			w.int64(onnxTensorElemType, onnxDataFloat)
+			w.message(onnxTensorShape, func(w *protoWriter) {
+				for _, d := range o.Dims {
+					w.message(onnxShapeDim, func(w *protoWriter) {
+						if d < 0 {
+							w.string(onnxDimParam, "N")
+						} else {
+							w.int64(onnxDimValue, d)
+						}
+					})
+				}
+			})
+		})
+	})
+}
+
+// WriteONNX encodes a block as an ONNX model and writes it
+// to w.
+//
+// The in argument specifies the block's input dimensions.
+// For a Root which starts with an Input block, Dims{}
+// suffices.
+// Tensors in the model are in NCHW order, and the batch
+// dimension is left unspecified.
+//
+// Parameters are stored as initializers, whose values come
+// from the WeightSource.
+// Weights are expected in the layout described by Params,
+// and fully-connected weights are reordered to match the
+// NCHW layout.
+// As in CPURealizer, every copy of the contents of a Repeat
+// gets its own parameters.
+//
+// Conv, MaxPool, MeanPool, Padding, BatchNorm, and the
+// ReLU, Sigmoid, Tanh, and Softmax activations map
+// directly to ONNX operators.
+// Deconv becomes a ConvTranspose, and Concat becomes a
+// Concat along the channel axis.
+// Resize becomes a bilinear Resize, and since the batch
+// size is unknown, its output shape is computed from the
+// shape of its input.
+// FC becomes a Flatten followed by a Gemm, and the result
+// is only reshaped back into an image when a later block
+// requires it.
+// Residual becomes an Add, Linear becomes a Mul and an
+// Add, and Dropout is exported as an inference-mode
+// Dropout.
+// Assert and Debug blocks are omitted.
+// Custom blocks are not supported.
+func WriteONNX(w io.Writer, b Block, in Dims, weights WeightSource) error {
+	o := &onnxWriter{weights: weights}
+	var x onnxValue
+	if in != (Dims{}) {
+		x = o.input(in)
+	}
+	x, err := o.write(b, in, x)
+	if err != nil {
+		return err
+	}
+	if x.Name == "" {
+		return fmt.Errorf("ONNX export: missing Input block")
+	}
+
+	out := b.OutDims()
+	outInfo := &onnxValueInfo{Name: "output", Dims: []int64{-1, int64(out.Depth)}}
+	if !x.Flat {
+		outInfo.Dims = append(outInfo.Dims, int64(out.Height), int64(out.Width))
+	}
+	if len(o.graph.Nodes) == 0 {
+		o.node("Identity", []string{x.Name})
+	}
+	o.graph.Nodes[len(o.graph.Nodes)-1].Outputs[0] = outInfo.Name
+	o.graph.Outputs = []*onnxValueInfo{outInfo}
+
+	_, err = w.Write(o.graph.encodeModel())
+	return err
+}
+
+// onnxWriter builds an ONNX graph for a block tree.
+type onnxWriter struct {
+	weights WeightSource
+	graph   onnxGraph
+}
+
+// An onnxValue is a tensor in an ONNX graph.
+//
+// Tensors are normally in NCHW form, but they are flat
+// (NC form) after fully-connected layers.
+type onnxValue struct {
+	Name string
+	Flat bool
+}
+
+// input declares the input of the graph.
+func (o *onnxWriter) input(d Dims) onnxValue {
+	o.graph.Inputs = append(o.graph.Inputs, &onnxValueInfo{
+		Name: "input",
+		Dims: []int64{-1, int64(d.Depth), int64(d.Height), int64(d.Width)},
+	})
+	return onnxValue{Name: "input"}
+}
+
+// node adds a node to the graph, returning its output.
+func (o *onnxWriter) node(op string, inputs []string, attrs ...*onnxAttr) onnxValue {
+	name := fmt.Sprintf("%s_%d", op, len(o.graph.Nodes))
+	o.graph.Nodes = append(o.graph.Nodes, &onnxNode{
+		Name:    name,
+		OpType:  op,
+		Inputs:  inputs,
+		Outputs: []string{name},
+		Attrs:   attrs,
+	})
+	return onnxValue{Name: name}
+}
+
+// nextName returns the name of the next node with the
+// given operator, for naming its initializers.
+func (o *onnxWriter) nextName(op string) string {
+	return fmt.Sprintf("%s_%d", op, len(o.graph.Nodes))
+}
+
+// floats adds a float initializer, returning its name.
+func (o *onnxWriter) floats(name string, dims []int64, vals []float32) string {
+	o.graph.Initializers = append(o.graph.Initializers, &onnxTensor{
+		Name:     name,
+		Dims:     dims,
+		DataType: onnxDataFloat,
+		Floats:   vals,
+	})
+	return name
+}
+
+// int64s adds a 1D integer initializer, returning its
+// name.
+func (o *onnxWriter) int64s(name string, vals []int64) string {
+	o.graph.Initializers = append(o.graph.Initializers, &onnxTensor{
+		Name:     name,
+		Dims:     []int64{int64(len(vals))},
+		DataType: onnxDataInt64,
+		Int64s:   vals,
+	})
+	return name
+}
+
+// params adds initializers for every parameter of a block,
+// returning their names.
+func (o *onnxWriter) params(b Block, in Dims, nodeName string) ([]string, error) {
+	values, err := loadParams(o.weights, b, in)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for i, p := range Params(b, in) {
+		vals := values[i]
+		if _, ok := b.(*FC); ok && p.Name == "weights" {
+			vals = chwWeights(vals, in)
+		}
+		var dims []int64
+		for _, x := range p.Shape {
+			dims = append(dims, int64(x))
+		}
+		res = append(res, o.floats(nodeName+"_"+p.Name, dims, vals))
+	}
+	return res, nil
+}
+
+// chwWeights reorders the columns of a weight matrix from
+// the HWC order used by Tensor to CHW order.
+func chwWeights(weights []float32, in Dims) []float32 {
+	res := make([]float32, len(weights))
+	size := in.Volume()
+	for row := 0; row < len(weights)/size; row++ {
+		for y := 0; y < in.Height; y++ {
+			for x := 0; x < in.Width; x++ {
+				for z := 0; z < in.Depth; z++ {
+					res[row*size+(z*in.Height+y)*in.Width+x] =
+						weights[row*size+(y*in.Width+x)*in.Depth+z]
+				}
+			}
+		}
+	}
+	return res
+}
+
+// image reshapes a flat value back into NCHW form.
+func (o *onnxWriter) image(x onnxValue, d Dims) onnxValue {
+	if !x.Flat {
+		return x
+	}
+	shape := o.int64s(o.nextName("Reshape")+"_shape",
+		[]int64{0, int64(d.Depth), int64(d.Height), int64(d.Width)})
+	return o.node("Reshape", []string{x.Name, shape})
+}
+
+func (o *onnxWriter) writeSeq(blocks []Block, in Dims, x onnxValue) (onnxValue, error) {
+	for _, b := range blocks {
+		var err error
+		x, err = o.write(b, in, x)
+		if err != nil {
+			return x, err
+		}
+		in = b.OutDims()
+	}
+	return x, nil
+}
+
+func (o *onnxWriter) write(b Block, in Dims, x onnxValue) (onnxValue, error) {
+	switch b := b.(type) {
+	case *Root:
+		return o.writeSeq(b.Children, in, x)
+	case *Projection:
+		return o.writeSeq(b.Children, in, x)
+	case *Input:
+		if x.Name != "" {
+			return x, fmt.Errorf("ONNX export: unexpected Input block")
+		}
+		return o.input(b.Out), nil
+	case *Assert, *Debug:
+		return x, nil
+	}
+	if x.Name == "" {
+		return x, fmt.Errorf("ONNX export: missing Input block before %s", b.Type())
+	}
+
+	switch b := b.(type) {
+	case *Repeat:
+		for i := 0; i < b.N; i++ {
+			var err error
+			x, err = o.writeSeq(b.Children, in, x)
+			if err != nil {
+				return x, err
+			}
+		}
+		return x, nil
+	case *Residual:
+		skip := x
+		if b.Projection != nil {
+			var err error
+			skip, err = o.writeSeq(b.Projection, in, x)
+			if err != nil {
+				return x, err
+			}
+		}
+		body, err := o.writeSeq(b.Residual, in, x)
+		if err != nil {
+			return x, err
+		}
+		if skip.Flat != body.Flat {
+			skip, body = o.image(skip, b.OutDims()), o.image(body, b.OutDims())
+		}
+		res := o.node("Add", []string{skip.Name, body.Name})
+		res.Flat = skip.Flat
+		return res, nil
+	case *Conv:
+		x = o.image(x, in)
+		params, err := o.params(b, in, o.nextName("Conv"))
+		if err != nil {
+			return x, err
+		}
+		return o.node("Conv", append([]string{x.Name}, params...),
+			onnxInts("kernel_shape", b.FilterHeight, b.FilterWidth),
+			onnxInts("strides", b.StrideY, b.StrideX),
+			onnxInts("pads", b.PadTop, b.PadLeft, b.PadBottom, b.PadRight),
+			onnxInts("dilations", b.DilationY, b.DilationX),
+			onnxInt("group", b.Groups)), nil
+	case *Concat:
+		return o.concat(b, in, x)
+	case *Deconv:
+		x = o.image(x, in)
+		params, err := o.params(b, in, o.nextName("ConvTranspose"))
+		if err != nil {
+			return x, err
+		}
+		return o.node("ConvTranspose", append([]string{x.Name}, params...),
+			onnxInts("kernel_shape", b.FilterHeight, b.FilterWidth),
+			onnxInts("strides", b.StrideY, b.StrideX),
+			onnxInts("output_padding", b.OutPadY, b.OutPadX)), nil
+	case *Resize:
+		return o.resize(b, in, x), nil
+	case *Pool:
+		op := "MaxPool"
+		if b.Name == "MeanPool" {
+			op = "AveragePool"
+		} else if b.Name != "MaxPool" {
+			break
+		}
+		x = o.image(x, in)
+		return o.node(op, []string{x.Name},
+			onnxInts("kernel_shape", b.Height, b.Width),
+			onnxInts("strides", b.StrideY, b.StrideX)), nil
+	case *Padding:
+		x = o.image(x, in)
+		pads := o.int64s(o.nextName("Pad")+"_pads", []int64{0, 0, int64(b.Top),
+			int64(b.Left), 0, 0, int64(b.Bottom), int64(b.Right)})
+		return o.node("Pad", []string{x.Name, pads}), nil
+	case *FC:
+		if !x.Flat {
+			x = o.node("Flatten", []string{x.Name}, onnxInt("axis", 1))
+		}
+		params, err := o.params(b, in, o.nextName("Gemm"))
+		if err != nil {
+			return x, err
+		}
+		res := o.node("Gemm", append([]string{x.Name}, params...),
+			onnxInt("transB", 1))
+		res.Flat = true
+		return res, nil
+	case *Linear:
+		if b.Scale != 1 {
+			scale := o.floats(o.nextName("Mul")+"_scale", nil,
+				[]float32{float32(b.Scale)})
+			x = onnxFlat(o.node("Mul", []string{x.Name, scale}), x.Flat)
+		}
+		if b.Bias != 0 {
+			bias := o.floats(o.nextName("Add")+"_bias", nil,
+				[]float32{float32(b.Bias)})
+			x = onnxFlat(o.node("Add", []string{x.Name, bias}), x.Flat)
+		}
+		return x, nil
+	case *Dropout:
+		ratio := o.floats(o.nextName("Dropout")+"_ratio", nil, []float32{float32(b.Prob)})
+		return onnxFlat(o.node("Dropout", []string{x.Name, ratio}), x.Flat), nil
+	case *Activation:
+		if b.Name == "BatchNorm" {
+			params, err := o.params(b, in, o.nextName("BatchNormalization"))
+			if err != nil {
+				return x, err
+			}
+			res := o.node("BatchNormalization", append([]string{x.Name}, params...),
+				&onnxAttr{Name: "epsilon", Type: onnxTypeFloat, Float: batchNormEpsilon})
+			return onnxFlat(res, x.Flat), nil
+		} else if op, ok := onnxActivations[b.Name]; ok {
+			var attrs []*onnxAttr
+			if op == "Softmax" {
+				attrs = append(attrs, onnxInt("axis", 1))
+			}
+			return onnxFlat(o.node(op, []string{x.Name}, attrs...), x.Flat), nil
+		}
+	}
+	return x, fmt.Errorf("ONNX export: unsupported block: %s", b.Type())
+}
+
+// concat writes the branches of a Concat and joins their
+// outputs along the channel axis.
+func (o *onnxWriter) concat(b *Concat, in Dims, x onnxValue) (onnxValue, error) {
+	var outs []onnxValue
+	var flat int
+	for _, branch := range b.Branches {
+		out, err := o.writeSeq(branch, in, x)
+		if err != nil {
+			return x, err
+		}
+		outs = append(outs, out)
+		if out.Flat {
+			flat++
+		}
+	}
+	var names []string
+	for i, out := range outs {
+		if flat != len(outs) {
+			branch := &Branch{Children: b.Branches[i], In: in}
+			out = o.image(out, branch.BranchOut())
+		}
+		names = append(names, out.Name)
+	}
+	res := o.node("Concat", names, onnxInt("axis", 1))
+	res.Flat = flat == len(outs)
+	return res, nil
+}
+
+// resize writes a Resize node.
+// Since the batch size is not known, the output shape is
+// computed from the shape of the input.
+func (o *onnxWriter) resize(b *Resize, in Dims, x onnxValue) onnxValue {
+	x = o.image(x, in)
+	shape := o.node("Shape", []string{x.Name})
+	starts := o.int64s(o.nextName("Slice")+"_starts", []int64{0})
+	ends := o.int64s(o.nextName("Slice")+"_ends", []int64{2})
+	batch := o.node("Slice", []string{shape.Name, starts, ends})
+	size := o.int64s(o.nextName("Concat")+"_size",
+		[]int64{int64(b.Out.Height), int64(b.Out.Width)})
+	sizes := o.node("Concat", []string{batch.Name, size}, onnxInt("axis", 0))
+	return o.node("Resize", []string{x.Name, "", "", sizes.Name},
+		onnxString("mode", "linear"),
+		onnxString("coordinate_transformation_mode", "half_pixel"))
+}
+
+func onnxFlat(x onnxValue, flat bool) onnxValue {
+	x.Flat = flat
+	return x
+}
+
+func onnxInt(name string, val int) *onnxAttr {
+	return &onnxAttr{Name: name, Type: onnxTypeInt, Int: int64(val)}
+}
+
+func onnxString(name, val string) *onnxAttr {
+	return &onnxAttr{Name: name, Type: onnxTypeString, String: val}
+}
+
+func onnxInts(name string, vals ...int) *onnxAttr {
+	res := &onnxAttr{Name: name, Type: onnxTypeInts}
+	for _, x := range vals {
+		res.Ints = append(res.Ints, int64(x))
+	}
+	return res
+}
+
+// decodeONNXModel decodes the graph of an ONNX model.
+//
+// Only the parts of the format used by WriteONNX are
+// decoded.
+func decodeONNXModel(data []byte) (*onnxGraph, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	var res *onnxGraph
+	opset := 1
+	for _, f := range fields {
+		if f.Wire != wireBytes {
+			continue
+		}
+		switch f.Num {
+		case onnxModelGraph:
+			res, err = decodeONNXGraph(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+		case onnxModelOpsetImport:
+			opsetFields, err := decodeProto(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			var domain string
+			var version int
+			for _, f := range opsetFields {
+				if f.Num == onnxOpsetDomain {
+					domain = string(f.Bytes)
+				} else if f.Num == onnxOpsetVersion {
+					version = int(f.Int)
+				}
+			}
+			if domain == "" || domain == "ai.onnx" {
+				opset = version
+			}
+		}
+	}
+	if res == nil {
+		return nil, fmt.Errorf("ONNX model has no graph")
+	}
+	res.Opset = opset
+	return res, nil
+}
+
+func decodeONNXGraph(data []byte) (*onnxGraph, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	res := &onnxGraph{}
+	for _, f := range fields {
+		if f.Wire != wireBytes {
+			continue
+		}
+		switch f.Num {
+		case onnxGraphNode:
+			node, err := decodeONNXNode(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			res.Nodes = append(res.Nodes, node)
+		case onnxGraphInitializer:
+			t, err := decodeONNXTensor(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			res.Initializers = append(res.Initializers, t)
+		case onnxGraphInput, onnxGraphOutput:
+			v, err := decodeONNXValueInfo(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if f.Num == onnxGraphInput {
+				res.Inputs = append(res.Inputs, v)
+			} else {
+				res.Outputs = append(res.Outputs, v)
+			}
+		}
+	}
+	return res, nil
+}
+
+func decodeONNXNode(data []byte) (*onnxNode, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	res := &onnxNode{}
+	for _, f := range fields {
+		switch f.Num {
+		case onnxNodeInput:
+			res.Inputs = append(res.Inputs, string(f.Bytes))
+		case onnxNodeOutput:
+			res.Outputs = append(res.Outputs, string(f.Bytes))
+		case onnxNodeName:
+			res.Name = string(f.Bytes)
+		case onnxNodeOpType:
+			res.OpType = string(f.Bytes)
+		case onnxNodeAttribute:
+			attr, err := decodeONNXAttr(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			res.Attrs = append(res.Attrs, attr)
+		}
+	}
+	return res, nil
+}
+
+func decodeONNXAttr(data []byte) (*onnxAttr, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	res := &onnxAttr{}
+	for _, f := range fields {
+		switch f.Num {
+		case onnxAttrName:
+			res.Name = string(f.Bytes)
+		case onnxAttrType:
+			res.Type = int(f.Int)
+		case onnxAttrFloat:
+			res.Float = math.Float32frombits(uint32(f.Int))
+		case onnxAttrInt:
+			res.Int = int64(f.Int)
+		case onnxAttrString:
+			res.String = string(f.Bytes)
+		case onnxAttrInts:
+			ints, err := decodeInt64s(f)
+			if err != nil {
+				return nil, err
+			}
+			res.Ints = append(res.Ints, ints...)
+		}
+	}
+	return res, nil
+}
+
+func decodeONNXTensor(data []byte) (*onnxTensor, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	res := &onnxTensor{}
+	var raw []byte
+	for _, f := range fields {
+		switch f.Num {
+		case onnxTensorDims:
+			dims, err := decodeInt64s(f)
+			if err != nil {
+				return nil, err
+			}
+			res.Dims = append(res.Dims, dims...)
+		case onnxTensorDataType:
+			res.DataType = int(f.Int)
+		case onnxTensorName:
+			res.Name = string(f.Bytes)
+		case onnxTensorRawData:
+			raw = f.Bytes
+		case onnxTensorFloatData:
+			if f.Wire == wireFixed32 {
+				res.Floats = append(res.Floats, math.Float32frombits(uint32(f.Int)))
+			} else {
+				for i := 0; i+4 <= len(f.Bytes); i += 4 {
+					bits := binary.LittleEndian.Uint32(f.Bytes[i:])
+					res.Floats = append(res.Floats, math.Float32frombits(bits))
+				}
+			}
+		case onnxTensorInt64Data:
+			ints, err := decodeInt64s(f)
+			if err != nil {
+				return nil, err
+			}
+			res.Int64s = append(res.Int64s, ints...)
+		}
+	}
+	if raw != nil {
+		switch res.DataType {
+		case onnxDataFloat:
+			for i := 0; i+4 <= len(raw); i += 4 {
+				bits := binary.LittleEndian.Uint32(raw[i:])
+				res.Floats = append(res.Floats, math.Float32frombits(bits))
+			}
+		case onnxDataInt64:
+			for i := 0; i+8 <= len(raw); i += 8 {
+				res.Int64s = append(res.Int64s, int64(binary.LittleEndian.Uint64(raw[i:])))
+			}
+		default:
+			return nil, fmt.Errorf("unsupported ONNX data type: %d", res.DataType)
+		}
+	}
+	return res, nil
+}
+
+func decodeONNXValueInfo(data []byte) (*onnxValueInfo, error) {
+	res := &onnxValueInfo{}
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		switch f.Num {
+		case onnxValueName:
+			res.Name = string(f.Bytes)
+		case onnxValueType:
+			res.Dims, err = decodeONNXShape(f.Bytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// decodeONNXShape decodes the shape from a TypeProto.
+// Symbolic dimensions are decoded as -1.
+func decodeONNXShape(data []byte) ([]int64, error) {
+	var res []int64
+	err := forEachField(data, onnxTypeTensor, func(data []byte) error {
+		return forEachField(data, onnxTensorShape, func(data []byte) error {
+			return forEachField(data, onnxShapeDim, func(data []byte) error {
+				fields, err := decodeProto(data)
+				if err != nil {
+					return err
+				}
+				dim := int64(-1)
+				for _, f := range fields {
+					if f.Num == onnxDimValue && f.Wire == wireVarint {
+						dim = int64(f.Int)
+					}
+				}
+				res = append(res, dim)
+				return nil
+			})
+		})
+	})
+	return res, err
+}
+
+// forEachField calls f with the contents of every
+// length-delimited field with the given number.
+func forEachField(data []byte, num int, f func(data []byte) error) error {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if field.Num == num && field.Wire == wireBytes {
+			if err := f(field.Bytes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,720 @@
+package convmarkup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An UnsupportedONNXError lists the nodes of an ONNX graph
+// which cannot be converted into blocks.
+type UnsupportedONNXError struct {
+	Nodes []UnsupportedONNXNode
+}
+
+// An UnsupportedONNXNode describes an ONNX node which
+// cannot be converted into a block.
+type UnsupportedONNXNode struct {
+	// Name is the name of the node, or "#i" for the i-th
+	// node if it has no name.
+	Name string
+
+	OpType string
+
+	// Reason explains why the node is unsupported.
+	Reason string
+}
+
+// Error lists every unsupported node.
+func (u *UnsupportedONNXError) Error() string {
+	var parts []string
+	for _, n := range u.Nodes {
+		parts = append(parts, fmt.Sprintf("%s (node %s): %s", n.OpType, n.Name,
+			n.Reason))
+	}
+	return "unsupported ONNX nodes: " + strings.Join(parts, "; ")
+}
+
+// ReadONNX reads an ONNX model and converts it into a root
+// ASTNode of built-in blocks.
+//
+// The model must have a single NCHW input with a known
+// size, which becomes an Input block.
+// Branches which split from a tensor and are merged by an
+// Add become Residual blocks.
+// If neither branch is the identity, the shorter branch
+// becomes the Projection.
+//
+// The supported operators are those produced by
+// WriteONNX, except for Concat and Resize, along with
+// GlobalAveragePool, GlobalMaxPool, MatMul, Identity, and
+// element-wise arithmetic with scalar constants.
+// Parameter values are not imported.
+//
+// If the graph uses unsupported operators, an
+// *UnsupportedONNXError is returned which lists every
+// unsupported node.
+func ReadONNX(r io.Reader) (*ASTNode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	graph, err := decodeONNXModel(data)
+	if err != nil {
+		return nil, err
+	}
+	return newONNXImporter(graph).root()
+}
+
+// onnxImporter converts an ONNX graph into ASTNodes.
+type onnxImporter struct {
+	graph        *onnxGraph
+	initializers map[string]*onnxTensor
+	producers    map[string]*onnxNode
+	consumers    map[string][]*onnxNode
+	index        map[*onnxNode]int
+}
+
+func newONNXImporter(g *onnxGraph) *onnxImporter {
+	res := &onnxImporter{
+		graph:        g,
+		initializers: map[string]*onnxTensor{},
+		producers:    map[string]*onnxNode{},
+		consumers:    map[string][]*onnxNode{},
+		index:        map[*onnxNode]int{},
+	}
+	for _, t := range g.Initializers {
+		res.initializers[t.Name] = t
+	}
+	for i, node := range g.Nodes {
+		res.index[node] = i
+		for _, out := range node.Outputs {
+			if out != "" {
+				res.producers[out] = node
+			}
+		}
+		for _, in := range node.Inputs {
+			if in != "" && res.initializers[in] == nil {
+				res.consumers[in] = append(res.consumers[in], node)
+			}
+		}
+	}
+	return res
+}
+
+func (o *onnxImporter) root() (*ASTNode, error) {
+	var inputs []*onnxValueInfo
+	for _, in := range o.graph.Inputs {
+		// Older models list initializers as inputs.
+		if o.initializers[in.Name] == nil {
+			inputs = append(inputs, in)
+		}
+	}
+	if len(inputs) != 1 {
+		return nil, fmt.Errorf("ONNX import: expected 1 input but got %d", len(inputs))
+	}
+	if len(o.graph.Outputs) != 1 {
+		return nil, fmt.Errorf("ONNX import: expected 1 output but got %d",
+			len(o.graph.Outputs))
+	}
+	in := inputs[0]
+	if len(in.Dims) != 4 || in.Dims[1] <= 0 || in.Dims[2] <= 0 || in.Dims[3] <= 0 {
+		return nil, fmt.Errorf("ONNX import: input %s must have shape NxCxHxW",
+			in.Name)
+	}
+
+	if err := o.checkNodes(); err != nil {
+		return nil, err
+	}
+
+	input := newASTNode("Input", map[string]float64{
+		"w": float64(in.Dims[3]),
+		"h": float64(in.Dims[2]),
+		"d": float64(in.Dims[1]),
+	})
+	children, _, err := o.seq(in.Name, nil, o.graph.Outputs[0].Name, false)
+	if err != nil {
+		return nil, err
+	}
+	return &ASTNode{Children: append([]*ASTNode{input}, children...)}, nil
+}
+
+// checkNodes finds every node which cannot be converted.
+func (o *onnxImporter) checkNodes() error {
+	var unsupported []UnsupportedONNXNode
+	for _, node := range o.graph.Nodes {
+		if reason := o.unsupportedReason(node); reason != "" {
+			unsupported = append(unsupported, UnsupportedONNXNode{
+				Name:   o.name(node),
+				OpType: node.OpType,
+				Reason: reason,
+			})
+		}
+	}
+	if len(unsupported) > 0 {
+		return &UnsupportedONNXError{Nodes: unsupported}
+	}
+	return nil
+}
+
+// unsupportedReason explains why a node cannot be
+// converted, or returns "" if it can be.
+func (o *onnxImporter) unsupportedReason(node *onnxNode) string {
+	if len(node.Outputs) != 1 {
+		return fmt.Sprintf("expected 1 output but got %d", len(node.Outputs))
+	}
+	switch node.OpType {
+	case "Identity", "Flatten", "Relu", "Sigmoid", "Tanh", "Softmax",
+		"BatchNormalization", "Dropout", "GlobalAveragePool", "GlobalMaxPool":
+		return ""
+	case "Reshape":
+		if len(node.Inputs) < 2 || o.initializers[node.Inputs[1]] == nil {
+			return "shape must be an initializer"
+		}
+		return ""
+	case "Conv":
+		if reason := o.checkLengths(node, 2, "strides", "dilations"); reason != "" {
+			return reason
+		} else if reason := o.checkLengths(node, 4, "pads"); reason != "" {
+			return reason
+		} else if o.autoPad(node) == "SAME_LOWER" {
+			return "auto_pad SAME_LOWER is not supported"
+		}
+		return o.checkWeights(node, 4)
+	case "ConvTranspose":
+		if reason := o.checkLengths(node, 2, "strides", "output_padding"); reason != "" {
+			return reason
+		} else if o.intAttr(node, "group", 1) != 1 {
+			return "groups are not supported"
+		} else if !allEqual(o.intsAttr(node, "pads", 0, 0, 0, 0), 0) {
+			return "padding is not supported"
+		} else if !allEqual(o.intsAttr(node, "dilations", 1, 1), 1) {
+			return "dilation is not supported"
+		} else if o.autoPad(node) != "NOTSET" && o.autoPad(node) != "VALID" {
+			return "auto_pad is not supported"
+		} else if node.attr("output_shape") != nil {
+			return "output_shape is not supported"
+		}
+		return o.checkWeights(node, 4)
+	case "MaxPool", "AveragePool":
+		if reason := o.checkLengths(node, 2, "strides"); reason != "" {
+			return reason
+		} else if !allEqual(o.intsAttr(node, "pads", 0, 0, 0, 0), 0) {
+			return "padding is not supported"
+		} else if !allEqual(o.intsAttr(node, "dilations", 1, 1), 1) {
+			return "dilation is not supported"
+		} else if o.autoPad(node) != "NOTSET" && o.autoPad(node) != "VALID" {
+			return "auto_pad is not supported"
+		} else if o.intAttr(node, "ceil_mode", 0) != 0 {
+			return "ceil_mode is not supported"
+		} else if len(o.intsAttr(node, "kernel_shape")) != 2 {
+			return "kernel_shape must be 2D"
+		}
+		return ""
+	case "Pad":
+		if mode := node.attr("mode"); mode != nil && mode.String != "constant" {
+			return "mode " + mode.String + " is not supported"
+		}
+		if value := node.attr("value"); value != nil && value.Float != 0 {
+			return "only zero padding is supported"
+		} else if len(node.Inputs) > 2 && node.Inputs[2] != "" {
+			value := o.initializers[node.Inputs[2]]
+			if value == nil || !allFloatsEqual(value.Floats, 0) {
+				return "only zero padding is supported"
+			}
+		}
+		if _, err := o.pads(node); err != nil {
+			return err.Error()
+		}
+		return ""
+	case "Gemm":
+		if node.attr("alpha") != nil && node.attr("alpha").Float != 1 {
+			return "alpha is not supported"
+		} else if node.attr("beta") != nil && node.attr("beta").Float != 1 {
+			return "beta is not supported"
+		} else if o.intAttr(node, "transA", 0) != 0 {
+			return "transA is not supported"
+		}
+		return o.checkWeights(node, 2)
+	case "MatMul":
+		return o.checkWeights(node, 2)
+	case "Add", "Sub", "Mul", "Div":
+		if len(node.Inputs) != 2 {
+			return "expected 2 inputs"
+		}
+		constant, _ := o.constantInput(node)
+		if constant == nil {
+			if node.OpType == "Add" {
+				return ""
+			}
+			return "both inputs are variable"
+		} else if len(constant.Floats) == 0 {
+			return "constant must be a float tensor"
+		} else if len(constant.Floats) != 1 && node.OpType != "Add" {
+			return "constant must be a scalar"
+		} else if node.OpType == "Div" && node.Inputs[0] == constant.Name {
+			return "division by a variable is not supported"
+		}
+		return ""
+	}
+	return "unsupported operator"
+}
+
+// checkLengths ensures that each of the given list
+// attributes has n values, if it is present.
+func (o *onnxImporter) checkLengths(node *onnxNode, n int, names ...string) string {
+	for _, name := range names {
+		if attr := node.attr(name); attr != nil && len(attr.Ints) != n {
+			return fmt.Sprintf("%s must have %d values", name, n)
+		}
+	}
+	return ""
+}
+
+// checkWeights ensures that a node's second input is an
+// initializer with the given number of dimensions.
+func (o *onnxImporter) checkWeights(node *onnxNode, dims int) string {
+	if len(node.Inputs) < 2 || o.initializers[node.Inputs[1]] == nil {
+		return "weights must be an initializer"
+	} else if len(o.initializers[node.Inputs[1]].Dims) != dims {
+		return fmt.Sprintf("weights must be %dD", dims)
+	}
+	return ""
+}
+
+// seq converts the chain of nodes which lead from the
+// value start to the value end.
+//
+// If first is non-nil, it is the first node in the chain.
+// Otherwise, start must be used by exactly one node, or by
+// exactly two nodes which merge at an Add.
+//
+// The flat argument indicates if start has been flattened.
+// The returned flag indicates if end is flat.
+func (o *onnxImporter) seq(start string, first *onnxNode, end string,
+	flat bool) ([]*ASTNode, bool, error) {
+	var res []*ASTNode
+	for v := start; v != end; {
+		node := first
+		first = nil
+		if node == nil {
+			consumers := o.consumers[v]
+			switch len(consumers) {
+			case 0:
+				return nil, false, fmt.Errorf("ONNX import: value %s does not reach %s",
+					v, end)
+			case 1:
+				node = consumers[0]
+			case 2:
+				residual, out, outFlat, err := o.residual(v, consumers, flat)
+				if err != nil {
+					return nil, false, err
+				}
+				res = append(res, residual)
+				v, flat = out, outFlat
+				continue
+			default:
+				return nil, false, fmt.Errorf("ONNX import: value %s is used by %d nodes",
+					v, len(consumers))
+			}
+		}
+		if o.isMerge(node) {
+			return nil, false, fmt.Errorf("ONNX import: node %s: unexpected merge",
+				o.name(node))
+		}
+		var err error
+		res, flat, err = o.convert(node, res, flat)
+		if err != nil {
+			return nil, false, fmt.Errorf("ONNX import: node %s: %s", o.name(node), err)
+		}
+		v = node.Outputs[0]
+	}
+	return res, flat, nil
+}
+
+// residual converts two branches which split from the
+// value v and merge at an Add.
+// It returns the Residual node, the output of the Add, and
+// whether or not the output is flat.
+func (o *onnxImporter) residual(v string, consumers []*onnxNode,
+	flat bool) (*ASTNode, string, bool, error) {
+	merge := o.mergeNode(consumers[0], consumers[1])
+	if merge == nil || !o.isMerge(merge) {
+		return nil, "", false, fmt.Errorf("ONNX import: value %s splits into branches "+
+			"which are not merged by an Add", v)
+	}
+
+	var branches [2][]*ASTNode
+	var identity [2]bool
+	bodyFlat := flat
+	usedFirst := map[*onnxNode]bool{}
+	for i, input := range merge.Inputs {
+		if input == v {
+			identity[i] = true
+			continue
+		}
+		var first *onnxNode
+		for _, c := range consumers {
+			if c != merge && o.reachable(c)[o.producers[input]] {
+				if first != nil {
+					return nil, "", false, fmt.Errorf("ONNX import: node %s: both inputs "+
+						"depend on the same branch", o.name(merge))
+				}
+				first = c
+			}
+		}
+		if first == nil || usedFirst[first] {
+			return nil, "", false, fmt.Errorf("ONNX import: node %s: inputs do not come "+
+				"from separate branches", o.name(merge))
+		}
+		usedFirst[first] = true
+		var err error
+		branches[i], bodyFlat, err = o.seq(v, first, input, flat)
+		if err != nil {
+			return nil, "", false, err
+		}
+	}
+
+	var projection, body []*ASTNode
+	switch {
+	case identity[0] && identity[1]:
+		return nil, "", false, fmt.Errorf("ONNX import: node %s: adding a value to "+
+			"itself is not supported", o.name(merge))
+	case identity[0]:
+		body = branches[1]
+	case identity[1]:
+		body = branches[0]
+	case len(branches[1]) < len(branches[0]):
+		projection, body = branches[1], branches[0]
+	default:
+		projection, body = branches[0], branches[1]
+	}
+
+	res := newASTNode("Residual", nil)
+	if projection != nil {
+		proj := newASTNode("Projection", nil)
+		proj.Children = projection
+		res.Children = append(res.Children, proj)
+	}
+	res.Children = append(res.Children, body...)
+	return res, merge.Outputs[0], bodyFlat, nil
+}
+
+// isMerge checks if a node adds two variable inputs.
+func (o *onnxImporter) isMerge(node *onnxNode) bool {
+	if node.OpType != "Add" || len(node.Inputs) != 2 {
+		return false
+	}
+	constant, _ := o.constantInput(node)
+	return constant == nil
+}
+
+// reachable finds every node which depends on a node,
+// including the node itself.
+func (o *onnxImporter) reachable(node *onnxNode) map[*onnxNode]bool {
+	res := map[*onnxNode]bool{}
+	queue := []*onnxNode{node}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if res[n] {
+			continue
+		}
+		res[n] = true
+		for _, out := range n.Outputs {
+			queue = append(queue, o.consumers[out]...)
+		}
+	}
+	return res
+}
+
+// mergeNode finds the first node which depends on both of
+// the given nodes.
+func (o *onnxImporter) mergeNode(n1, n2 *onnxNode) *onnxNode {
+	reach1 := o.reachable(n1)
+	var res *onnxNode
+	for n := range o.reachable(n2) {
+		if reach1[n] && (res == nil || o.index[n] < o.index[res]) {
+			res = n
+		}
+	}
+	return res
+}
+
+// convert converts a node in a chain, appending any new
+// ASTNodes to res.
+func (o *onnxImporter) convert(node *onnxNode, res []*ASTNode,
+	flat bool) ([]*ASTNode, bool, error) {
+	switch node.OpType {
+	case "Identity":
+		return res, flat, nil
+	case "Flatten":
+		return res, true, nil
+	case "Reshape":
+		shape := o.initializers[node.Inputs[1]]
+		if len(shape.Int64s) == 2 {
+			return res, true, nil
+		} else if flat && len(shape.Int64s) == 4 && shape.Int64s[2] == 1 &&
+			shape.Int64s[3] == 1 {
+			return res, false, nil
+		}
+		return nil, false, fmt.Errorf("unsupported shape: %v", shape.Int64s)
+	case "Conv":
+		return o.convertConv(node, res), flat, nil
+	case "ConvTranspose":
+		weights := o.initializers[node.Inputs[1]]
+		attrs := map[string]float64{
+			"w": float64(weights.Dims[3]),
+			"h": float64(weights.Dims[2]),
+			"n": float64(weights.Dims[1]),
+		}
+		strides := o.intsAttr(node, "strides", 1, 1)
+		outPad := o.intsAttr(node, "output_padding", 0, 0)
+		setIfNot(attrs, "sy", strides[0], 1)
+		setIfNot(attrs, "sx", strides[1], 1)
+		setIfNot(attrs, "oy", outPad[0], 0)
+		setIfNot(attrs, "ox", outPad[1], 0)
+		return append(res, newASTNode("Deconv", attrs)), flat, nil
+	case "MaxPool", "AveragePool":
+		kernel := o.intsAttr(node, "kernel_shape")
+		strides := o.intsAttr(node, "strides", 1, 1)
+		attrs := map[string]float64{
+			"w": float64(kernel[1]),
+			"h": float64(kernel[0]),
+		}
+		setIfNot(attrs, "sy", strides[0], kernel[0])
+		setIfNot(attrs, "sx", strides[1], kernel[1])
+		name := "MaxPool"
+		if node.OpType == "AveragePool" {
+			name = "MeanPool"
+		}
+		return append(res, newASTNode(name, attrs)), flat, nil
+	case "GlobalAveragePool":
+		return append(res, newASTNode("MeanPool", nil)), flat, nil
+	case "GlobalMaxPool":
+		return append(res, newASTNode("MaxPool", nil)), flat, nil
+	case "Pad":
+		pads, _ := o.pads(node)
+		return append(res, newASTNode("Padding", map[string]float64{
+			"t": float64(pads[2]),
+			"r": float64(pads[7]),
+			"b": float64(pads[6]),
+			"l": float64(pads[3]),
+		})), flat, nil
+	case "Gemm", "MatMul":
+		weights := o.initializers[node.Inputs[1]]
+		out := weights.Dims[1]
+		if node.OpType == "Gemm" && o.intAttr(node, "transB", 0) != 0 {
+			out = weights.Dims[0]
+		}
+		attrs := map[string]float64{"out": float64(out)}
+		return append(res, newASTNode("FC", attrs)), true, nil
+	case "Add", "Sub", "Mul", "Div":
+		res, err := o.convertArithmetic(node, res)
+		return res, flat, err
+	case "BatchNormalization":
+		return append(res, newASTNode("BatchNorm", nil)), flat, nil
+	case "Relu":
+		return append(res, newASTNode("ReLU", nil)), flat, nil
+	case "Sigmoid", "Tanh":
+		return append(res, newASTNode(node.OpType, nil)), flat, nil
+	case "Softmax":
+		axis := 1
+		if o.graph.Opset >= 13 {
+			axis = -1
+		}
+		axis = o.intAttr(node, "axis", axis)
+		if axis == 1 && !flat && o.graph.Opset < 13 {
+			// Older opsets normalize over C, H, and W at once.
+			return nil, false, errors.New("softmax over spatial dimensions is not supported")
+		} else if axis != 1 && !(flat && axis == -1) {
+			return nil, false, fmt.Errorf("softmax along axis %d is not supported", axis)
+		}
+		return append(res, newASTNode("Softmax", nil)), flat, nil
+	case "Dropout":
+		prob := 0.5
+		if ratio := node.attr("ratio"); ratio != nil {
+			prob = float64(ratio.Float)
+		} else if len(node.Inputs) > 1 && node.Inputs[1] != "" {
+			t := o.initializers[node.Inputs[1]]
+			if t == nil || len(t.Floats) != 1 {
+				return nil, false, errors.New("ratio must be a scalar initializer")
+			}
+			prob = float64(t.Floats[0])
+		}
+		attrs := map[string]float64{"prob": prob}
+		return append(res, newASTNode("Dropout", attrs)), flat, nil
+	}
+	return nil, false, errors.New("unsupported operator")
+}
+
+func (o *onnxImporter) convertConv(node *onnxNode, res []*ASTNode) []*ASTNode {
+	weights := o.initializers[node.Inputs[1]]
+	attrs := map[string]float64{
+		"w": float64(weights.Dims[3]),
+		"h": float64(weights.Dims[2]),
+		"n": float64(weights.Dims[0]),
+	}
+	strides := o.intsAttr(node, "strides", 1, 1)
+	dilations := o.intsAttr(node, "dilations", 1, 1)
+	setIfNot(attrs, "sy", strides[0], 1)
+	setIfNot(attrs, "sx", strides[1], 1)
+	setIfNot(attrs, "dy", dilations[0], 1)
+	setIfNot(attrs, "dx", dilations[1], 1)
+	setIfNot(attrs, "groups", o.intAttr(node, "group", 1), 1)
+
+	pads := o.intsAttr(node, "pads", 0, 0, 0, 0)
+	if o.autoPad(node) == "SAME_UPPER" {
+		attrs["same"] = 1
+	} else if allEqual(pads, pads[0]) {
+		setIfNot(attrs, "p", pads[0], 0)
+	} else {
+		res = append(res, newASTNode("Padding", map[string]float64{
+			"t": float64(pads[0]),
+			"r": float64(pads[3]),
+			"b": float64(pads[2]),
+			"l": float64(pads[1]),
+		}))
+	}
+	return append(res, newASTNode("Conv", attrs))
+}
+
+// convertArithmetic converts an element-wise operation
+// with a constant into a Linear block, combining it with
+// a preceding Linear block if possible.
+//
+// An Add with a constant vector is treated as the bias of
+// a preceding FC block.
+func (o *onnxImporter) convertArithmetic(node *onnxNode,
+	res []*ASTNode) ([]*ASTNode, error) {
+	constant, index := o.constantInput(node)
+	if len(constant.Floats) != 1 {
+		if len(res) > 0 && res[len(res)-1].BlockName == "FC" &&
+			float64(len(constant.Floats)) == res[len(res)-1].Attrs["out"] {
+			return res, nil
+		}
+		return nil, errors.New("constant must be a scalar")
+	}
+	x := float64(constant.Floats[0])
+	var scale, bias float64
+	switch node.OpType {
+	case "Add":
+		scale, bias = 1, x
+	case "Sub":
+		if index == 0 {
+			scale, bias = -1, x
+		} else {
+			scale, bias = 1, -x
+		}
+	case "Mul":
+		scale = x
+	case "Div":
+		scale = 1 / x
+	}
+
+	if len(res) > 0 && res[len(res)-1].BlockName == "Linear" {
+		// Compose the two linear functions.
+		last := res[len(res)-1]
+		oldScale, oldBias := 1.0, last.Attrs["bias"]
+		if s, ok := last.Attrs["scale"]; ok {
+			oldScale = s
+		}
+		scale, bias = oldScale*scale, oldBias*scale+bias
+		res = res[:len(res)-1]
+	}
+	attrs := map[string]float64{}
+	setIfNot(attrs, "scale", scale, 1)
+	setIfNot(attrs, "bias", bias, 0)
+	return append(res, newASTNode("Linear", attrs)), nil
+}
+
+// constantInput finds the input of a node which is an
+// initializer, along with its index.
+func (o *onnxImporter) constantInput(node *onnxNode) (*onnxTensor, int) {
+	for i, in := range node.Inputs {
+		if t := o.initializers[in]; t != nil {
+			return t, i
+		}
+	}
+	return nil, -1
+}
+
+// pads finds the padding of a Pad node, in ONNX order.
+func (o *onnxImporter) pads(node *onnxNode) ([]int64, error) {
+	var pads []int64
+	if attr := node.attr("pads"); attr != nil {
+		pads = attr.Ints
+	} else if len(node.Inputs) > 1 && o.initializers[node.Inputs[1]] != nil {
+		pads = o.initializers[node.Inputs[1]].Int64s
+	} else {
+		return nil, errors.New("pads must be an initializer")
+	}
+	if len(pads) != 8 {
+		return nil, errors.New("only 4D padding is supported")
+	} else if pads[0] != 0 || pads[1] != 0 || pads[4] != 0 || pads[5] != 0 {
+		return nil, errors.New("only spatial padding is supported")
+	}
+	return pads, nil
+}
+
+// name returns a name for a node in error messages.
+func (o *onnxImporter) name(node *onnxNode) string {
+	if node.Name == "" {
+		return fmt.Sprintf("#%d", o.index[node])
+	}
+	return fmt.Sprintf("%q", node.Name)
+}
+
+func (o *onnxImporter) autoPad(node *onnxNode) string {
+	if attr := node.attr("auto_pad"); attr != nil && attr.String != "" {
+		return attr.String
+	}
+	return "NOTSET"
+}
+
+func (o *onnxImporter) intAttr(node *onnxNode, name string, def int) int {
+	if attr := node.attr(name); attr != nil {
+		return int(attr.Int)
+	}
+	return def
+}
+
+func (o *onnxImporter) intsAttr(node *onnxNode, name string, def ...int) []int {
+	attr := node.attr(name)
+	if attr == nil {
+		return def
+	}
+	res := make([]int, len(attr.Ints))
+	for i, x := range attr.Ints {
+		res[i] = int(x)
+	}
+	return res
+}
+
+// setIfNot sets an attribute unless it has the default
+// value.
+func setIfNot[T int | int64 | float64](attrs map[string]float64, name string, val,
+	def T) {
+	if val != def {
+		attrs[name] = float64(val)
+	}
+}
+
+func allEqual[T int | int64](vals []T, x T) bool {
+	for _, v := range vals {
+		if v != x {
+			return false
+		}
+	}
+	return true
+}
+
+func allFloatsEqual(vals []float32, x float32) bool {
+	for _, v := range vals {
+		if v != x {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,218 @@
+package convmarkup
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReadONNX(t *testing.T) {
+	markup := `Input(w=8, h=7, d=4)
+Padding(t=1, r=0, b=0, l=2)
+Conv(w=3, h=3, n=4, sx=2, dx=2, p=1, groups=2)
+Residual {
+    Projection {
+        Conv(w=1, h=1, n=4)
+    }
+    Conv(w=3, h=3, n=4, p=1)
+    BatchNorm
+    ReLU
+}
+Residual {
+    MaxPool(w=3, h=3, sx=1, sy=1)
+    Padding(t=1, r=1, b=1, l=1)
+    Sigmoid
+}
+Linear(scale=2, bias=1)
+MeanPool(w=2, h=3)
+Dropout(prob=0.25)
+FC(out=5)
+Tanh
+FC(out=2)
+Softmax
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteONNX(&buf, block, Dims{}, &RandomWeights{}); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := ReadONNX(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(imported); actual != markup {
+		t.Errorf("expected:\n%s\ngot:\n%s", markup, actual)
+	}
+	if _, err := imported.Block(Dims{}, DefaultCreators()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadONNXUnsupported(t *testing.T) {
+	graph := &onnxGraph{
+		Nodes: []*onnxNode{
+			{Name: "lstm", OpType: "LSTM", Inputs: []string{"input"},
+				Outputs: []string{"a"}},
+			{OpType: "Mul", Inputs: []string{"a", "a"}, Outputs: []string{"b"}},
+			{Name: "gemm", OpType: "Gemm", Inputs: []string{"b", "w"},
+				Outputs: []string{"output"},
+				Attrs:   []*onnxAttr{{Name: "transA", Type: onnxTypeInt, Int: 1}}},
+		},
+		Initializers: []*onnxTensor{
+			{Name: "w", Dims: []int64{2, 2}, DataType: onnxDataFloat,
+				Floats: make([]float32, 4)},
+		},
+		Inputs:  []*onnxValueInfo{{Name: "input", Dims: []int64{-1, 1, 1, 2}}},
+		Outputs: []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 2}}},
+	}
+	_, err := ReadONNX(bytes.NewReader(graph.encodeModel()))
+	var unsupported *UnsupportedONNXError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []UnsupportedONNXNode{
+		{Name: `"lstm"`, OpType: "LSTM", Reason: "unsupported operator"},
+		{Name: "#1", OpType: "Mul", Reason: "both inputs are variable"},
+		{Name: `"gemm"`, OpType: "Gemm", Reason: "transA is not supported"},
+	}
+	if !reflect.DeepEqual(unsupported.Nodes, expected) {
+		t.Errorf("expected %v but got %v", expected, unsupported.Nodes)
+	}
+	expectedMsg := `unsupported ONNX nodes: LSTM (node "lstm"): unsupported operator; ` +
+		`Mul (node #1): both inputs are variable; Gemm (node "gemm"): ` +
+		`transA is not supported`
+	if err.Error() != expectedMsg {
+		t.Errorf("expected error %q but got %q", expectedMsg, err.Error())
+	}
+}
+
+func TestReadONNXInvalidNodes(t *testing.T) {
+	weights := &onnxTensor{Name: "w", Dims: []int64{1, 1, 1, 1}, DataType: onnxDataFloat,
+		Floats: []float32{1}}
+	pads := &onnxAttr{Name: "pads", Type: onnxTypeInts, Ints: []int64{0, 0, 1, 1, 0, 0, 1, 1}}
+	tests := []struct {
+		node   *onnxNode
+		opset  int
+		reason string
+	}{
+		{
+			&onnxNode{OpType: "Reshape", Inputs: []string{"input"},
+				Attrs: []*onnxAttr{{Name: "shape", Type: onnxTypeInts,
+					Ints: []int64{-1, 4}}}},
+			0,
+			"shape must be an initializer",
+		},
+		{
+			&onnxNode{OpType: "Conv", Inputs: []string{"input", "w"},
+				Attrs: []*onnxAttr{{Name: "pads", Type: onnxTypeInts}}},
+			0,
+			"pads must have 4 values",
+		},
+		{
+			&onnxNode{OpType: "Conv", Inputs: []string{"input", "w"},
+				Attrs: []*onnxAttr{{Name: "strides", Type: onnxTypeInts}}},
+			0,
+			"strides must have 2 values",
+		},
+		{
+			&onnxNode{OpType: "Conv", Inputs: []string{"input", "w"},
+				Attrs: []*onnxAttr{{Name: "dilations", Type: onnxTypeInts,
+					Ints: []int64{1}}}},
+			0,
+			"dilations must have 2 values",
+		},
+		{
+			&onnxNode{OpType: "MaxPool", Inputs: []string{"input"},
+				Attrs: []*onnxAttr{
+					{Name: "kernel_shape", Type: onnxTypeInts, Ints: []int64{2, 2}},
+					{Name: "strides", Type: onnxTypeInts},
+				}},
+			0,
+			"strides must have 2 values",
+		},
+		{
+			&onnxNode{OpType: "Pad", Inputs: []string{"input"},
+				Attrs: []*onnxAttr{pads, {Name: "value", Type: onnxTypeFloat, Float: 1}}},
+			2,
+			"only zero padding is supported",
+		},
+		{
+			&onnxNode{OpType: "Pad", Inputs: []string{"input"},
+				Attrs: []*onnxAttr{pads, {Name: "mode", Type: onnxTypeString,
+					String: "reflect"}}},
+			2,
+			"mode reflect is not supported",
+		},
+		{
+			&onnxNode{OpType: "Relu", Inputs: []string{"input"}, Outputs: []string{}},
+			0,
+			"expected 1 output but got 0",
+		},
+	}
+	for _, test := range tests {
+		if test.node.Outputs == nil {
+			test.node.Outputs = []string{"output"}
+		}
+		graph := &onnxGraph{
+			Nodes:        []*onnxNode{test.node},
+			Initializers: []*onnxTensor{weights},
+			Inputs:       []*onnxValueInfo{{Name: "input", Dims: []int64{-1, 1, 2, 2}}},
+			Outputs:      []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 1, 2, 2}}},
+			Opset:        test.opset,
+		}
+		_, err := ReadONNX(bytes.NewReader(graph.encodeModel()))
+		var unsupported *UnsupportedONNXError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("%s: unexpected error: %v", test.reason, err)
+			continue
+		}
+		expected := []UnsupportedONNXNode{
+			{Name: "#0", OpType: test.node.OpType, Reason: test.reason},
+		}
+		if !reflect.DeepEqual(unsupported.Nodes, expected) {
+			t.Errorf("expected %v but got %v", expected, unsupported.Nodes)
+		}
+	}
+}
+
+func TestReadONNXSoftmax(t *testing.T) {
+	for _, test := range []struct {
+		opset   int
+		flatten bool
+		ok      bool
+	}{
+		{opset: 11, flatten: false, ok: false},
+		{opset: 11, flatten: true, ok: true},
+		{opset: 13, flatten: false, ok: true},
+	} {
+		nodes := []*onnxNode{
+			{OpType: "Softmax", Inputs: []string{"input"}, Outputs: []string{"output"},
+				Attrs: []*onnxAttr{{Name: "axis", Type: onnxTypeInt, Int: 1}}},
+		}
+		if test.flatten {
+			nodes = append([]*onnxNode{
+				{OpType: "Flatten", Inputs: []string{"input"}, Outputs: []string{"flat"}},
+			}, nodes...)
+			nodes[1].Inputs = []string{"flat"}
+		}
+		graph := &onnxGraph{
+			Nodes:   nodes,
+			Inputs:  []*onnxValueInfo{{Name: "input", Dims: []int64{-1, 3, 2, 2}}},
+			Outputs: []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 3, 2, 2}}},
+			Opset:   test.opset,
+		}
+		_, err := ReadONNX(bytes.NewReader(graph.encodeModel()))
+		if (err == nil) != test.ok {
+			t.Errorf("opset %d, flatten %v: unexpected error: %v", test.opset,
+				test.flatten, err)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package convmarkup
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func exportONNX(t *testing.T, markup string) *onnxGraph {
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := testWeights(func(b Block, p Param) ([]float32, error) {
+		res := make([]float32, p.Size())
+		for i := range res {
+			res[i] = float32(i)
+		}
+		return res, nil
+	})
+	var buf bytes.Buffer
+	if err := WriteONNX(&buf, block, Dims{}, weights); err != nil {
+		t.Fatal(err)
+	}
+	graph, err := decodeONNXModel(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+func TestWriteONNX(t *testing.T) {
+	graph := exportONNX(t, `Input(w=3, h=2, d=4)
+Padding(t=1, b=0, l=2, r=0)
+Conv(w=3, h=3, n=4, sx=2, dx=2, p=1, groups=2)
+Residual {
+	Projection {
+		BatchNorm
+	}
+	MaxPool(w=1, h=1)
+	Dropout(prob=0.5)
+}
+Linear(scale=2, bias=1)
+Repeat(n=2) {
+	Tanh
+}
+Assert(w=2, h=3, d=4)
+FC(out=2)
+Softmax`)
+
+	var ops []string
+	for _, node := range graph.Nodes {
+		ops = append(ops, node.OpType)
+	}
+	expectedOps := []string{"Pad", "Conv", "BatchNormalization", "MaxPool", "Dropout",
+		"Add", "Mul", "Add", "Tanh", "Tanh", "Flatten", "Gemm", "Softmax"}
+	if !reflect.DeepEqual(ops, expectedOps) {
+		t.Fatalf("expected ops %v but got %v", expectedOps, ops)
+	}
+
+	for i, node := range graph.Nodes[1:] {
+		if node.Inputs[0] != graph.Nodes[i].Outputs[0] && node.OpType != "MaxPool" &&
+			node.OpType != "Add" {
+			t.Errorf("node %d is not connected to the previous node", i+1)
+		}
+	}
+	residual := graph.Nodes[5]
+	if residual.Inputs[0] != graph.Nodes[2].Outputs[0] ||
+		residual.Inputs[1] != graph.Nodes[4].Outputs[0] ||
+		graph.Nodes[3].Inputs[0] != graph.Nodes[1].Outputs[0] {
+		t.Errorf("bad residual connections")
+	}
+
+	conv := graph.Nodes[1]
+	expectedAttrs := map[string][]int64{
+		"kernel_shape": {3, 3},
+		"strides":      {1, 2},
+		"pads":         {1, 1, 1, 1},
+		"dilations":    {1, 2},
+	}
+	for _, attr := range conv.Attrs {
+		if attr.Name == "group" {
+			if attr.Int != 2 {
+				t.Errorf("unexpected groups: %d", attr.Int)
+			}
+		} else if !reflect.DeepEqual(attr.Ints, expectedAttrs[attr.Name]) {
+			t.Errorf("attribute %s: expected %v but got %v", attr.Name,
+				expectedAttrs[attr.Name], attr.Ints)
+		}
+	}
+	if len(conv.Attrs) != 5 {
+		t.Errorf("expected 5 conv attributes but got %d", len(conv.Attrs))
+	}
+
+	initializers := map[string]*onnxTensor{}
+	for _, init := range graph.Initializers {
+		initializers[init.Name] = init
+	}
+	if pads := initializers[graph.Nodes[0].Inputs[1]]; pads == nil ||
+		!reflect.DeepEqual(pads.Int64s, []int64{0, 0, 1, 2, 0, 0, 0, 0}) {
+		t.Errorf("unexpected pads: %v", pads)
+	}
+	if w := initializers[conv.Inputs[1]]; w == nil ||
+		!reflect.DeepEqual(w.Dims, []int64{4, 2, 3, 3}) {
+		t.Errorf("unexpected conv weights: %v", w)
+	}
+	if len(graph.Nodes[2].Inputs) != 5 {
+		t.Errorf("unexpected BatchNormalization inputs: %v", graph.Nodes[2].Inputs)
+	}
+	if scale := initializers[graph.Nodes[6].Inputs[1]]; scale == nil ||
+		len(scale.Dims) != 0 || !reflect.DeepEqual(scale.Floats, []float32{2}) {
+		t.Errorf("unexpected scale: %v", scale)
+	}
+
+	// The FC weights should be permuted from HWC to CHW.
+	fcWeights := initializers[graph.Nodes[11].Inputs[1]]
+	if !reflect.DeepEqual(fcWeights.Dims, []int64{2, 24}) {
+		t.Errorf("unexpected FC weight shape: %v", fcWeights.Dims)
+	}
+	expectedRow := []float32{0, 4, 8, 12, 16, 20, 1, 5, 9, 13, 17, 21, 2, 6, 10, 14,
+		18, 22, 3, 7, 11, 15, 19, 23}
+	if !reflect.DeepEqual(fcWeights.Floats[:24], expectedRow) {
+		t.Errorf("unexpected FC weights: %v", fcWeights.Floats[:24])
+	}
+
+	expectedInputs := []*onnxValueInfo{{Name: "input", Dims: []int64{-1, 4, 2, 3}}}
+	if !reflect.DeepEqual(graph.Inputs, expectedInputs) {
+		t.Errorf("unexpected inputs: %v", graph.Inputs[0])
+	}
+	expectedOutputs := []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 2}}}
+	if !reflect.DeepEqual(graph.Outputs, expectedOutputs) {
+		t.Errorf("unexpected outputs: %v", graph.Outputs[0])
+	}
+	if last := graph.Nodes[len(graph.Nodes)-1]; last.Outputs[0] != "output" {
+		t.Errorf("unexpected final output: %s", last.Outputs[0])
+	}
+}
+
+func TestWriteONNXReshape(t *testing.T) {
+	graph := exportONNX(t, `Input(w=2, h=2, d=1)
+FC(out=4)
+ReLU
+FC(out=3)
+Residual {
+	FC(out=3)
+}
+Conv(w=1, h=1, n=2)`)
+	var ops []string
+	for _, node := range graph.Nodes {
+		ops = append(ops, node.OpType)
+	}
+	expectedOps := []string{"Flatten", "Gemm", "Relu", "Gemm", "Gemm", "Add",
+		"Reshape", "Conv"}
+	if !reflect.DeepEqual(ops, expectedOps) {
+		t.Errorf("expected ops %v but got %v", expectedOps, ops)
+	}
+	expectedOutputs := []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 2, 1, 1}}}
+	if !reflect.DeepEqual(graph.Outputs, expectedOutputs) {
+		t.Errorf("unexpected outputs: %v", graph.Outputs[0])
+	}
+
+	graph = exportONNX(t, "Input(w=2, h=2, d=1)\nAssert(w=2, h=2, d=1)")
+	if len(graph.Nodes) != 1 || graph.Nodes[0].OpType != "Identity" ||
+		graph.Nodes[0].Inputs[0] != "input" || graph.Nodes[0].Outputs[0] != "output" {
+		t.Errorf("unexpected nodes: %v", graph.Nodes)
+	}
+}
+
+func TestWriteONNXConcat(t *testing.T) {
+	graph := exportONNX(t, `Input(w=4, h=4, d=2)
+Concat {
+	Branch {
+	}
+	Branch {
+		Deconv(w=2, h=2, n=3, sx=2, sy=2, ox=1)
+		MaxPool(w=2, h=2)
+	}
+	Branch {
+		Resize(w=3, h=5)
+		Resize(w=4, h=4)
+	}
+}`)
+	var ops []string
+	for _, node := range graph.Nodes {
+		ops = append(ops, node.OpType)
+	}
+	expectedOps := []string{"ConvTranspose", "MaxPool", "Shape", "Slice", "Concat",
+		"Resize", "Shape", "Slice", "Concat", "Resize", "Concat"}
+	if !reflect.DeepEqual(ops, expectedOps) {
+		t.Fatalf("expected ops %v but got %v", expectedOps, ops)
+	}
+
+	concat := graph.Nodes[10]
+	expectedInputs := []string{"input", graph.Nodes[1].Outputs[0], graph.Nodes[9].Outputs[0]}
+	if !reflect.DeepEqual(concat.Inputs, expectedInputs) {
+		t.Errorf("expected concat inputs %v but got %v", expectedInputs, concat.Inputs)
+	}
+	if len(concat.Attrs) != 1 || concat.Attrs[0].Name != "axis" || concat.Attrs[0].Int != 1 {
+		t.Errorf("unexpected concat attributes: %v", concat.Attrs)
+	}
+	if concat.Outputs[0] != "output" {
+		t.Errorf("unexpected final output: %s", concat.Outputs[0])
+	}
+	expectedOutputs := []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 7, 4, 4}}}
+	if !reflect.DeepEqual(graph.Outputs, expectedOutputs) {
+		t.Errorf("unexpected outputs: %v", graph.Outputs[0])
+	}
+
+	initializers := map[string]*onnxTensor{}
+	for _, init := range graph.Initializers {
+		initializers[init.Name] = init
+	}
+
+	deconv := graph.Nodes[0]
+	if deconv.Inputs[0] != "input" || len(deconv.Inputs) != 3 {
+		t.Errorf("unexpected deconv inputs: %v", deconv.Inputs)
+	}
+	if w := initializers[deconv.Inputs[1]]; w == nil ||
+		!reflect.DeepEqual(w.Dims, []int64{2, 3, 2, 2}) {
+		t.Errorf("unexpected deconv weights: %v", w)
+	}
+	expectedAttrs := map[string][]int64{
+		"kernel_shape":   {2, 2},
+		"strides":        {2, 2},
+		"output_padding": {0, 1},
+	}
+	for _, attr := range deconv.Attrs {
+		if !reflect.DeepEqual(attr.Ints, expectedAttrs[attr.Name]) {
+			t.Errorf("attribute %s: expected %v but got %v", attr.Name,
+				expectedAttrs[attr.Name], attr.Ints)
+		}
+	}
+	if len(deconv.Attrs) != 3 {
+		t.Errorf("expected 3 deconv attributes but got %d", len(deconv.Attrs))
+	}
+
+	resize := graph.Nodes[5]
+	sizes := graph.Nodes[4]
+	if !reflect.DeepEqual(resize.Inputs, []string{"input", "", "", sizes.Outputs[0]}) {
+		t.Errorf("unexpected resize inputs: %v", resize.Inputs)
+	}
+	if size := initializers[sizes.Inputs[1]]; size == nil ||
+		!reflect.DeepEqual(size.Int64s, []int64{5, 3}) {
+		t.Errorf("unexpected resize size: %v", size)
+	}
+	if graph.Nodes[3].Inputs[0] != graph.Nodes[2].Outputs[0] ||
+		sizes.Inputs[0] != graph.Nodes[3].Outputs[0] ||
+		graph.Nodes[2].Inputs[0] != "input" {
+		t.Errorf("bad resize shape connections")
+	}
+	for _, attr := range resize.Attrs {
+		expected := map[string]string{
+			"mode":                           "linear",
+			"coordinate_transformation_mode": "half_pixel",
+		}[attr.Name]
+		if attr.String != expected {
+			t.Errorf("attribute %s: expected %q but got %q", attr.Name, expected,
+				attr.String)
+		}
+	}
+	if graph.Nodes[9].Inputs[0] != resize.Outputs[0] {
+		t.Errorf("resize blocks are not connected")
+	}
+}
+
+func TestWriteONNXDeconvRoundTrip(t *testing.T) {
+	markup := "Input(w=3, h=2, d=2)\nDeconv(w=3, h=2, n=4, sx=2, sy=3, ox=1, oy=2)\n"
+	graph := exportONNX(t, markup)
+	imported, err := ReadONNX(bytes.NewReader(graph.encodeModel()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Format(imported); actual != markup {
+		t.Errorf("expected:\n%s\ngot:\n%s", markup, actual)
+	}
+}
+
+func TestWriteONNXConcatFlat(t *testing.T) {
+	graph := exportONNX(t, `Input(w=2, h=2, d=1)
+Concat {
+	Branch {
+		FC(out=2)
+	}
+	Branch {
+		FC(out=3)
+	}
+}
+Concat {
+	Branch {
+	}
+	Branch {
+		Conv(w=1, h=1, n=2)
+	}
+}`)
+	var ops []string
+	for _, node := range graph.Nodes {
+		ops = append(ops, node.OpType)
+	}
+	expectedOps := []string{"Flatten", "Gemm", "Flatten", "Gemm", "Concat", "Reshape",
+		"Conv", "Reshape", "Concat"}
+	if !reflect.DeepEqual(ops, expectedOps) {
+		t.Fatalf("expected ops %v but got %v", expectedOps, ops)
+	}
+	expectedOutputs := []*onnxValueInfo{{Name: "output", Dims: []int64{-1, 7, 1, 1}}}
+	if !reflect.DeepEqual(graph.Outputs, expectedOutputs) {
+		t.Errorf("unexpected outputs: %v", graph.Outputs[0])
+	}
+}
+
+func TestWriteONNXErrors(t *testing.T) {
+	parsed, err := Parse("Input(w=2, h=2, d=1)\nInput(w=2, h=2, d=1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WriteONNX(&bytes.Buffer{}, block, Dims{}, &RandomWeights{})
+	if err == nil || !strings.Contains(err.Error(), "unexpected Input block") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	in := Dims{Width: 2, Height: 2, Depth: 1}
+	err = WriteONNX(&bytes.Buffer{}, &Root{Children: []Block{&Input{Out: in},
+		valueBlock{Out: in}}}, Dims{}, &RandomWeights{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported block: Value") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = WriteONNX(&bytes.Buffer{}, &Root{Children: []Block{&Activation{Name: "ReLU"}}},
+		Dims{}, &RandomWeights{})
+	if err == nil || !strings.Contains(err.Error(), "missing Input block") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+)
+
+// A Param describes a tensor of parameters.
+type Param struct {
+	Name  string
+	Shape []int
+
+	// NonTrainable is true for parameters which are not
+	// learned by gradient descent, such as the running
+	// statistics of a BatchNorm.
+	NonTrainable bool
+}
+
+// Size returns the number of values in the parameter.
+func (p Param) Size() int {
+	res := 1
+	for _, x := range p.Shape {
+		res *= x
+	}
+	return res
+}
+
+// Params returns the parameters of a single block, not
+// including the parameters of its sub-blocks.
+// The in argument specifies the block's input dimensions.
+//
+// Conv weights have the shape [n, d/groups, h, w], where d
+// is the input depth.
+// Deconv weights have the shape [d, n, h, w].
+// FC weights have the shape [out, in], where in is the
+// input volume.
+// BatchNorm has a scale and a bias for each input channel,
+// as well as a non-trainable mean and variance.
+func Params(b Block, in Dims) []Param {
+	switch b := b.(type) {
+	case *Conv:
+		return []Param{
+			{Name: "weights", Shape: []int{b.FilterCount, in.Depth / b.Groups,
+				b.FilterHeight, b.FilterWidth}},
+			{Name: "biases", Shape: []int{b.FilterCount}},
+		}
+	case *Deconv:
+		return []Param{
+			{Name: "weights", Shape: []int{in.Depth, b.FilterCount,
+				b.FilterHeight, b.FilterWidth}},
+			{Name: "biases", Shape: []int{b.FilterCount}},
+		}
+	case *FC:
+		return []Param{
+			{Name: "weights", Shape: []int{b.OutCount, in.Volume()}},
+			{Name: "biases", Shape: []int{b.OutCount}},
+		}
+	case *Activation:
+		if b.Name == "BatchNorm" {
+			return []Param{
+				{Name: "scales", Shape: []int{in.Depth}},
+				{Name: "biases", Shape: []int{in.Depth}},
+				{Name: "means", Shape: []int{in.Depth}, NonTrainable: true},
+				{Name: "variances", Shape: []int{in.Depth}, NonTrainable: true},
+			}
+		}
+	}
+	return nil
+}
+
+// ParamCount counts the trainable parameters in a block
+// and all of its sub-blocks.
+// The contents of a Repeat are counted once per copy.
+func ParamCount(b Block, in Dims) int {
+	return CountParams(b, in, nil).Total
+}
+
+// A ParamRow describes the parameters of one block.
+type ParamRow struct {
+	// Line is the line number (starting at 0) of the block,
+	// or -1 if it is unknown.
+	Line int
+
+	Depth int
+	Type  string
+
+	// Params is the number of trainable parameters in one
+	// copy of the block.
+	Params int
+
+	// Count is the number of copies of the block, which is
+	// greater than 1 inside of Repeat blocks.
+	Count int
+}
+
+// A ParamReport breaks down the trainable parameters of a
+// block tree.
+type ParamReport struct {
+	// Rows contains a row for every block that has its own
+	// parameters, in order of appearance.
+	Rows []ParamRow
+
+	// Total is the total number of parameters, including
+	// the parameters of every copy of repeated blocks.
+	Total int
+}
+
+// CountParams produces a ParamReport for a block and all
+// of its sub-blocks.
+//
+// The in argument specifies the block's input dimensions.
+// For a Root, Dims{} suffices.
+//
+// The SourceMap is used to look up line numbers.
+// It may be nil.
+func CountParams(b Block, in Dims, src SourceMap) *ParamReport {
+	res := &ParamReport{}
+	Walk(b, in, func(step WalkStep) error {
+		var count int
+		for _, p := range Params(step.Block, step.In) {
+			if !p.NonTrainable {
+				count += p.Size()
+			}
+		}
+		if count == 0 {
+			return nil
+		}
+		res.Rows = append(res.Rows, ParamRow{
+			Line:   src.Line(step.Block),
+			Depth:  step.Depth,
+			Type:   step.Block.Type(),
+			Params: count,
+			Count:  step.Count,
+		})
+		res.Total += count * step.Count
+		return nil
+	})
+	return res
+}
+
+// String renders the report as a table.
+func (p *ParamReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tBLOCK\tPARAMS\tCOUNT")
+	for _, row := range p.Rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", formatLine(row.Line), row.Type,
+			row.Params, row.Count)
+	}
+	fmt.Fprintf(w, "\tTotal\t%d\n", p.Total)
+	w.Flush()
+	return buf.String()
+}
+
+// formatLine formats a line number for display.
+func formatLine(line int) string {
+	if line < 0 {
+		return "-"
+	}
+	return fmt.Sprint(line + 1)
+}
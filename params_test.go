@@ -0,0 +1,70 @@
+package convmarkup
+
+import "testing"
+
+func TestCountParams(t *testing.T) {
+	markup := `Input(w=8, h=8, d=3)
+Conv(w=3, h=3, n=4)
+BatchNorm
+ReLU
+Residual {
+	Projection {
+		Conv(w=1, h=1, n=2)
+	}
+	Repeat(n=3) {
+		Conv(w=1, h=1, n=4)
+	}
+	Conv(w=1, h=1, n=2)
+}
+FC(out=5)
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := CountParams(block, Dims{}, src)
+
+	expected := []ParamRow{
+		{Line: 1, Depth: 1, Type: "Conv", Params: 3*3*3*4 + 4, Count: 1},
+		{Line: 2, Depth: 1, Type: "BatchNorm", Params: 8, Count: 1},
+		{Line: 6, Depth: 2, Type: "Conv", Params: 4*2 + 2, Count: 1},
+		{Line: 9, Depth: 3, Type: "Conv", Params: 4*4 + 4, Count: 3},
+		{Line: 11, Depth: 2, Type: "Conv", Params: 4*2 + 2, Count: 1},
+		{Line: 13, Depth: 1, Type: "FC", Params: 6*6*2*5 + 5, Count: 1},
+	}
+	if len(report.Rows) != len(expected) {
+		t.Fatalf("expected %d rows but got %d", len(expected), len(report.Rows))
+	}
+	var total int
+	for i, x := range expected {
+		if report.Rows[i] != x {
+			t.Errorf("row %d: expected %v but got %v", i, x, report.Rows[i])
+		}
+		total += x.Params * x.Count
+	}
+	if report.Total != total {
+		t.Errorf("expected total %d but got %d", total, report.Total)
+	}
+	if count := ParamCount(block, Dims{}); count != total {
+		t.Errorf("expected ParamCount %d but got %d", total, count)
+	}
+}
+
+func TestParamsGroups(t *testing.T) {
+	in := Dims{Width: 5, Height: 5, Depth: 8}
+	conv, err := CreateConv(in, map[string]float64{"w": 3, "h": 3, "n": 8,
+		"groups": 8}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := ParamCount(conv, in); count != 8*3*3+8 {
+		t.Errorf("unexpected parameter count: %d", count)
+	}
+	if cost := BlockCost(conv, in); cost.MACs != 3*3*8*3*3 {
+		t.Errorf("unexpected MACs: %d", cost.MACs)
+	}
+}
@@ -3,14 +3,20 @@ package convmarkup
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 var (
-	commandExpr = regexp.MustCompile(`^([A-Za-z]*)(\(([^\)]*)\))?( {)?$`)
-	argExpr     = regexp.MustCompile(`^ *([A-Za-z]*)=([\-0-9\.]*) *$`)
+	commandExpr = regexp.MustCompile(`^([A-Za-z]*)(\((.*)\))?( {)?$`)
+	argExpr     = regexp.MustCompile(`^ *([A-Za-z]*)=(.*)$`)
+	letExpr     = regexp.MustCompile(`^let +([^ =]*) *=(.*)$`)
+	defineExpr  = regexp.MustCompile(`^Define +([A-Za-z]*)(\(([^\)]*)\))? *{$`)
+	includeExpr = regexp.MustCompile(`^Include *\((.*)\)$`)
 )
 
 // A ParseError is an error produced while trying to parse
@@ -20,12 +26,166 @@ type ParseError struct {
 
 	// Line is the line number, starting at 0.
 	Line int
+
+	// File is the name of the file containing the error.
+	// It is empty for code passed directly to Parse.
+	File string
+
+	// Span is the range of code which caused the error, or
+	// nil if it is unknown.
+	// It may point to a block name or to an attribute.
+	Span *Span
+
+	// Err is the underlying error, if there is one.
+	// For errors from creating Blocks, it is a *BlockError.
+	Err error
 }
 
 // Error produces an error message that incorporates the
-// error message and line number.
+// error message, file name, and line number.
 func (p *ParseError) Error() string {
-	return fmt.Sprintf("line %d: %s", p.Line+1, p.Message)
+	return position(p.File, p.Line) + ": " + p.Message
+}
+
+// Unwrap returns the underlying error, if there is one.
+func (p *ParseError) Unwrap() error {
+	return p.Err
+}
+
+// position formats a file name and line number for use
+// in error messages.
+func position(file string, line int) string {
+	if file == "" {
+		return fmt.Sprintf("line %d", line+1)
+	}
+	return fmt.Sprintf("%s: line %d", file, line+1)
+}
+
+// A Pos is a position in a markup file.
+type Pos struct {
+	// Line is the line number, starting at 0.
+	Line int
+
+	// Col is the byte offset within the line, starting
+	// at 0.
+	Col int
+}
+
+// A Span is a range of code in a markup file, from Start
+// up to (but not including) End.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// lineSpan creates a Span for the text s, which starts at
+// the given column of a line.
+// Surrounding whitespace is not included in the Span.
+func lineSpan(line, col int, s string) Span {
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	col += len(s) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	return Span{
+		Start: Pos{Line: line, Col: col},
+		End:   Pos{Line: line, Col: col + len(trimmed)},
+	}
+}
+
+// An ErrorList is a list of errors found in a piece of
+// code, in the order they were found.
+type ErrorList []*ParseError
+
+// Error returns the first error message, followed by the
+// number of additional errors.
+func (e ErrorList) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// Err returns nil if the list is empty, or the list
+// itself otherwise.
+func (e ErrorList) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Unwrap returns the errors in the list, for use with
+// errors.Is and errors.As.
+func (e ErrorList) Unwrap() []error {
+	res := make([]error, len(e))
+	for i, x := range e {
+		res[i] = x
+	}
+	return res
+}
+
+// A BlockError is an error from creating the Block for an
+// ASTNode.
+type BlockError struct {
+	// Node is the node which failed.
+	Node *ASTNode
+
+	// Name is the block name of the node.
+	Name string
+
+	// In is the input dimensions of the block.
+	In Dims
+
+	// Path contains the nodes from the root node to Node,
+	// inclusive.
+	// If Node is part of a macro, Path includes the macro
+	// invocations which led to it.
+	Path []*ASTNode
+
+	// Macros contains the macro invocations in Path,
+	// outermost first.
+	Macros []*ASTNode
+
+	// Err is the underlying error, which usually comes from
+	// a Creator.
+	Err error
+}
+
+// Error produces an error message that incorporates the
+// line number of the node and of every macro invocation
+// leading to it.
+func (b *BlockError) Error() string {
+	var res strings.Builder
+	for _, call := range b.Macros {
+		res.WriteString(call.position() + ": in " + call.BlockName + ": ")
+	}
+	res.WriteString(b.Node.position() + ": " + b.Err.Error())
+	return res.String()
+}
+
+// Unwrap returns the underlying error.
+func (b *BlockError) Unwrap() error {
+	return b.Err
+}
+
+// parseError creates a ParseError which reports the error
+// at the outermost macro invocation, or at the node itself
+// if it is not part of a macro.
+func (b *BlockError) parseError() *ParseError {
+	node, span := b.Node, b.Node.errorSpan(b.Err)
+	if len(b.Macros) > 0 {
+		node = b.Macros[0]
+		span = node.errorSpan(nil)
+	}
+	return &ParseError{
+		Message: strings.TrimPrefix(b.Error(), node.position()+": "),
+		Line:    node.Line,
+		File:    node.File,
+		Span:    span,
+		Err:     b,
+	}
 }
 
 // ASTNode is a node in a parsed markup file.
@@ -38,147 +198,724 @@ type ASTNode struct {
 	// Line is the line number, starting at 0.
 	Line int
 
+	// File is the name of the file containing the node.
+	// It is empty for code passed directly to Parse.
+	File string
+
 	BlockName string
 	Attrs     map[string]float64
 	Children  []*ASTNode
+
+	// NameSpan is the location of the block name.
+	NameSpan Span
+
+	// AttrSpans maps attribute names to the locations of
+	// their declarations, such as "w=3".
+	AttrSpans map[string]Span
+
+	// Exprs stores attribute values which refer to macro
+	// parameters, and therefore cannot be evaluated until
+	// the macro is expanded.
+	// It is only used inside of macro bodies.
+	Exprs map[string]Expr
+
+	// Macros contains the macros defined in a file.
+	// The parser only sets it on the root node.
+	Macros []*Macro
+}
+
+// newASTNode creates a node which was not parsed from
+// code, such as a node converted from another format.
+func newASTNode(name string, attrs map[string]float64) *ASTNode {
+	if attrs == nil {
+		attrs = map[string]float64{}
+	}
+	return &ASTNode{BlockName: name, Attrs: attrs}
 }
 
 // Parse converts a string of code into a root ASTNode for
 // a markup file.
+//
+// Since there is no file system to read from, the code may
+// not contain Include directives.
 func Parse(contents string) (*ASTNode, error) {
-	lines := strings.Split(contents, "\n")
-	for i, x := range lines {
-		y := strings.TrimSpace(x)
-		if strings.HasPrefix(y, "#") {
-			lines[i] = ""
-		} else {
-			lines[i] = y
-		}
+	p := &parser{env: map[string]float64{}}
+	root, errs := p.parse(contents)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return root, nil
+}
+
+// ParseAll is like Parse, but it reports every error in
+// the code rather than stopping at the first one.
+//
+// After a line with an error, parsing resumes at the next
+// line, or after the closing brace if the line opened a
+// block.
+// If there are errors, they are returned as an ErrorList
+// along with the nodes which could be parsed.
+func ParseAll(contents string) (*ASTNode, error) {
+	p := &parser{env: map[string]float64{}}
+	root, errs := p.parse(contents)
+	return root, errs.Err()
+}
+
+// ParseFS reads and parses a markup file from a file
+// system.
+//
+// Include directives in the file are resolved relative to
+// the directory of the file containing them.
+// Every ASTNode and ParseError records the name of the file
+// it came from.
+func ParseFS(fsys fs.FS, name string) (*ASTNode, error) {
+	p, data, err := newFSParser(fsys, name)
+	if err != nil {
+		return nil, err
 	}
-	parsed, err := parseLines(0, lines)
+	root, errs := p.parse(data)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return root, nil
+}
+
+// ParseAllFS is like ParseFS, but it reports every error,
+// as in ParseAll.
+func ParseAllFS(fsys fs.FS, name string) (*ASTNode, error) {
+	p, data, err := newFSParser(fsys, name)
 	if err != nil {
 		return nil, err
 	}
-	return &ASTNode{Children: parsed}, nil
+	root, errs := p.parse(data)
+	return root, errs.Err()
+}
+
+func newFSParser(fsys fs.FS, name string) (*parser, string, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, "", err
+	}
+	p := &parser{
+		env:       map[string]float64{},
+		fsys:      fsys,
+		including: []string{name},
+	}
+	return p, string(data), nil
 }
 
 // Block creates a Block instance for the node.
 //
 // If this is the root node, passing Dims{} as the input
 // dimensions should suffice.
+//
+// Macros are only looked up in a.Macros, so to create a
+// Block for a subtree which uses macros, give a copy of
+// the subtree's node the Macros of the root node.
+//
+// If a node fails, the error is a *ParseError wrapping a
+// *BlockError, which can be found with errors.As.
 func (a *ASTNode) Block(in Dims, c map[string]Creator) (Block, error) {
-	creator, ok := c[a.BlockName]
+	res, err := a.block(in, c, nil)
+	if errs, ok := err.(ErrorList); ok {
+		return nil, errs[0]
+	}
+	return res, err
+}
+
+// BlockAll is like Block, but it reports every error it
+// can find rather than stopping at the first one.
+//
+// Once a block fails, the input dimensions of the blocks
+// after it are unknown, so those blocks are not checked.
+// However, the children of a failed block are still
+// checked, as are independent subtrees such as the
+// branches of a Concat and the projection of a Residual.
+//
+// If there are errors, they are returned as an ErrorList.
+func (a *ASTNode) BlockAll(in Dims, c map[string]Creator) (Block, error) {
+	return a.block(in, c, nil)
+}
+
+// BlockSources is like Block, but it also returns a
+// SourceMap which maps every Block in the resulting tree
+// to the node that created it.
+func (a *ASTNode) BlockSources(in Dims, c map[string]Creator) (Block, SourceMap,
+	error) {
+	src := SourceMap{}
+	res, err := a.block(in, c, src)
+	if errs, ok := err.(ErrorList); ok {
+		return nil, nil, errs[0]
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return res, src, nil
+}
+
+// block creates a Block for the node, returning an
+// ErrorList if any of the node's descendants fail.
+func (a *ASTNode) block(in Dims, c map[string]Creator, src SourceMap) (Block, error) {
+	if _, ok := c[a.BlockName]; !ok && a.BlockName == "" {
+		return nil, errors.New("missing Creator for root node")
+	}
+	b := &blockBuilder{
+		creators:  c,
+		macros:    map[string]*Macro{},
+		expanding: map[*Macro]bool{},
+		src:       src,
+	}
+	for _, m := range a.Macros {
+		if _, ok := c[m.Name]; ok {
+			b.errors = append(b.errors, &ParseError{
+				Message: "macro " + m.Name + " conflicts with an existing block",
+				Line:    m.Line,
+				File:    m.File,
+			})
+			continue
+		}
+		b.macros[m.Name] = m
+	}
+	res := b.build(a, in, nil)
+	if err := b.errors.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// passThroughBlocks contains the names of blocks whose
+// output dimensions always match their input dimensions.
+// When such a block fails, the blocks after it can still
+// be checked.
+var passThroughBlocks = map[string]bool{
+	"Projection": true,
+	"Branch":     true,
+}
+
+// blockBuilder creates Blocks from ASTNodes.
+type blockBuilder struct {
+	creators map[string]Creator
+	macros   map[string]*Macro
+
+	// expanding contains the macros which are currently
+	// being expanded, to detect recursion.
+	expanding map[*Macro]bool
+
+	// src may be nil.
+	src SourceMap
+
+	// path contains the nodes being built, starting at the
+	// root, and calls contains the macro invocations being
+	// expanded.
+	path  []*ASTNode
+	calls []*ASTNode
+
+	errors ErrorList
+}
+
+// fail records an error for the node at the end of the
+// current path.
+func (b *blockBuilder) fail(in Dims, err error) {
+	node := b.path[len(b.path)-1]
+	blockErr := &BlockError{
+		Node:   node,
+		Name:   node.BlockName,
+		In:     in,
+		Path:   append([]*ASTNode{}, b.path...),
+		Macros: append([]*ASTNode{}, b.calls...),
+		Err:    err,
+	}
+	b.errors = append(b.errors, blockErr.parseError())
+}
+
+// build creates a Block for a node.
+// If the node or any of its children fail, the errors are
+// recorded and nil is returned.
+//
+// The env argument contains the arguments of the macro
+// being expanded, if there is one.
+func (b *blockBuilder) build(node *ASTNode, in Dims, env map[string]float64) Block {
+	b.path = append(b.path, node)
+	defer func() {
+		b.path = b.path[:len(b.path)-1]
+	}()
+
+	creator, ok := b.creators[node.BlockName]
 	if !ok {
-		if a.BlockName == "" {
-			return nil, errors.New("missing Creator for root node")
+		b.fail(in, fmt.Errorf("missing creator for %s", node.BlockName))
+		return nil
+	}
+
+	attrs, err := node.evalAttrs(env)
+	if err != nil {
+		b.fail(in, err)
+	}
+
+	children, ok := b.buildSeq(node.Children, in, env)
+	if !ok || err != nil {
+		return nil
+	}
+
+	res, err := creator(in, attrs, children)
+	if err != nil {
+		b.fail(in, err)
+		return nil
+	}
+	if _, ok := res.(*Branch); ok {
+		if parent := b.parent(); parent == nil || parent.BlockName != "Concat" {
+			b.fail(in, errors.New("Branch must be a child of Concat"))
+			return nil
 		}
-		return nil, fmt.Errorf("line %d: missing creator for %s", a.Line+1,
-			a.BlockName)
 	}
+	if b.src != nil && pointerBlock(res) {
+		b.src[res] = node
+	}
+	return res
+}
 
-	var children []Block
-	subIn := in
-	for _, ch := range a.Children {
-		child, err := ch.Block(subIn, c)
-		if err != nil {
-			return nil, err
+// parent finds the node containing the node at the end of
+// the current path, skipping macro invocations.
+// It returns nil if the node is the first one built.
+func (b *blockBuilder) parent() *ASTNode {
+	for i := len(b.path) - 2; i >= 0; i-- {
+		if !b.isCall(b.path[i]) {
+			return b.path[i]
 		}
-		children = append(children, child)
-		subIn = child.OutDims()
 	}
+	return nil
+}
 
-	res, err := creator(in, a.Attrs, children)
-	if err == nil {
-		return res, nil
+func (b *blockBuilder) isCall(node *ASTNode) bool {
+	for _, call := range b.calls {
+		if call == node {
+			return true
+		}
 	}
-	return nil, fmt.Errorf("line %d: %s", a.Line+1, err.Error())
+	return false
 }
 
-// parseLines parses a list of lines.
-func parseLines(off int, l []string) ([]*ASTNode, error) {
-	var res []*ASTNode
-	for i := 0; i < len(l); i++ {
-		x := l[i]
-		if x == "" {
-			continue
+// buildSeq creates Blocks for a sequence of nodes, where
+// each node is fed the output of the previous one.
+// Macro invocations are replaced by the macro's contents.
+//
+// If any node fails, the errors are recorded and false is
+// returned.
+func (b *blockBuilder) buildSeq(nodes []*ASTNode, in Dims,
+	env map[string]float64) ([]Block, bool) {
+	var res []Block
+	failed := false
+	for _, node := range nodes {
+		var blocks []Block
+		ok := true
+		if m, isMacro := b.macros[node.BlockName]; isMacro {
+			blocks, ok = b.expand(m, node, in, env)
+		} else if block := b.build(node, in, env); block != nil {
+			blocks = []Block{block}
+		} else {
+			ok = false
 		}
-		parsed := commandExpr.FindStringSubmatch(x)
-		if parsed == nil {
-			return nil, &ParseError{
-				Message: "invalid block declaration",
-				Line:    off + i,
+		if !ok {
+			if !passThroughBlocks[node.BlockName] {
+				return nil, false
 			}
+			failed = true
+			continue
+		}
+		for _, block := range blocks {
+			res = append(res, block)
+			in = block.OutDims()
 		}
-		name := parsed[1]
-		attrs, err := parseAttrs(parsed[3])
+	}
+	return res, !failed
+}
+
+// expand creates the Blocks for a macro invocation.
+func (b *blockBuilder) expand(m *Macro, call *ASTNode, in Dims,
+	env map[string]float64) ([]Block, bool) {
+	b.path = append(b.path, call)
+	defer func() {
+		b.path = b.path[:len(b.path)-1]
+	}()
+
+	args, err := call.evalAttrs(env)
+	if err == nil {
+		err = m.checkArgs(args, call.Children)
+	}
+	if err == nil && b.expanding[m] {
+		err = errors.New("recursive macro: " + m.Name)
+	}
+	if err != nil {
+		b.fail(in, err)
+		return nil, false
+	}
+
+	b.expanding[m] = true
+	b.calls = append(b.calls, call)
+	res, ok := b.buildSeq(m.Body, in, args)
+	b.calls = b.calls[:len(b.calls)-1]
+	delete(b.expanding, m)
+	return res, ok
+}
+
+func (a *ASTNode) position() string {
+	return position(a.File, a.Line)
+}
+
+// errorSpan finds the Span to report an error at, or
+// returns nil if the node has no location information.
+func (a *ASTNode) errorSpan(err error) *Span {
+	var attrErr *AttrError
+	if errors.As(err, &attrErr) {
+		if span, ok := a.AttrSpans[attrErr.Attr]; ok {
+			return &span
+		}
+	}
+	if a.NameSpan == (Span{}) {
+		return nil
+	}
+	span := a.NameSpan
+	return &span
+}
+
+// evalAttrs computes the attributes of the node, given the
+// arguments of the macro being expanded.
+func (a *ASTNode) evalAttrs(env map[string]float64) (map[string]float64, error) {
+	if len(a.Exprs) == 0 {
+		return a.Attrs, nil
+	}
+	res := map[string]float64{}
+	for name, val := range a.Attrs {
+		res[name] = val
+	}
+	for name, e := range a.Exprs {
+		val, err := e.Eval(env)
 		if err != nil {
-			return nil, &ParseError{
-				Message: err.Error(),
-				Line:    off + i,
-			}
+			return nil, attrError(name, "bad value for attribute %s: %s", name, err)
 		}
-		node := &ASTNode{
-			Line:      off + i,
-			BlockName: name,
-			Attrs:     attrs,
-		}
-		if parsed[4] != "" {
-			closeIdx, err := matchingClose(l, i)
-			if err != nil {
-				return nil, &ParseError{
-					Message: err.Error(),
-					Line:    off + i,
-				}
+		res[name] = val
+	}
+	return res, nil
+}
+
+// parser stores the state for parsing a markup file.
+type parser struct {
+	// env maps names from let statements to their values.
+	env map[string]float64
+
+	// macro is the macro currently being defined, and
+	// macroNode collects its contents.
+	macro     *Macro
+	macroNode *ASTNode
+
+	// fsys is used to resolve Include directives.
+	// It is nil for code passed directly to Parse.
+	fsys fs.FS
+
+	// including is the stack of files being parsed, the
+	// last of which is the current file.
+	including []string
+
+	// defined maps the names of let constants and macros to
+	// the lines that defined them, so that a file which is
+	// included more than once can repeat its definitions.
+	defined map[string]definition
+
+	errors ErrorList
+}
+
+// A definition is the location of a let statement or a
+// macro definition.
+type definition struct {
+	file string
+	line int
+}
+
+// redefinition checks if a let statement or macro
+// definition on the given line of the current file has
+// already been handled, which happens when the file is
+// included more than once.
+func (p *parser) redefinition(name string, line int) bool {
+	def, ok := p.defined[name]
+	return ok && def == definition{file: p.file(), line: line}
+}
+
+// define records the location of a let statement or a
+// macro definition.
+func (p *parser) define(name string, line int) {
+	if p.defined == nil {
+		p.defined = map[string]definition{}
+	}
+	p.defined[name] = definition{file: p.file(), line: line}
+}
+
+// file returns the name of the file being parsed.
+func (p *parser) file() string {
+	if len(p.including) == 0 {
+		return ""
+	}
+	return p.including[len(p.including)-1]
+}
+
+func (p *parser) errorf(line int, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Message: fmt.Sprintf(format, args...),
+		Line:    line,
+		File:    p.file(),
+	}
+}
+
+// parse parses the lines of a markup file, recording
+// every error.
+func (p *parser) parse(contents string) (*ASTNode, ErrorList) {
+	root := &ASTNode{File: p.file()}
+	p.parseLines(contents, root, root)
+	return root, p.errors
+}
+
+// parseLines parses the lines of a file, adding the
+// resulting nodes as children of parent.
+//
+// The parent is the root node, unless the file is being
+// included from inside of a block.
+func (p *parser) parseLines(contents string, root, parent *ASTNode) {
+	stack := []*ASTNode{parent}
+
+	// skip is the number of open braces which belong to
+	// lines with errors, and skipLine is the first such line.
+	var skip, skipLine int
+
+	for i, rawLine := range strings.Split(contents, "\n") {
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		col := len(rawLine) - len(strings.TrimLeftFunc(rawLine, unicode.IsSpace))
+		if skip > 0 {
+			if line == "}" {
+				skip--
+			} else if strings.HasSuffix(line, "{") {
+				skip++
 			}
-			node.Children, err = parseLines(off+i+1, l[i+1:closeIdx])
-			if err != nil {
-				return nil, err
+			continue
+		}
+		if err := p.parseLine(Pos{Line: i, Col: col}, line, root, &stack); err != nil {
+			p.errors = append(p.errors, err)
+			if strings.HasSuffix(line, "{") {
+				skip, skipLine = 1, i
 			}
-			i = closeIdx
 		}
-		res = append(res, node)
 	}
-	return res, nil
+	if len(stack) > 1 {
+		p.errors = append(p.errors, p.errorf(stack[1].Line, "no matching }"))
+		if stack[1] == p.macroNode {
+			// The macro ends with the file, so that it does
+			// not swallow the lines after an Include.
+			p.macro.Body = p.macroNode.Children
+			p.macro, p.macroNode = nil, nil
+		}
+	} else if skip > 0 {
+		p.errors = append(p.errors, p.errorf(skipLine, "no matching }"))
+	}
 }
 
-// matchingClose finds the matching close curly-brace for
-// the line at the given index.
-func matchingClose(lines []string, open int) (int, error) {
-	numIndent := 1
-	for i := open + 1; i < len(lines); i++ {
-		l := lines[i]
-		if l == "}" {
-			numIndent--
-			if numIndent == 0 {
-				return i, nil
-			}
-		} else if strings.HasSuffix(l, "{") {
-			numIndent++
+// parseLine parses a single non-empty line, updating the
+// stack of open blocks.
+//
+// The line has been stripped of whitespace, and start is
+// the position of its first character.
+func (p *parser) parseLine(start Pos, line string, root *ASTNode,
+	stackPtr *[]*ASTNode) *ParseError {
+	i := start.Line
+	stack := *stackPtr
+	topLevel := len(stack) == 1 && stack[0] == root
+	if line == "}" {
+		if len(stack) == 1 {
+			return p.errorf(i, "unexpected }")
+		}
+		if stack[len(stack)-1] == p.macroNode {
+			p.macro.Body = p.macroNode.Children
+			p.macro, p.macroNode = nil, nil
+		}
+		*stackPtr = stack[:len(stack)-1]
+		return nil
+	}
+	if parsed := defineExpr.FindStringSubmatch(line); parsed != nil {
+		if !topLevel {
+			return p.errorf(i, "Define must be at the top level")
+		}
+		if p.redefinition(parsed[1], i) {
+			// Parse the body again, but discard the result.
+			root = &ASTNode{}
+		}
+		macro, err := parseDefine(root, p.env, parsed[1], parsed[3])
+		if err != nil {
+			return p.errorf(i, "%s", err)
+		}
+		macro.Line = i
+		macro.File = p.file()
+		root.Macros = append(root.Macros, macro)
+		p.define(macro.Name, i)
+		p.macro = macro
+		p.macroNode = &ASTNode{Line: i, File: p.file()}
+		*stackPtr = append(stack, p.macroNode)
+		return nil
+	}
+	if parsed := letExpr.FindStringSubmatch(line); parsed != nil {
+		if !topLevel {
+			return p.errorf(i, "let statements must be at the top level")
 		}
+		if p.redefinition(parsed[1], i) {
+			return nil
+		}
+		if err := p.parseLet(parsed[1], parsed[2]); err != nil {
+			return p.errorf(i, "%s", err)
+		}
+		p.define(parsed[1], i)
+		return nil
+	}
+	if parsed := includeExpr.FindStringSubmatch(line); parsed != nil {
+		return p.include(i, parsed[1], root, stack[len(stack)-1])
+	}
+	node, open, err := p.parseDecl(start, line)
+	if err != nil {
+		return err
+	}
+	parent := stack[len(stack)-1]
+	parent.Children = append(parent.Children, node)
+	if open {
+		*stackPtr = append(stack, node)
 	}
-	return 0, errors.New("no matching }")
+	return nil
 }
 
-// parseAttrs parses an attribute list.
-func parseAttrs(str string) (map[string]float64, error) {
-	res := map[string]float64{}
+// include handles an Include directive on the given line,
+// parsing the included file into parent.
+//
+// Errors in the included file are recorded separately,
+// so the returned error only concerns the directive
+// itself.
+func (p *parser) include(line int, arg string, root, parent *ASTNode) *ParseError {
+	if p.fsys == nil {
+		return p.errorf(line, "Include is not supported without a file system")
+	}
+	name, err := strconv.Unquote(strings.TrimSpace(arg))
+	if err != nil {
+		return p.errorf(line, "invalid file name: %s", arg)
+	}
+	name = path.Join(path.Dir(p.file()), name)
+	if !fs.ValidPath(name) {
+		return p.errorf(line, "invalid file name: %s", arg)
+	}
+	for i, x := range p.including {
+		if x == name {
+			cycle := append(append([]string{}, p.including[i:]...), name)
+			return p.errorf(line, "include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	data, err := fs.ReadFile(p.fsys, name)
+	if err != nil {
+		return p.errorf(line, "%s", err)
+	}
+	p.including = append(p.including, name)
+	p.parseLines(string(data), root, parent)
+	p.including = p.including[:len(p.including)-1]
+	return nil
+}
+
+// parseLet handles a let statement.
+func (p *parser) parseLet(name, value string) error {
+	if !isName(name) {
+		return errors.New("invalid name: " + strconv.Quote(name))
+	}
+	if _, ok := p.env[name]; ok {
+		return errors.New("duplicate definition: " + name)
+	}
+	val, err := evalExpr(value, p.env)
+	if err != nil {
+		return err
+	}
+	p.env[name] = val
+	return nil
+}
+
+// parseDecl parses a block declaration, indicating whether
+// or not the declaration opens a curly brace.
+func (p *parser) parseDecl(start Pos, line string) (node *ASTNode, open bool,
+	err *ParseError) {
+	parsed := commandExpr.FindStringSubmatchIndex(line)
+	if parsed == nil {
+		err = p.errorf(start.Line, "invalid block declaration")
+		span := lineSpan(start.Line, start.Col, line)
+		err.Span = &span
+		return nil, false, err
+	}
+	node = &ASTNode{
+		Line:      start.Line,
+		File:      p.file(),
+		BlockName: line[parsed[2]:parsed[3]],
+		NameSpan:  lineSpan(start.Line, start.Col+parsed[2], line[parsed[2]:parsed[3]]),
+		Attrs:     map[string]float64{},
+		AttrSpans: map[string]Span{},
+	}
+	if parsed[6] >= 0 {
+		if err := p.parseAttrs(node, start.Col+parsed[6], line[parsed[6]:parsed[7]]); err != nil {
+			return nil, false, err
+		}
+	}
+	return node, parsed[8] >= 0, nil
+}
+
+// parseAttrs parses and evaluates an attribute list,
+// adding the attributes to a node.
+// The list starts at the given column of the node's line.
+//
+// Inside of a macro definition, attributes which refer to
+// macro parameters are stored as unevaluated expressions.
+func (p *parser) parseAttrs(node *ASTNode, col int, str string) *ParseError {
 	if str == "" {
-		return res, nil
+		return nil
 	}
 	for i, x := range strings.Split(str, ",") {
-		parsed := argExpr.FindStringSubmatch(x)
-		if parsed == nil {
-			return nil, fmt.Errorf("bad format for attribute %d", i)
-		}
-		name := parsed[1]
-		value, err := strconv.ParseFloat(parsed[2], 64)
-		if err != nil {
-			return nil, fmt.Errorf("bad format for attribute %d", i)
+		span := lineSpan(node.Line, col, x)
+		col += len(x) + 1
+		if err := p.parseAttr(node, i, x); err != nil {
+			res := p.errorf(node.Line, "%s", err)
+			res.Span = &span
+			return res
 		}
-		if _, ok := res[name]; ok {
-			return nil, fmt.Errorf("duplicate attribute: %s", name)
+		node.AttrSpans[argExpr.FindStringSubmatch(x)[1]] = span
+	}
+	return nil
+}
+
+// parseAttr parses the i-th attribute of a node.
+func (p *parser) parseAttr(node *ASTNode, i int, str string) error {
+	parsed := argExpr.FindStringSubmatch(str)
+	if parsed == nil {
+		return fmt.Errorf("bad format for attribute %d", i)
+	}
+	name := parsed[1]
+	if _, ok := node.Attrs[name]; ok {
+		return fmt.Errorf("duplicate attribute: %s", name)
+	} else if _, ok := node.Exprs[name]; ok {
+		return fmt.Errorf("duplicate attribute: %s", name)
+	}
+	e, err := parseExpr(parsed[2])
+	if err == nil {
+		e = substituteExpr(e, p.env)
+		if names := exprNames(e); p.macro != nil && len(names) > 0 {
+			if err = p.macro.checkParams(names); err == nil {
+				if node.Exprs == nil {
+					node.Exprs = map[string]Expr{}
+				}
+				node.Exprs[name] = e
+				return nil
+			}
+		} else {
+			node.Attrs[name], err = e.Eval(p.env)
 		}
-		res[name] = value
 	}
-	return res, nil
+	if err != nil {
+		return fmt.Errorf("bad value for attribute %s: %s", name, err)
+	}
+	return nil
 }
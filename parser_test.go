@@ -1,7 +1,9 @@
 package convmarkup
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -23,6 +25,8 @@ func TestParse(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	// Positions are checked in TestParsePositions.
+	clearSpans(actual)
 	expected := &ASTNode{
 		Children: []*ASTNode{
 			{
@@ -71,6 +75,82 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParsePositions(t *testing.T) {
+	code := "Input(w=8, h=8, d=3)\n" +
+		"\tResidual {\n" +
+		"\t\tConv( w=3,h=3 , n=-x )\n" +
+		"\t}\n" +
+		"  FC(out=10)"
+	root, err := Parse(strings.Replace(code, "x", "1", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	span := func(line, start, end int) Span {
+		return Span{Start: Pos{Line: line, Col: start}, End: Pos{Line: line, Col: end}}
+	}
+	residual := root.Children[1]
+	conv := residual.Children[0]
+	fc := root.Children[2]
+	nameSpans := []Span{root.Children[0].NameSpan, residual.NameSpan, conv.NameSpan,
+		fc.NameSpan}
+	expectedNames := []Span{span(0, 0, 5), span(1, 1, 9), span(2, 2, 6), span(4, 2, 4)}
+	if !reflect.DeepEqual(nameSpans, expectedNames) {
+		t.Errorf("expected name spans %v but got %v", expectedNames, nameSpans)
+	}
+	expectedAttrs := []map[string]Span{
+		{"w": span(0, 6, 9), "h": span(0, 11, 14), "d": span(0, 16, 19)},
+		{},
+		{"w": span(2, 8, 11), "h": span(2, 12, 15), "n": span(2, 18, 22)},
+		{"out": span(4, 5, 11)},
+	}
+	for i, node := range []*ASTNode{root.Children[0], residual, conv, fc} {
+		if !reflect.DeepEqual(node.AttrSpans, expectedAttrs[i]) {
+			t.Errorf("node %d: expected %v but got %v", i, expectedAttrs[i],
+				node.AttrSpans)
+		}
+	}
+
+	_, err = Parse(code)
+	expectedErr := &ParseError{
+		Message: "bad value for attribute n: undefined name: x",
+		Line:    2,
+		Span:    &Span{Start: Pos{Line: 2, Col: 18}, End: Pos{Line: 2, Col: 22}},
+	}
+	if !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("expected %v but got %v", expectedErr, err)
+	}
+
+	root, err = Parse(strings.Replace(code, "x", "0", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.Block(Dims{}, DefaultCreators())
+	err = withoutCause(err)
+	expectedErr = &ParseError{
+		Message: "attribute n cannot be 0",
+		Line:    2,
+		Span:    &Span{Start: Pos{Line: 2, Col: 18}, End: Pos{Line: 2, Col: 22}},
+	}
+	if !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("expected %v but got %v", expectedErr, err)
+	}
+
+	root, err = Parse("Input(w=2, h=2, d=2)\nResidual {\nFC(out=3)\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.Block(Dims{}, DefaultCreators())
+	err = withoutCause(err)
+	expectedErr = &ParseError{
+		Message: "residual output size mismatch",
+		Line:    1,
+		Span:    &Span{Start: Pos{Line: 1, Col: 0}, End: Pos{Line: 1, Col: 8}},
+	}
+	if !reflect.DeepEqual(err, expectedErr) {
+		t.Errorf("expected %v but got %v", expectedErr, err)
+	}
+}
+
 func TestParseErrors(t *testing.T) {
 	invalid := []string{
 		"MyBlock(a=a)",
@@ -78,6 +158,16 @@ func TestParseErrors(t *testing.T) {
 		"MyBlock=2",
 		"MyBlock{\n}",
 		"MyBlock #comment",
+		"MyBlock(a=)",
+		"MyBlock(a=1+)",
+		"MyBlock(a=(1)",
+		"MyBlock(a=1/0)",
+		"MyBlock(a=2 3)",
+		"let x = 3\nlet x = 4",
+		"let 2x = 3",
+		"let x = y",
+		"MyBlock {\nlet x = 3\n}",
+		"}",
 	}
 	for i, x := range invalid {
 		if _, err := Parse(x); err == nil {
@@ -86,6 +176,37 @@ func TestParseErrors(t *testing.T) {
 	}
 }
 
+func TestParseExpressions(t *testing.T) {
+	code := `let width = 64
+		let k = 3
+		let half_k = (k - 1) / 2
+		MyBlock(a=width*2, b=-k, c=-(k+1)*2, d=width % 10 - 1.5, e=half_k)
+		let width2 = width/-4
+		Other(a=width2 - -2, b=.5*4, c=10-4-3, d=12/2/3)
+	`
+	actual, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]float64{
+		{"a": 128, "b": -3, "c": -8, "d": 2.5, "e": 1},
+		{"a": -14, "b": 2, "c": 3, "d": 2},
+	}
+	if len(actual.Children) != len(expected) {
+		t.Fatalf("expected %d children but got %d", len(expected),
+			len(actual.Children))
+	}
+	for i, x := range expected {
+		if !reflect.DeepEqual(actual.Children[i].Attrs, x) {
+			t.Errorf("child %d: expected %v but got %v", i, x,
+				actual.Children[i].Attrs)
+		}
+	}
+	if actual.Children[1].Line != 5 {
+		t.Errorf("unexpected line: %d", actual.Children[1].Line)
+	}
+}
+
 func TestASTNodeBlock(t *testing.T) {
 	markup := `
 	Input(w=224, h=113, d=3)
@@ -139,6 +260,7 @@ func TestASTNodeBlock(t *testing.T) {
 			&Padding{Top: 1, Right: 0, Bottom: 3, Left: 2,
 				Out: Dims{Width: 226, Height: 117, Depth: 3}},
 			&Conv{FilterWidth: 3, FilterHeight: 5, FilterCount: 64, StrideX: 2, StrideY: 4,
+				DilationX: 1, DilationY: 1, Groups: 1,
 				Out: Dims{Width: 112, Height: 29, Depth: 64}},
 			&Activation{Name: "BatchNorm", Out: Dims{Width: 112, Height: 29, Depth: 64}},
 			&Activation{Name: "ReLU", Out: Dims{Width: 112, Height: 29, Depth: 64}},
@@ -148,11 +270,13 @@ func TestASTNodeBlock(t *testing.T) {
 				&Padding{Left: 1, Right: 1, Top: 1, Bottom: 1,
 					Out: Dims{Width: 114, Height: 16, Depth: 64}},
 				&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 64, StrideX: 1,
-					StrideY: 1, Out: Dims{Width: 112, Height: 14, Depth: 64}},
+					StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+					Out: Dims{Width: 112, Height: 14, Depth: 64}},
 			}},
 			&Residual{Projection: []Block{
 				&Conv{FilterWidth: 1, FilterHeight: 1, FilterCount: 128, StrideX: 1,
-					StrideY: 1, Out: Dims{Width: 112, Height: 14, Depth: 128}},
+					StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+					Out: Dims{Width: 112, Height: 14, Depth: 128}},
 				&Debug{Attrs: map[string]float64{"foo": 3},
 					In: Dims{Width: 112, Height: 14, Depth: 128}},
 			}, Residual: []Block{
@@ -160,10 +284,12 @@ func TestASTNodeBlock(t *testing.T) {
 					Children: []Block{&Padding{Left: 1, Right: 1, Top: 1, Bottom: 1,
 						Out: Dims{Width: 114, Height: 16, Depth: 64}},
 						&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 64, StrideX: 1,
-							StrideY: 1, Out: Dims{Width: 112, Height: 14, Depth: 64}}}},
+							StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+							Out: Dims{Width: 112, Height: 14, Depth: 64}}}},
 				&Resize{Out: Dims{Width: 114, Height: 16, Depth: 64}},
 				&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 128, StrideX: 1,
-					StrideY: 1, Out: Dims{Width: 112, Height: 14, Depth: 128}},
+					StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+					Out: Dims{Width: 112, Height: 14, Depth: 128}},
 			}},
 			&Assert{In: Dims{Width: 112, Height: 14, Depth: 128}},
 			&Pool{Name: "MeanPool", Width: 2, Height: 3, StrideX: 1, StrideY: 2,
@@ -203,6 +329,10 @@ func TestASTnodeFailures(t *testing.T) {
 		input + "Residual {\n}",
 		input + "Padding(l=1, r=1, t=3)",
 		input + "MeanPool(w=2, h=2, sx=0)",
+		input + "MaxPool(w=225, h=2)",
+		input + "MeanPool(w=2, h=225)",
+		input + "Conv(w=225, h=225, n=3)\nMaxPool",
+		"Input(w=2, h=2, d=1)\nMaxPool(w=4, h=4)\nMaxPool",
 		input + "Conv(w=3, h=2)",
 		input + "Residual {\nConv(w=3, h=3, n=3)\n}",
 		input + "Residual {\nConv(w=1, h=1, n=5)\n}",
@@ -219,6 +349,22 @@ func TestASTnodeFailures(t *testing.T) {
 		input + "Dropout(foo=1)",
 		input + "Dropout",
 		input + "Debug {\nDebug\n}",
+		input + "Conv(w=3, h=3, n=3, dx=0)",
+		input + "Conv(w=3, h=3, n=3, p=-1)",
+		input + "Conv(w=3, h=3, n=3, same=2)",
+		input + "Conv(w=3, h=3, n=3, p=1, same=1)",
+		input + "Conv(w=3, h=3, n=4, groups=2)",
+		input + "Conv(w=3, h=3, n=3, groups=0)",
+		input + "Deconv(w=3, h=3)",
+		input + "Deconv(w=3, h=3, n=2, sx=2, ox=2)",
+		input + "Deconv(w=3, h=3, n=2, oy=1)",
+		input + "Concat {\n}",
+		input + "Concat {\nConv(w=1, h=1, n=3)\n}",
+		input + "Concat {\nBranch {\n}\nBranch {\nConv(w=3, h=3, n=3)\n}\n}",
+		input + "Concat {\nBranch(n=1) {\n}\n}",
+		input + "Branch {\nReLU\n}",
+		input + "Residual {\nBranch {\n}\n}",
+		input + "Concat {\nBranch {\nBranch {\n}\n}\n}",
 	}
 	for i, x := range invalid {
 		parsed, err := Parse(x)
@@ -232,3 +378,408 @@ func TestASTnodeFailures(t *testing.T) {
 		}
 	}
 }
+
+func TestConcat(t *testing.T) {
+	markup := `
+	Input(w=8, h=6, d=3)
+	Concat {
+		Branch {
+		}
+		Branch {
+			Conv(w=1, h=1, n=16)
+		}
+		Branch {
+			Padding(l=1, r=1, t=1, b=1)
+			Conv(w=3, h=3, n=8)
+		}
+	}
+	Conv(w=1, h=1, n=4)
+	`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := parsed.Block(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := Dims{Width: 8, Height: 6, Depth: 3}
+	expected := &Root{
+		Children: []Block{
+			&Input{Out: in},
+			&Concat{
+				Branches: [][]Block{
+					nil,
+					{
+						&Conv{FilterWidth: 1, FilterHeight: 1, FilterCount: 16,
+							StrideX: 1, StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+							Out: Dims{Width: 8, Height: 6, Depth: 16}},
+					},
+					{
+						&Padding{Left: 1, Right: 1, Top: 1, Bottom: 1,
+							Out: Dims{Width: 10, Height: 8, Depth: 3}},
+						&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 8,
+							StrideX: 1, StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+							Out: Dims{Width: 8, Height: 6, Depth: 8}},
+					},
+				},
+				Out: Dims{Width: 8, Height: 6, Depth: 27},
+			},
+			&Conv{FilterWidth: 1, FilterHeight: 1, FilterCount: 4, StrideX: 1,
+				StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1,
+				Out: Dims{Width: 8, Height: 6, Depth: 4}},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v but got %#v", expected, actual)
+	}
+}
+
+func TestBranchOutsideConcat(t *testing.T) {
+	code := `Define Pair {
+	Branch {
+	}
+	Branch {
+		ReLU
+	}
+}
+Input(w=4, h=4, d=4)
+Concat {
+	Pair
+}
+Repeat(n=1) {
+	Branch {
+		ReLU
+	}
+}`
+	root, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.Block(Dims{}, DefaultCreators())
+	expectedMsg := "line 13: Branch must be a child of Concat"
+	if err == nil || err.Error() != expectedMsg {
+		t.Fatalf("expected %q but got %v", expectedMsg, err)
+	}
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) || blockErr.Node != root.Children[2].Children[0] {
+		t.Errorf("unexpected BlockError: %#v", blockErr)
+	}
+}
+
+func TestConvPadding(t *testing.T) {
+	in := Dims{Width: 10, Height: 7, Depth: 3}
+	tests := []struct {
+		attrs    map[string]float64
+		expected *Conv
+	}{
+		{
+			map[string]float64{"w": 3, "h": 3, "n": 2, "p": 1},
+			&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 2, StrideX: 1,
+				StrideY: 1, DilationX: 1, DilationY: 1, Groups: 1, PadTop: 1, PadRight: 1,
+				PadBottom: 1, PadLeft: 1, Out: Dims{Width: 10, Height: 7, Depth: 2}},
+		},
+		{
+			map[string]float64{"w": 3, "h": 2, "n": 2, "sx": 3, "sy": 2, "same": 1},
+			&Conv{FilterWidth: 3, FilterHeight: 2, FilterCount: 2, StrideX: 3,
+				StrideY: 2, DilationX: 1, DilationY: 1, Groups: 1, PadTop: 0, PadRight: 1,
+				PadBottom: 1, PadLeft: 1, Out: Dims{Width: 4, Height: 4, Depth: 2}},
+		},
+		{
+			map[string]float64{"w": 3, "h": 3, "n": 2, "dx": 2, "dy": 3},
+			&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 2, StrideX: 1,
+				StrideY: 1, DilationX: 2, DilationY: 3, Groups: 1,
+				Out: Dims{Width: 6, Height: 1, Depth: 2}},
+		},
+		{
+			map[string]float64{"w": 3, "h": 3, "n": 2, "dx": 2, "dy": 2, "same": 1},
+			&Conv{FilterWidth: 3, FilterHeight: 3, FilterCount: 2, StrideX: 1,
+				StrideY: 1, DilationX: 2, DilationY: 2, Groups: 1, PadTop: 2, PadRight: 2,
+				PadBottom: 2, PadLeft: 2, Out: Dims{Width: 10, Height: 7, Depth: 2}},
+		},
+		{
+			map[string]float64{"w": 1, "h": 1, "n": 6, "groups": 3},
+			&Conv{FilterWidth: 1, FilterHeight: 1, FilterCount: 6, StrideX: 1,
+				StrideY: 1, DilationX: 1, DilationY: 1, Groups: 3,
+				Out: Dims{Width: 10, Height: 7, Depth: 6}},
+		},
+		{
+			map[string]float64{"w": 5, "h": 5, "n": 2, "sx": 2, "sy": 2},
+			&Conv{FilterWidth: 5, FilterHeight: 5, FilterCount: 2, StrideX: 2,
+				StrideY: 2, DilationX: 1, DilationY: 1, Groups: 1,
+				Out: Dims{Width: 3, Height: 2, Depth: 2}},
+		},
+	}
+	for i, test := range tests {
+		actual, err := CreateConv(in, test.attrs, nil)
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("test %d: expected %#v but got %#v", i, test.expected, actual)
+		}
+	}
+}
+
+func TestDeconv(t *testing.T) {
+	in := Dims{Width: 7, Height: 5, Depth: 3}
+	actual, err := CreateDeconv(in, map[string]float64{"w": 3, "h": 4, "n": 2,
+		"sx": 2, "sy": 3, "ox": 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Deconv{FilterWidth: 3, FilterHeight: 4, FilterCount: 2,
+		StrideX: 2, StrideY: 3, OutPadX: 1,
+		Out: Dims{Width: 16, Height: 16, Depth: 2}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v but got %#v", expected, actual)
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	code := `Input(w=8, h=8, d=3)
+Conv(w=3, h=3, n=x)
+Residual {
+	let y = 3
+	ReLU
+}
+Bad=1 {
+	Nested(a=) {
+	}
+	Conv(w=3, h=3, n=8)
+}
+}
+FC(out=10)
+Repeat(n=2) {`
+	root, err := ParseAll(code)
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList but got %T", err)
+	}
+	expected := ErrorList{
+		{Message: "bad value for attribute n: undefined name: x", Line: 1,
+			Span: &Span{Start: Pos{Line: 1, Col: 15}, End: Pos{Line: 1, Col: 18}}},
+		{Message: "let statements must be at the top level", Line: 3},
+		{Message: "invalid block declaration", Line: 6,
+			Span: &Span{Start: Pos{Line: 6, Col: 0}, End: Pos{Line: 6, Col: 7}}},
+		{Message: "unexpected }", Line: 11},
+		{Message: "no matching }", Line: 13},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v but got %v", expected, errs)
+	}
+	if expectedMsg := "line 2: bad value for attribute n: undefined name: x " +
+		"(and 4 more errors)"; err.Error() != expectedMsg {
+		t.Errorf("unexpected message: %s", err)
+	}
+	var names []string
+	for _, ch := range root.Children {
+		names = append(names, ch.BlockName)
+	}
+	if expectedNames := []string{"Input", "Residual", "FC", "Repeat"}; !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("expected children %v but got %v", expectedNames, names)
+	}
+
+	if _, err := Parse(code); !reflect.DeepEqual(err, expected[0]) {
+		t.Errorf("expected Parse to return %v but got %v", expected[0], err)
+	}
+	if root, err := ParseAll("Input(w=1, h=1, d=1)"); err != nil || root == nil {
+		t.Errorf("unexpected result: %v, %v", root, err)
+	}
+}
+
+func TestBlockAll(t *testing.T) {
+	code := `Define Unit(n) {
+	Conv(w=3, h=3, n=n)
+}
+Input(w=4, h=4, d=4)
+Concat {
+	Branch {
+		Residual {
+			Projection {
+				FC(out=0)
+			}
+			Unit(n=4)
+			Padding(t=1, b=1, l=1, r=1)
+			Unit(n=-1)
+		}
+	}
+	Branch {
+		Assert(w=3, h=4, d=4)
+	}
+	Branch {
+		Conv(w=1, h=1, n=2, groups=3)
+	}
+	Branch {
+		Conv(w=3, h=3)
+		Softmax
+	}
+}
+Unknown
+`
+	root, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.BlockAll(Dims{}, DefaultCreators())
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList but got %T", err)
+	}
+	var messages []string
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	expected := []string{
+		"line 9: attribute out cannot be 0",
+		"line 13: in Unit: line 2: attribute n cannot be -1",
+		"line 17: expected dimensions 3x4x4 but got 4x4x4",
+		"line 20: input depth 4 is not divisible by 3 groups",
+		"line 23: missing attribute: n",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %#v but got %#v", expected, messages)
+	}
+
+	_, err = root.Block(Dims{}, DefaultCreators())
+	if !reflect.DeepEqual(err, errs[0]) {
+		t.Errorf("expected Block to return %v but got %v", errs[0], err)
+	}
+}
+
+func TestBlockError(t *testing.T) {
+	code := `Define Unit(n) {
+	Residual {
+		Conv(w=1, h=1, n=n)
+	}
+}
+Input(w=4, h=4, d=4)
+Repeat(n=2) {
+	Unit(n=3)
+}`
+	root, err := Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.Block(Dims{}, DefaultCreators())
+	expectedMsg := "line 8: in Unit: line 2: residual output size mismatch"
+	if err == nil || err.Error() != expectedMsg {
+		t.Fatalf("expected %q but got %v", expectedMsg, err)
+	}
+	var blockErr *BlockError
+	if !errors.As(err, &blockErr) {
+		t.Fatalf("expected BlockError but got %T", err)
+	}
+	if blockErr.Error() != expectedMsg {
+		t.Errorf("unexpected message: %s", blockErr)
+	}
+	repeat := root.Children[1]
+	call := repeat.Children[0]
+	residual := root.Macros[0].Body[0]
+	if blockErr.Node != residual || blockErr.Name != "Residual" {
+		t.Errorf("unexpected node: %#v", blockErr.Node)
+	}
+	if blockErr.In != (Dims{Width: 4, Height: 4, Depth: 4}) {
+		t.Errorf("unexpected input: %v", blockErr.In)
+	}
+	expectedPath := []*ASTNode{root, repeat, call, residual}
+	if !reflect.DeepEqual(blockErr.Path, expectedPath) {
+		t.Errorf("unexpected path: %v", blockErr.Path)
+	}
+	if !reflect.DeepEqual(blockErr.Macros, []*ASTNode{call}) {
+		t.Errorf("unexpected macros: %v", blockErr.Macros)
+	}
+
+	code = `Input(w=4, h=4, d=4)
+Concat {
+	Branch {
+		ReLU {
+			ReLU
+		}
+	}
+	Branch {
+		Residual {
+		}
+	}
+}`
+	root, err = Parse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = root.BlockAll(Dims{}, DefaultCreators())
+	if !errors.Is(err, ErrUnexpectedChildren) || !errors.Is(err, ErrNotEnoughChildren) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	relu := root.Children[1].Children[0].Children[0]
+	if !errors.As(err, &blockErr) || blockErr.Node != relu ||
+		len(blockErr.Path) != 4 || len(blockErr.Macros) != 0 {
+		t.Errorf("unexpected BlockError: %#v", blockErr)
+	}
+
+	_, err = (&ASTNode{}).Block(Dims{}, DefaultCreators())
+	if !errors.Is(err, ErrNotEnoughChildren) {
+		t.Errorf("expected ErrNotEnoughChildren but got %v", err)
+	}
+}
+
+func withoutCause(err error) error {
+	if pe, ok := err.(*ParseError); ok {
+		res := *pe
+		res.Err = nil
+		return &res
+	}
+	return err
+}
+
+func clearSpans(node *ASTNode) {
+	node.NameSpan = Span{}
+	node.AttrSpans = nil
+	for _, ch := range node.Children {
+		clearSpans(ch)
+	}
+}
+
+type valueBlock struct {
+	Out  Dims
+	Tags interface{}
+}
+
+func (v valueBlock) Type() string {
+	return "Value"
+}
+
+func (v valueBlock) OutDims() Dims {
+	return v.Out
+}
+
+func TestBlockSourcesValueBlocks(t *testing.T) {
+	root, err := Parse("Input(w=2, h=2, d=1)\nValue\nValue\nValue(tags=1)\nReLU")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creators := DefaultCreators()
+	creators["Value"] = func(in Dims, attr map[string]float64, c []Block) (Block, error) {
+		// Equal values would share a map key, and a slice
+		// makes the value impossible to use as a key.
+		res := valueBlock{Out: in}
+		if attr["tags"] != 0 {
+			res.Tags = []string{"x"}
+		}
+		return res, nil
+	}
+	block, src, err := root.BlockSources(Dims{}, creators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	children := block.(*Root).Children
+	for i := 1; i < 4; i++ {
+		if line := src.Line(children[i]); line != -1 {
+			t.Errorf("child %d: expected unknown line but got %d", i, line)
+		}
+	}
+	if line := src.Line(children[4]); line != 4 {
+		t.Errorf("expected line 4 but got %d", line)
+	}
+	if s := Summarize(block, Dims{}, src); len(s.Rows) != 5 {
+		t.Errorf("unexpected summary:\n%s", s)
+	}
+}
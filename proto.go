@@ -0,0 +1,141 @@
+package convmarkup
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protocol buffer wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// protoWriter encodes protocol buffer messages.
+type protoWriter struct {
+	buf []byte
+}
+
+func (p *protoWriter) tag(field, wireType int) {
+	p.buf = binary.AppendUvarint(p.buf, uint64(field<<3|wireType))
+}
+
+func (p *protoWriter) varint(field int, v uint64) {
+	p.tag(field, wireVarint)
+	p.buf = binary.AppendUvarint(p.buf, v)
+}
+
+func (p *protoWriter) int64(field int, v int64) {
+	p.varint(field, uint64(v))
+}
+
+func (p *protoWriter) float32(field int, v float32) {
+	p.tag(field, wireFixed32)
+	p.buf = binary.LittleEndian.AppendUint32(p.buf, math.Float32bits(v))
+}
+
+func (p *protoWriter) bytes(field int, b []byte) {
+	p.tag(field, wireBytes)
+	p.buf = binary.AppendUvarint(p.buf, uint64(len(b)))
+	p.buf = append(p.buf, b...)
+}
+
+func (p *protoWriter) string(field int, s string) {
+	p.bytes(field, []byte(s))
+}
+
+// message encodes an embedded message, which is produced
+// by calling f with a new protoWriter.
+func (p *protoWriter) message(field int, f func(w *protoWriter)) {
+	var sub protoWriter
+	f(&sub)
+	p.bytes(field, sub.buf)
+}
+
+// packedInt64s encodes a packed repeated int64 field.
+func (p *protoWriter) packedInt64s(field int, vals []int64) {
+	var data []byte
+	for _, v := range vals {
+		data = binary.AppendUvarint(data, uint64(v))
+	}
+	p.bytes(field, data)
+}
+
+// A protoField is a field of a decoded protocol buffer
+// message.
+//
+// Varint and fixed-width values are stored in Int, while
+// length-delimited values are stored in Bytes.
+type protoField struct {
+	Num   int
+	Wire  int
+	Int   uint64
+	Bytes []byte
+}
+
+// decodeProto splits a protocol buffer message into its
+// fields.
+func decodeProto(data []byte) ([]protoField, error) {
+	var res []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("protobuf: bad field key")
+		}
+		data = data[n:]
+		field := protoField{Num: int(key >> 3), Wire: int(key & 7)}
+		switch field.Wire {
+		case wireVarint:
+			field.Int, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("protobuf: bad varint")
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errors.New("protobuf: unexpected end of data")
+			}
+			field.Int = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errors.New("protobuf: unexpected end of data")
+			}
+			field.Int = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, errors.New("protobuf: bad length")
+			}
+			field.Bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return nil, errors.New("protobuf: unsupported wire type")
+		}
+		res = append(res, field)
+	}
+	return res, nil
+}
+
+// decodeInt64s decodes a repeated int64 field, which may
+// be packed or unpacked.
+func decodeInt64s(f protoField) ([]int64, error) {
+	if f.Wire == wireVarint {
+		return []int64{int64(f.Int)}, nil
+	}
+	var res []int64
+	data := f.Bytes
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("protobuf: bad varint")
+		}
+		res = append(res, int64(v))
+		data = data[n:]
+	}
+	return res, nil
+}
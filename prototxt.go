@@ -0,0 +1,253 @@
+package convmarkup
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A ptxtMessage is a message in the protocol buffer text
+// format, as used by Caffe prototxt files.
+type ptxtMessage struct {
+	Fields []*ptxtField
+}
+
+// A ptxtField is a field of a ptxtMessage.
+// Exactly one of Value and Message is set.
+type ptxtField struct {
+	Name string
+
+	// Value is the scalar value, with quotes and escape
+	// sequences removed from strings.
+	Value string
+
+	Message *ptxtMessage
+}
+
+// get finds the last scalar value of a field, or returns
+// def if the field is not present.
+func (p *ptxtMessage) get(name, def string) string {
+	vals := p.getAll(name)
+	if len(vals) == 0 {
+		return def
+	}
+	return vals[len(vals)-1]
+}
+
+// getAll finds every scalar value of a repeated field.
+func (p *ptxtMessage) getAll(name string) []string {
+	var res []string
+	for _, f := range p.Fields {
+		if f.Name == name && f.Message == nil {
+			res = append(res, f.Value)
+		}
+	}
+	return res
+}
+
+// message finds the last sub-message with a field name, or
+// returns an empty message if there is none.
+func (p *ptxtMessage) message(name string) *ptxtMessage {
+	msgs := p.messages(name)
+	if len(msgs) == 0 {
+		return &ptxtMessage{}
+	}
+	return msgs[len(msgs)-1]
+}
+
+// messages finds every sub-message with a field name.
+func (p *ptxtMessage) messages(name string) []*ptxtMessage {
+	var res []*ptxtMessage
+	for _, f := range p.Fields {
+		if f.Name == name && f.Message != nil {
+			res = append(res, f.Message)
+		}
+	}
+	return res
+}
+
+// has checks if a field is present.
+func (p *ptxtMessage) has(name string) bool {
+	for _, f := range p.Fields {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// set adds a scalar field.
+func (p *ptxtMessage) set(name, value string) {
+	p.Fields = append(p.Fields, &ptxtField{Name: name, Value: value})
+}
+
+// setString adds a quoted string field.
+func (p *ptxtMessage) setString(name, value string) {
+	p.set(name, strconv.Quote(value))
+}
+
+// setInt adds an integer field.
+func (p *ptxtMessage) setInt(name string, value int) {
+	p.set(name, strconv.Itoa(value))
+}
+
+// add adds a sub-message.
+func (p *ptxtMessage) add(name string) *ptxtMessage {
+	res := &ptxtMessage{}
+	p.Fields = append(p.Fields, &ptxtField{Name: name, Message: res})
+	return res
+}
+
+// format encodes the message in text format.
+// Scalar values are written as they are stored, so string
+// values must already be quoted.
+func (p *ptxtMessage) format(indent int) string {
+	var res strings.Builder
+	prefix := strings.Repeat("  ", indent)
+	for _, f := range p.Fields {
+		if f.Message != nil {
+			res.WriteString(prefix + f.Name + " {\n")
+			res.WriteString(f.Message.format(indent + 1))
+			res.WriteString(prefix + "}\n")
+		} else {
+			res.WriteString(prefix + f.Name + ": " + f.Value + "\n")
+		}
+	}
+	return res.String()
+}
+
+// parsePtxt parses a message in text format.
+func parsePtxt(data string) (*ptxtMessage, error) {
+	p := &ptxtParser{data: data}
+	res, err := p.message(false)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line+1, err)
+	}
+	return res, nil
+}
+
+type ptxtParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *ptxtParser) message(nested bool) (*ptxtMessage, error) {
+	res := &ptxtMessage{}
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok == "" {
+			if nested {
+				return nil, errors.New("missing }")
+			}
+			return res, nil
+		} else if tok == "}" {
+			if !nested {
+				return nil, errors.New("unexpected }")
+			}
+			return res, nil
+		} else if !isName(tok) {
+			return nil, fmt.Errorf("unexpected %q", tok)
+		}
+		field := &ptxtField{Name: tok}
+		res.Fields = append(res.Fields, field)
+
+		sep, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if sep == ":" {
+			if sep, err = p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if sep == "{" {
+			if field.Message, err = p.message(true); err != nil {
+				return nil, err
+			}
+		} else if sep == "" || sep == "}" || sep == ":" {
+			return nil, fmt.Errorf("missing value for %s", field.Name)
+		} else if sep[0] == '"' || sep[0] == '\'' {
+			if field.Value, err = ptxtUnquote(sep); err != nil {
+				return nil, fmt.Errorf("invalid string for %s", field.Name)
+			}
+		} else {
+			field.Value = sep
+		}
+	}
+}
+
+// next reads the next token, returning "" at the end of
+// the input.
+// Strings are returned with their quotes, and separators
+// between fields are skipped.
+func (p *ptxtParser) next() (string, error) {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '#' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		} else if c == '\n' {
+			p.line++
+			p.pos++
+		} else if c == ' ' || c == '\t' || c == '\r' || c == ',' || c == ';' {
+			p.pos++
+		} else {
+			break
+		}
+	}
+	if p.pos == len(p.data) {
+		return "", nil
+	}
+	start := p.pos
+	c := p.data[p.pos]
+	p.pos++
+	switch {
+	case c == '{' || c == '}' || c == ':':
+	case c == '"' || c == '\'':
+		for p.pos < len(p.data) && p.data[p.pos] != c {
+			if p.data[p.pos] == '\n' {
+				return "", errors.New("unterminated string")
+			} else if p.data[p.pos] == '\\' && p.pos+1 < len(p.data) {
+				p.pos++
+			}
+			p.pos++
+		}
+		if p.pos == len(p.data) {
+			return "", errors.New("unterminated string")
+		}
+		p.pos++
+	default:
+		for p.pos < len(p.data) && !strings.ContainsRune("{}:,;#\"' \t\r\n",
+			rune(p.data[p.pos])) {
+			p.pos++
+		}
+	}
+	return p.data[start:p.pos], nil
+}
+
+// ptxtUnquote removes the quotes from a string token and
+// replaces its escape sequences.
+func ptxtUnquote(tok string) (string, error) {
+	quote := tok[0]
+	body := tok[1 : len(tok)-1]
+	var res strings.Builder
+	for len(body) > 0 {
+		r, multibyte, tail, err := strconv.UnquoteChar(body, quote)
+		if err != nil {
+			return "", err
+		}
+		if multibyte {
+			res.WriteRune(r)
+		} else {
+			res.WriteByte(byte(r))
+		}
+		body = tail
+	}
+	return res.String(), nil
+}
@@ -0,0 +1,218 @@
+package convmarkup
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PyTorch generates the source code of a Python file which
+// defines an nn.Module subclass equivalent to r.
+//
+// If in is not zero, it specifies the input dimensions of
+// r, and r needn't start with an Input block.
+// The module takes NCHW inputs.
+//
+// Padding blocks, and Conv padding which is not the same
+// on both sides of an axis, become nn.ZeroPad2d layers.
+// FC becomes an nn.Linear which flattens its input in CHW
+// order, and its output is reshaped to Nx(out)x1x1 to
+// match the output dimensions of the block.
+// Residual and Concat are implemented in the forward
+// method.
+// The contents of each Repeat become a separate module
+// class, and copies of this module are applied in a loop.
+// Assert and Debug blocks are omitted.
+// Other blocks are not supported.
+func PyTorch(r *Root, in Dims, className string) (string, error) {
+	t := &pyTorchTarget{className: className, classNames: nameCounter{}}
+	t.gen = newCodeGen(t)
+	t.cur = newPyTorchClass(className)
+	if err := t.gen.generate(r, in, "x"); err != nil {
+		return "", fmt.Errorf("PyTorch export: %w", err)
+	}
+	t.finish(fmt.Sprintf(`"""Expects inputs of shape (N, %d, %d, %d)."""`, t.in.Depth,
+		t.in.Height, t.in.Width))
+
+	var res strings.Builder
+	res.WriteString("import torch\nimport torch.nn as nn\n")
+	for _, class := range t.classes {
+		res.WriteString("\n\n" + class)
+	}
+	return res.String(), nil
+}
+
+type pyTorchTarget struct {
+	gen        *codeGen
+	in         Dims
+	className  string
+	classNames nameCounter
+	cur        *pyTorchClass
+	classes    []string
+}
+
+// pyTorchClass is a module class under construction.
+type pyTorchClass struct {
+	name    string
+	modules nameCounter
+	init    codeWriter
+	forward codeWriter
+}
+
+func newPyTorchClass(name string) *pyTorchClass {
+	res := &pyTorchClass{name: name, modules: nameCounter{}}
+	res.init.indent = 2
+	res.forward.indent = 2
+	return res
+}
+
+// finish adds the current class to the output.
+func (p *pyTorchTarget) finish(docstring string) {
+	var w codeWriter
+	w.line("class %s(nn.Module):", p.cur.name)
+	w.indent++
+	if docstring != "" {
+		w.line("%s", docstring)
+		w.line("")
+	}
+	w.line("def __init__(self):")
+	w.indent++
+	w.line("super().__init__()")
+	w.buf.WriteString(p.cur.init.String())
+	w.indent--
+	w.line("")
+	w.line("def forward(self, x):")
+	w.indent++
+	w.buf.WriteString(p.cur.forward.String())
+	w.line("return x")
+	p.classes = append(p.classes, w.String())
+}
+
+// module adds a submodule to the current class, returning
+// the expression which refers to it.
+func (p *pyTorchTarget) module(prefix, format string, args ...interface{}) string {
+	name := p.cur.modules.next(prefix)
+	p.cur.init.line("self.%s = %s", name, fmt.Sprintf(format, args...))
+	return "self." + name
+}
+
+func (p *pyTorchTarget) line(format string, args ...interface{}) {
+	p.cur.forward.line(format, args...)
+}
+
+func (p *pyTorchTarget) input(d Dims, dst string) {
+	p.in = d
+}
+
+func (p *pyTorchTarget) assign(dst, src string) {
+	p.line("%s = %s", dst, src)
+}
+
+func (p *pyTorchTarget) add(dst, x, y string) {
+	p.line("%s = %s + %s", dst, x, y)
+}
+
+func (p *pyTorchTarget) concat(dst string, srcs []string) {
+	p.line("%s = torch.cat([%s], dim=1)", dst, strings.Join(srcs, ", "))
+}
+
+func (p *pyTorchTarget) repeat(n int, x string, body func(x string) error) error {
+	parent := p.cur
+	p.cur = newPyTorchClass(p.classNames.next(p.className + "Block"))
+	if err := body("x"); err != nil {
+		return err
+	}
+	p.finish("")
+	name := p.cur.name
+	p.cur = parent
+
+	list := p.module("repeat", "nn.ModuleList([%s() for _ in range(%d)])", name, n)
+	p.line("for block in %s:", list)
+	p.cur.forward.indent++
+	p.line("%s = block(%s)", x, x)
+	p.cur.forward.indent--
+	return nil
+}
+
+func (p *pyTorchTarget) layer(b Block, in Dims, src, dst string) error {
+	var module string
+	switch b := b.(type) {
+	case *Conv:
+		if b.PadTop != b.PadBottom || b.PadLeft != b.PadRight {
+			pad := p.module("pad", "nn.ZeroPad2d(%s)",
+				pyTuple(b.PadLeft, b.PadRight, b.PadTop, b.PadBottom))
+			p.line("%s = %s(%s)", dst, pad, src)
+			src = dst
+		}
+		args := fmt.Sprintf("%d, %d, kernel_size=%s", in.Depth, b.FilterCount,
+			pyTuple(b.FilterHeight, b.FilterWidth))
+		if b.StrideX != 1 || b.StrideY != 1 {
+			args += ", stride=" + pyTuple(b.StrideY, b.StrideX)
+		}
+		if b.PadTop == b.PadBottom && b.PadLeft == b.PadRight &&
+			(b.PadTop != 0 || b.PadLeft != 0) {
+			args += ", padding=" + pyTuple(b.PadTop, b.PadLeft)
+		}
+		if b.DilationX != 1 || b.DilationY != 1 {
+			args += ", dilation=" + pyTuple(b.DilationY, b.DilationX)
+		}
+		if b.Groups != 1 {
+			args += fmt.Sprintf(", groups=%d", b.Groups)
+		}
+		module = p.module("conv", "nn.Conv2d(%s)", args)
+	case *Deconv:
+		args := fmt.Sprintf("%d, %d, kernel_size=%s", in.Depth, b.FilterCount,
+			pyTuple(b.FilterHeight, b.FilterWidth))
+		if b.StrideX != 1 || b.StrideY != 1 {
+			args += ", stride=" + pyTuple(b.StrideY, b.StrideX)
+		}
+		if b.OutPadX != 0 || b.OutPadY != 0 {
+			args += ", output_padding=" + pyTuple(b.OutPadY, b.OutPadX)
+		}
+		module = p.module("deconv", "nn.ConvTranspose2d(%s)", args)
+	case *Pool:
+		op := "MaxPool2d"
+		if b.Name == "MeanPool" {
+			op = "AvgPool2d"
+		}
+		args := "kernel_size=" + pyTuple(b.Height, b.Width)
+		if b.StrideX != b.Width || b.StrideY != b.Height {
+			args += ", stride=" + pyTuple(b.StrideY, b.StrideX)
+		}
+		module = p.module("pool", "nn.%s(%s)", op, args)
+	case *Padding:
+		module = p.module("pad", "nn.ZeroPad2d(%s)",
+			pyTuple(b.Left, b.Right, b.Top, b.Bottom))
+	case *Resize:
+		module = p.module("resize",
+			`nn.Upsample(size=%s, mode="bilinear", align_corners=False)`,
+			pyTuple(b.Out.Height, b.Out.Width))
+	case *FC:
+		fc := p.module("fc", "nn.Linear(%d, %d)", in.Volume(), b.OutCount)
+		p.line("%s = %s(torch.flatten(%s, 1))[:, :, None, None]", dst, fc, src)
+		return nil
+	case *Linear:
+		p.line("%s = %s", dst, pyAffine(src, b.Scale, b.Bias))
+		return nil
+	case *Dropout:
+		module = p.module("dropout", "nn.Dropout(p=%s)", formatNumber(b.Prob))
+	case *Activation:
+		switch b.Name {
+		case "BatchNorm":
+			module = p.module("bn", "nn.BatchNorm2d(%d)", in.Depth)
+		case "ReLU":
+			module = p.module("relu", "nn.ReLU()")
+		case "Sigmoid":
+			module = p.module("sigmoid", "nn.Sigmoid()")
+		case "Tanh":
+			module = p.module("tanh", "nn.Tanh()")
+		case "Softmax":
+			module = p.module("softmax", "nn.Softmax(dim=1)")
+		default:
+			return fmt.Errorf("unsupported activation: %s", b.Name)
+		}
+	default:
+		return fmt.Errorf("unsupported block: %s", b.Type())
+	}
+	p.line("%s = %s(%s)", dst, module, src)
+	return nil
+}
@@ -0,0 +1,21 @@
+package convmarkup
+
+import "testing"
+
+func TestPyTorch(t *testing.T) {
+	testGolden(t, "pytorch", ".py", func(r *Root) (string, error) {
+		return PyTorch(r, Dims{}, "Model")
+	})
+}
+
+func TestPyTorchErrors(t *testing.T) {
+	root := &Root{Children: []Block{&Activation{Name: "ReLU", Out: Dims{1, 1, 1}}}}
+	_, err := PyTorch(root, Dims{}, "Model")
+	if err == nil || err.Error() != "PyTorch export: missing Input block before ReLU" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	root.Children = append(root.Children, &Debug{In: Dims{1, 1, 1}})
+	if _, err := PyTorch(root, Dims{1, 1, 1}, "Model"); err != nil {
+		t.Error(err)
+	}
+}
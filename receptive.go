@@ -0,0 +1,200 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"text/tabwriter"
+)
+
+// A ReceptiveField describes the region of a network's
+// input that can affect a single unit of a tensor.
+//
+// Coordinates are measured in input pixels, where the
+// first input pixel is centered at 0.
+type ReceptiveField struct {
+	// Width and Height give the size of the region.
+	Width  float64
+	Height float64
+
+	// JumpX and JumpY give the distance between adjacent
+	// units, also known as the effective stride.
+	JumpX float64
+	JumpY float64
+
+	// CenterX and CenterY give the center of the region for
+	// the top-left unit.
+	CenterX float64
+	CenterY float64
+}
+
+// InputField is the receptive field of the input itself.
+var InputField = ReceptiveField{Width: 1, Height: 1, JumpX: 1, JumpY: 1}
+
+// window computes the field of a sliding-window operation
+// with the given kernel size, stride, and top/left
+// padding.
+func (r ReceptiveField) window(kx, ky, sx, sy, px, py int) ReceptiveField {
+	return ReceptiveField{
+		Width:   r.Width + float64(kx-1)*r.JumpX,
+		Height:  r.Height + float64(ky-1)*r.JumpY,
+		JumpX:   r.JumpX * float64(sx),
+		JumpY:   r.JumpY * float64(sy),
+		CenterX: r.CenterX + (float64(kx-1)/2-float64(px))*r.JumpX,
+		CenterY: r.CenterY + (float64(ky-1)/2-float64(py))*r.JumpY,
+	}
+}
+
+// BlockField computes the receptive field of a block's
+// output, given the receptive field and dimensions of its
+// input.
+//
+// For blocks with sub-blocks, the sub-blocks are taken
+// into account, with Repeat blocks being unrolled.
+// The field of a Residual or Concat is that of whichever
+// branch has the largest field.
+func BlockField(b Block, in Dims, f ReceptiveField) ReceptiveField {
+	return blockField(b, in, f, func(Block, int, ReceptiveField) {}, 0)
+}
+
+type fieldFunc func(b Block, depth int, f ReceptiveField)
+
+func blockField(b Block, in Dims, f ReceptiveField, cb fieldFunc,
+	depth int) ReceptiveField {
+	switch b := b.(type) {
+	case *Root:
+		f = seqField(b.Children, in, f, cb, depth+1)
+	case *Projection:
+		f = seqField(b.Children, in, f, cb, depth+1)
+	case *Repeat:
+		for i := 0; i < b.N; i++ {
+			f = seqField(b.Children, in, f, cb, depth+1)
+		}
+	case *Residual:
+		skip := f
+		if b.Projection != nil {
+			skip = seqField(b.Projection, in, f, cb, depth+1)
+		}
+		f = seqField(b.Residual, in, f, cb, depth+1)
+		if skip.Width*skip.Height > f.Width*f.Height {
+			f = skip
+		}
+	case *Branch:
+		f = seqField(b.Children, in, f, cb, depth+1)
+	case *Concat:
+		branchIn := f
+		for i, branch := range b.Branches {
+			bf := seqField(branch, in, branchIn, cb, depth+1)
+			if i == 0 || bf.Width*bf.Height > f.Width*f.Height {
+				f = bf
+			}
+		}
+	case *Input:
+		f = InputField
+	case *Conv:
+		spanX, spanY := b.FilterSpan()
+		f = f.window(spanX, spanY, b.StrideX, b.StrideY, b.PadLeft, b.PadTop)
+	case *Deconv:
+		// Every output unit depends on the input units whose
+		// filters overlap it, which are spaced 1/stride apart
+		// in output coordinates.
+		sx, sy := float64(b.StrideX), float64(b.StrideY)
+		kx, ky := float64(b.FilterWidth-1), float64(b.FilterHeight-1)
+		f = ReceptiveField{
+			Width:   f.Width + kx/sx*f.JumpX,
+			Height:  f.Height + ky/sy*f.JumpY,
+			JumpX:   f.JumpX / sx,
+			JumpY:   f.JumpY / sy,
+			CenterX: f.CenterX - kx/(2*sx)*f.JumpX,
+			CenterY: f.CenterY - ky/(2*sy)*f.JumpY,
+		}
+	case *Pool:
+		f = f.window(b.Width, b.Height, b.StrideX, b.StrideY, 0, 0)
+	case *Padding:
+		f = f.window(1, 1, 1, 1, b.Left, b.Top)
+	case *FC:
+		f = f.window(in.Width, in.Height, 1, 1, 0, 0)
+	case *Resize:
+		// Bilinear interpolation, where every output unit
+		// depends on up to two input units per axis.
+		scaleX := float64(in.Width) / float64(b.Out.Width)
+		scaleY := float64(in.Height) / float64(b.Out.Height)
+		f = ReceptiveField{
+			Width:   f.Width + f.JumpX,
+			Height:  f.Height + f.JumpY,
+			JumpX:   f.JumpX * scaleX,
+			JumpY:   f.JumpY * scaleY,
+			CenterX: f.CenterX + f.JumpX*(scaleX-1)/2,
+			CenterY: f.CenterY + f.JumpY*(scaleY-1)/2,
+		}
+	}
+	cb(b, depth, f)
+	return f
+}
+
+func seqField(blocks []Block, in Dims, f ReceptiveField, cb fieldFunc,
+	depth int) ReceptiveField {
+	for _, b := range blocks {
+		f = blockField(b, in, f, cb, depth)
+		in = b.OutDims()
+	}
+	return f
+}
+
+// A FieldRow describes the receptive field after a block.
+type FieldRow struct {
+	// Line is the line number (starting at 0) of the block,
+	// or -1 if it is unknown.
+	Line int
+
+	Depth int
+	Type  string
+	Field ReceptiveField
+}
+
+// A FieldReport lists the receptive field after every
+// block in a tree.
+type FieldReport struct {
+	// Rows contains a row for every block, in the order
+	// that the blocks are applied.
+	// Rows for a block's children come before the row for
+	// the block itself, and the contents of Repeat blocks
+	// appear once per copy.
+	Rows []FieldRow
+}
+
+// ReceptiveFields produces a FieldReport for a block and
+// all of its sub-blocks.
+//
+// The in argument specifies the block's input dimensions,
+// and f specifies the receptive field of the input.
+// For a Root, Dims{} and InputField suffice.
+//
+// The SourceMap is used to look up line numbers.
+// It may be nil.
+func ReceptiveFields(b Block, in Dims, f ReceptiveField,
+	src SourceMap) *FieldReport {
+	res := &FieldReport{}
+	blockField(b, in, f, func(b Block, depth int, f ReceptiveField) {
+		res.Rows = append(res.Rows, FieldRow{
+			Line:  src.Line(b),
+			Depth: depth,
+			Type:  b.Type(),
+			Field: f,
+		})
+	}, 0)
+	return res
+}
+
+// String renders the report as a table.
+func (f *FieldReport) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tBLOCK\tSIZE\tJUMP\tCENTER")
+	for _, row := range f.Rows {
+		r := row.Field
+		fmt.Fprintf(w, "%s\t%s\t%gx%g\t%gx%g\t%g,%g\n", formatLine(row.Line),
+			row.Type, r.Width, r.Height, r.JumpX, r.JumpY, r.CenterX, r.CenterY)
+	}
+	w.Flush()
+	return buf.String()
+}
@@ -0,0 +1,52 @@
+package convmarkup
+
+import "testing"
+
+func TestReceptiveFields(t *testing.T) {
+	markup := `Input(w=16, h=16, d=1)
+Padding(t=1, r=1, b=1, l=1)
+Conv(w=3, h=3, n=2)
+MaxPool(w=2, h=2)
+Repeat(n=2) {
+	Residual {
+		Padding(t=0, r=1, b=0, l=1)
+		Conv(w=3, h=1, n=2)
+		Padding(t=0, r=0, b=0, l=0)
+	}
+}
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := ReceptiveFields(block, Dims{}, InputField, src)
+
+	rows := report.Rows
+	if len(rows) != 14 {
+		t.Fatalf("expected 14 rows but got %d", len(rows))
+	}
+	expected := map[int]ReceptiveField{
+		0:  InputField,
+		1:  {Width: 1, Height: 1, JumpX: 1, JumpY: 1, CenterX: -1, CenterY: -1},
+		2:  {Width: 3, Height: 3, JumpX: 1, JumpY: 1},
+		3:  {Width: 4, Height: 4, JumpX: 2, JumpY: 2, CenterX: 0.5, CenterY: 0.5},
+		7:  {Width: 8, Height: 4, JumpX: 2, JumpY: 2, CenterX: 0.5, CenterY: 0.5},
+		11: {Width: 12, Height: 4, JumpX: 2, JumpY: 2, CenterX: 0.5, CenterY: 0.5},
+		13: {Width: 12, Height: 4, JumpX: 2, JumpY: 2, CenterX: 0.5, CenterY: 0.5},
+	}
+	for i, f := range expected {
+		if rows[i].Field != f {
+			t.Errorf("row %d: expected %v but got %v", i, f, rows[i].Field)
+		}
+	}
+	if rows[7].Type != "Residual" || rows[7].Line != 5 || rows[7].Depth != 2 {
+		t.Errorf("unexpected row: %v", rows[7])
+	}
+	if rows[13].Type != "" || rows[12].Type != "Repeat" {
+		t.Errorf("unexpected container rows: %v, %v", rows[12], rows[13])
+	}
+}
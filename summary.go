@@ -0,0 +1,191 @@
+package convmarkup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+)
+
+// A SummaryRow describes one block in a Summary.
+type SummaryRow struct {
+	// Block is the block described by the row.
+	// For the projection of a Residual, it is a new
+	// *Projection containing the projection's blocks.
+	Block Block
+
+	// Line is the line number (starting at 0) of the block,
+	// or -1 if it is unknown.
+	Line int
+
+	// Depth is the nesting depth of the block, where the
+	// top-level blocks have depth 0.
+	Depth int
+
+	Type string
+
+	// Attrs contains the block's attributes, formatted as
+	// they would be in markup.
+	// It is empty if the source of the block is unknown.
+	Attrs string
+
+	In  Dims
+	Out Dims
+
+	// Params is the number of trainable parameters in one
+	// copy of the block, not including its sub-blocks.
+	Params int
+
+	// Count is the number of copies of the block, which is
+	// greater than 1 inside of Repeat blocks.
+	Count int
+}
+
+// A Summary lists the blocks of a block tree along with
+// their dimensions, similar to model.summary() in Keras.
+type Summary struct {
+	Rows []SummaryRow
+
+	// Total is the total number of trainable parameters,
+	// including the parameters of every copy of repeated
+	// blocks.
+	Total int
+}
+
+// Summarize produces a Summary with a row for every block
+// in a block tree, in order of appearance.
+// The contents of Residual, Repeat, and other container
+// blocks follow the row for the container.
+// The projection of a Residual gets a Projection row of
+// its own, followed by the blocks of the projection.
+//
+// If b is a Root, the Root itself does not get a row, and
+// its children are the top-level blocks.
+//
+// The in argument specifies the block's input dimensions.
+// For a Root, Dims{} suffices.
+//
+// The SourceMap is used to look up line numbers and
+// attributes.
+// It may be nil.
+func Summarize(b Block, in Dims, src SourceMap) *Summary {
+	s := &summarizer{src: src, res: &Summary{}}
+	if r, ok := b.(*Root); ok {
+		s.seq(r.Children, in, 0, 1)
+	} else {
+		s.block(b, in, 0, 1)
+	}
+	return s.res
+}
+
+type summarizer struct {
+	src SourceMap
+	res *Summary
+}
+
+func (s *summarizer) seq(blocks []Block, in Dims, depth, count int) {
+	for _, b := range blocks {
+		s.block(b, in, depth, count)
+		in = b.OutDims()
+	}
+}
+
+func (s *summarizer) block(b Block, in Dims, depth, count int) {
+	row := SummaryRow{
+		Block: b,
+		Line:  s.src.Line(b),
+		Depth: depth,
+		Type:  b.Type(),
+		In:    in,
+		Out:   b.OutDims(),
+		Count: count,
+	}
+	if node := s.src.Node(b); node != nil {
+		row.Attrs = formatAttrs(node.BlockName, node.Attrs)
+	}
+	for _, p := range Params(b, in) {
+		if !p.NonTrainable {
+			row.Params += p.Size()
+		}
+	}
+	s.res.Rows = append(s.res.Rows, row)
+	s.res.Total += row.Params * count
+
+	switch b := b.(type) {
+	case *Residual:
+		if b.Projection != nil {
+			s.projection(b, in, depth+1, count)
+		}
+		s.seq(b.Residual, in, depth+1, count)
+	case *Repeat:
+		s.seq(b.Children, in, depth+1, count*b.N)
+	default:
+		for _, seq := range SubBlocks(b) {
+			s.seq(seq, in, depth+1, count)
+		}
+	}
+}
+
+// projection adds a row for the projection of a Residual,
+// followed by the rows of its contents.
+func (s *summarizer) projection(r *Residual, in Dims, depth, count int) {
+	line := -1
+	if node := s.src.Node(r); node != nil && len(node.Children) > 0 &&
+		node.Children[0].BlockName == "Projection" {
+		line = node.Children[0].Line
+	}
+	s.res.Rows = append(s.res.Rows, SummaryRow{
+		Block: &Projection{Children: r.Projection, In: in},
+		Line:  line,
+		Depth: depth,
+		Type:  "Projection",
+		In:    in,
+		Out:   r.Projection[len(r.Projection)-1].OutDims(),
+		Count: count,
+	})
+	s.seq(r.Projection, in, depth+1, count)
+}
+
+// String renders the summary as a plain text table.
+// Nested blocks are indented, and Repeat blocks show their
+// number of copies.
+func (s *Summary) String() string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LINE\tBLOCK\tATTRS\tIN\tOUT\tPARAMS")
+	for _, row := range s.Rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", formatLine(row.Line),
+			strings.Repeat("  ", row.Depth)+row.name(), row.Attrs, formatDims(row.In),
+			formatDims(row.Out), row.Params)
+	}
+	fmt.Fprintf(w, "\tTotal\t\t\t\t%d\n", s.Total)
+	w.Flush()
+	return buf.String()
+}
+
+// Markdown renders the summary as a Markdown table.
+// Nested blocks are indented with non-breaking spaces.
+func (s *Summary) Markdown() string {
+	var buf bytes.Buffer
+	buf.WriteString("| Line | Block | Attributes | Input | Output | Params |\n")
+	buf.WriteString("| ---: | :--- | :--- | :--- | :--- | ---: |\n")
+	for _, row := range s.Rows {
+		attrs := ""
+		if row.Attrs != "" {
+			attrs = "`" + row.Attrs + "`"
+		}
+		fmt.Fprintf(&buf, "| %s | %s%s | %s | %s | %s | %d |\n", formatLine(row.Line),
+			strings.Repeat("&nbsp;&nbsp;", row.Depth), row.name(), attrs,
+			formatDims(row.In), formatDims(row.Out), row.Params)
+	}
+	fmt.Fprintf(&buf, "| | **Total** | | | | **%d** |\n", s.Total)
+	return buf.String()
+}
+
+// name gets the displayed name of the row's block.
+func (row SummaryRow) name() string {
+	if r, ok := row.Block.(*Repeat); ok {
+		return fmt.Sprintf("%s x%d", row.Type, r.N)
+	}
+	return row.Type
+}
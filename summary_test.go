@@ -0,0 +1,58 @@
+package convmarkup
+
+import "testing"
+
+func TestSummarize(t *testing.T) {
+	markup := `Input(w=4, h=4, d=2)
+Residual {
+	Projection {
+		Conv(w=1, h=1, n=3)
+	}
+	Repeat(n=2) {
+		BatchNorm
+	}
+	Conv(w=3, h=3, n=3, same=1)
+}
+`
+	parsed, err := Parse(markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, src, err := parsed.BlockSources(Dims{}, DefaultCreators())
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := Summarize(block, Dims{}, src)
+
+	expectedText := `LINE  BLOCK          ATTRS                  IN     OUT    PARAMS
+1     Input          w=4, h=4, d=2          0x0x0  4x4x2  0
+2     Residual                              4x4x2  4x4x3  0
+3       Projection                          4x4x2  4x4x3  0
+4         Conv       w=1, h=1, n=3          4x4x2  4x4x3  9
+6       Repeat x2    n=2                    4x4x2  4x4x2  0
+7         BatchNorm                         4x4x2  4x4x2  4
+9       Conv         w=3, h=3, n=3, same=1  4x4x2  4x4x3  57
+      Total                                               74
+`
+	if actual := summary.String(); actual != expectedText {
+		t.Errorf("expected text:\n%s\ngot:\n%s", expectedText, actual)
+	}
+
+	expectedMarkdown := "| Line | Block | Attributes | Input | Output | Params |\n" +
+		"| ---: | :--- | :--- | :--- | :--- | ---: |\n" +
+		"| 1 | Input | `w=4, h=4, d=2` | 0x0x0 | 4x4x2 | 0 |\n" +
+		"| 2 | Residual |  | 4x4x2 | 4x4x3 | 0 |\n" +
+		"| 3 | &nbsp;&nbsp;Projection |  | 4x4x2 | 4x4x3 | 0 |\n" +
+		"| 4 | &nbsp;&nbsp;&nbsp;&nbsp;Conv | `w=1, h=1, n=3` | 4x4x2 | 4x4x3 | 9 |\n" +
+		"| 6 | &nbsp;&nbsp;Repeat x2 | `n=2` | 4x4x2 | 4x4x2 | 0 |\n" +
+		"| 7 | &nbsp;&nbsp;&nbsp;&nbsp;BatchNorm |  | 4x4x2 | 4x4x2 | 4 |\n" +
+		"| 9 | &nbsp;&nbsp;Conv | `w=3, h=3, n=3, same=1` | 4x4x2 | 4x4x3 | 57 |\n" +
+		"| | **Total** | | | | **74** |\n"
+	if actual := summary.Markdown(); actual != expectedMarkdown {
+		t.Errorf("expected Markdown:\n%s\ngot:\n%s", expectedMarkdown, actual)
+	}
+
+	if summary.Rows[4].Count != 1 || summary.Rows[5].Count != 2 {
+		t.Errorf("unexpected counts: %d, %d", summary.Rows[4].Count, summary.Rows[5].Count)
+	}
+}
@@ -0,0 +1,31 @@
+package convmarkup
+
+// A Tensor is a 3D tensor of float32 values.
+//
+// Values are stored in row-major order with depth as the
+// innermost dimension, so the value at (x, y, z) is stored
+// at index (y*Width+x)*Depth+z.
+type Tensor struct {
+	Dims Dims
+	Data []float32
+}
+
+// NewTensor creates a zero tensor.
+func NewTensor(d Dims) *Tensor {
+	return &Tensor{Dims: d, Data: make([]float32, d.Volume())}
+}
+
+// Index returns the index of the value at (x, y, z).
+func (t *Tensor) Index(x, y, z int) int {
+	return (y*t.Dims.Width+x)*t.Dims.Depth + z
+}
+
+// At returns the value at (x, y, z).
+func (t *Tensor) At(x, y, z int) float32 {
+	return t.Data[t.Index(x, y, z)]
+}
+
+// Set sets the value at (x, y, z).
+func (t *Tensor) Set(x, y, z int, val float32) {
+	t.Data[t.Index(x, y, z)] = val
+}
@@ -0,0 +1,14 @@
+from tensorflow import keras
+from tensorflow.keras import layers
+
+
+def build_model():
+    """Builds a model for inputs of shape (N, 2, 2, 1)."""
+    inputs = keras.Input(shape=(2, 2, 1))
+    x = inputs
+    for _ in range(2):
+        pass
+    for _ in range(3):
+        pass
+    x = layers.ReLU()(x)
+    return keras.Model(inputs, x)
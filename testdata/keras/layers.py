@@ -0,0 +1,25 @@
+from tensorflow import keras
+from tensorflow.keras import layers
+
+
+def build_model():
+    """Builds a model for inputs of shape (N, 6, 8, 4)."""
+    inputs = keras.Input(shape=(6, 8, 4))
+    x = inputs
+    x = layers.ZeroPadding2D(padding=((1, 0), (0, 2)))(x)
+    x = layers.Conv2D(8, (1, 3), dilation_rate=(1, 2), groups=4)(x)
+    branch0 = layers.MaxPooling2D(pool_size=(2, 2), strides=(1, 1))(x)
+    branch0 = layers.ZeroPadding2D(padding=((0, 1), (0, 1)))(branch0)
+    branch1 = x
+    branch2 = layers.Lambda(lambda t: t * 0.5 - 1)(x)
+    branch2 = layers.Activation("sigmoid")(branch2)
+    x = layers.Concatenate()([branch0, branch1, branch2])
+    skip0 = x
+    x = layers.Activation("tanh")(x)
+    x = layers.Add()([x, skip0])
+    x = layers.Conv2DTranspose(3, (2, 2), strides=(2, 2), output_padding=(0, 1))(x)
+    x = layers.Resizing(4, 4, interpolation="bilinear")(x)
+    for _ in range(3):
+        for _ in range(2):
+            x = layers.Lambda(lambda t: t * 2)(x)
+    return keras.Model(inputs, x)
@@ -0,0 +1,36 @@
+from tensorflow import keras
+from tensorflow.keras import layers
+
+
+def build_model():
+    """Builds a model for inputs of shape (N, 32, 32, 3)."""
+    inputs = keras.Input(shape=(32, 32, 3))
+    x = inputs
+    x = layers.ZeroPadding2D(padding=((0, 1), (0, 1)))(x)
+    x = layers.Conv2D(16, (3, 3), strides=(2, 2))(x)
+    x = layers.BatchNormalization(epsilon=0.00001)(x)
+    x = layers.ReLU()(x)
+    for _ in range(2):
+        skip0 = x
+        x = layers.ZeroPadding2D(padding=((1, 1), (1, 1)))(x)
+        x = layers.Conv2D(16, (3, 3))(x)
+        x = layers.BatchNormalization(epsilon=0.00001)(x)
+        x = layers.ReLU()(x)
+        x = layers.ZeroPadding2D(padding=((1, 1), (1, 1)))(x)
+        x = layers.Conv2D(16, (3, 3))(x)
+        x = layers.Add()([x, skip0])
+        x = layers.ReLU()(x)
+    skip1 = layers.Conv2D(32, (1, 1), strides=(2, 2))(x)
+    x = layers.ZeroPadding2D(padding=((0, 1), (0, 1)))(x)
+    x = layers.Conv2D(32, (3, 3), strides=(2, 2))(x)
+    x = layers.ReLU()(x)
+    x = layers.ZeroPadding2D(padding=((1, 1), (1, 1)))(x)
+    x = layers.Conv2D(32, (3, 3))(x)
+    x = layers.Add()([x, skip1])
+    x = layers.AveragePooling2D(pool_size=(8, 8))(x)
+    x = layers.Dropout(0.5)(x)
+    x = layers.Flatten()(x)
+    x = layers.Dense(10)(x)
+    x = layers.Reshape((1, 1, 10))(x)
+    x = layers.Softmax()(x)
+    return keras.Model(inputs, x)
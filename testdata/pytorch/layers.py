@@ -0,0 +1,56 @@
+import torch
+import torch.nn as nn
+
+
+class ModelBlock1(nn.Module):
+    def __init__(self):
+        super().__init__()
+
+    def forward(self, x):
+        x = x * 2
+        return x
+
+
+class ModelBlock0(nn.Module):
+    def __init__(self):
+        super().__init__()
+        self.repeat0 = nn.ModuleList([ModelBlock1() for _ in range(2)])
+
+    def forward(self, x):
+        for block in self.repeat0:
+            x = block(x)
+        return x
+
+
+class Model(nn.Module):
+    """Expects inputs of shape (N, 4, 6, 8)."""
+
+    def __init__(self):
+        super().__init__()
+        self.pad0 = nn.ZeroPad2d((0, 2, 1, 0))
+        self.conv0 = nn.Conv2d(4, 8, kernel_size=(1, 3), dilation=(1, 2), groups=4)
+        self.pool0 = nn.MaxPool2d(kernel_size=(2, 2), stride=(1, 1))
+        self.pad1 = nn.ZeroPad2d((0, 1, 0, 1))
+        self.sigmoid0 = nn.Sigmoid()
+        self.tanh0 = nn.Tanh()
+        self.deconv0 = nn.ConvTranspose2d(24, 3, kernel_size=(2, 2), stride=(2, 2), output_padding=(0, 1))
+        self.resize0 = nn.Upsample(size=(4, 4), mode="bilinear", align_corners=False)
+        self.repeat0 = nn.ModuleList([ModelBlock0() for _ in range(3)])
+
+    def forward(self, x):
+        x = self.pad0(x)
+        x = self.conv0(x)
+        branch0 = self.pool0(x)
+        branch0 = self.pad1(branch0)
+        branch1 = x
+        branch2 = x * 0.5 - 1
+        branch2 = self.sigmoid0(branch2)
+        x = torch.cat([branch0, branch1, branch2], dim=1)
+        skip0 = x
+        x = self.tanh0(x)
+        x = x + skip0
+        x = self.deconv0(x)
+        x = self.resize0(x)
+        for block in self.repeat0:
+            x = block(x)
+        return x
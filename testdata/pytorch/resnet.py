@@ -0,0 +1,62 @@
+import torch
+import torch.nn as nn
+
+
+class ModelBlock0(nn.Module):
+    def __init__(self):
+        super().__init__()
+        self.conv0 = nn.Conv2d(16, 16, kernel_size=(3, 3), padding=(1, 1))
+        self.bn0 = nn.BatchNorm2d(16)
+        self.relu0 = nn.ReLU()
+        self.conv1 = nn.Conv2d(16, 16, kernel_size=(3, 3), padding=(1, 1))
+        self.relu1 = nn.ReLU()
+
+    def forward(self, x):
+        skip0 = x
+        x = self.conv0(x)
+        x = self.bn0(x)
+        x = self.relu0(x)
+        x = self.conv1(x)
+        x = x + skip0
+        x = self.relu1(x)
+        return x
+
+
+class Model(nn.Module):
+    """Expects inputs of shape (N, 3, 32, 32)."""
+
+    def __init__(self):
+        super().__init__()
+        self.pad0 = nn.ZeroPad2d((0, 1, 0, 1))
+        self.conv0 = nn.Conv2d(3, 16, kernel_size=(3, 3), stride=(2, 2))
+        self.bn0 = nn.BatchNorm2d(16)
+        self.relu0 = nn.ReLU()
+        self.repeat0 = nn.ModuleList([ModelBlock0() for _ in range(2)])
+        self.conv1 = nn.Conv2d(16, 32, kernel_size=(1, 1), stride=(2, 2))
+        self.pad1 = nn.ZeroPad2d((0, 1, 0, 1))
+        self.conv2 = nn.Conv2d(16, 32, kernel_size=(3, 3), stride=(2, 2))
+        self.relu1 = nn.ReLU()
+        self.conv3 = nn.Conv2d(32, 32, kernel_size=(3, 3), padding=(1, 1))
+        self.pool0 = nn.AvgPool2d(kernel_size=(8, 8))
+        self.dropout0 = nn.Dropout(p=0.5)
+        self.fc0 = nn.Linear(32, 10)
+        self.softmax0 = nn.Softmax(dim=1)
+
+    def forward(self, x):
+        x = self.pad0(x)
+        x = self.conv0(x)
+        x = self.bn0(x)
+        x = self.relu0(x)
+        for block in self.repeat0:
+            x = block(x)
+        skip1 = self.conv1(x)
+        x = self.pad1(x)
+        x = self.conv2(x)
+        x = self.relu1(x)
+        x = self.conv3(x)
+        x = x + skip1
+        x = self.pool0(x)
+        x = self.dropout0(x)
+        x = self.fc0(torch.flatten(x, 1))[:, :, None, None]
+        x = self.softmax0(x)
+        return x
@@ -0,0 +1,127 @@
+package convmarkup
+
+import "reflect"
+
+// A SourceMap maps Blocks to the ASTNodes that created
+// them.
+//
+// Since Blocks are used as map keys, only blocks which
+// are pointers can be looked up, as equal values would
+// share a key.
+// Custom blocks should be pointers to structs, like the
+// built-in blocks.
+// Other blocks are left out of the SourceMaps created by
+// BlockSources, and Node and Line treat them as unknown.
+type SourceMap map[Block]*ASTNode
+
+// Node returns the node that created b, or nil if the
+// source is unknown.
+//
+// It is safe to call Node on a nil SourceMap.
+func (s SourceMap) Node(b Block) *ASTNode {
+	if !pointerBlock(b) {
+		return nil
+	}
+	return s[b]
+}
+
+// Line returns the line number (starting at 0) of the
+// node that created b, or -1 if the source is unknown.
+//
+// It is safe to call Line on a nil SourceMap.
+func (s SourceMap) Line(b Block) int {
+	if node := s.Node(b); node != nil {
+		return node.Line
+	}
+	return -1
+}
+
+// pointerBlock checks if a block is a pointer, making it
+// safe to use as a map key.
+func pointerBlock(b Block) bool {
+	return b != nil && reflect.TypeOf(b).Kind() == reflect.Ptr
+}
+
+// SubBlocks returns the sequences of sub-blocks contained
+// in a block.
+// Every sequence is fed the input of the containing block.
+//
+// For a Residual, the projection (if there is one) comes
+// before the residual mapping.
+// For a Concat, there is one sequence per branch.
+// For blocks without sub-blocks, nil is returned.
+func SubBlocks(b Block) [][]Block {
+	switch b := b.(type) {
+	case *Root:
+		return [][]Block{b.Children}
+	case *Residual:
+		if b.Projection != nil {
+			return [][]Block{b.Projection, b.Residual}
+		}
+		return [][]Block{b.Residual}
+	case *Projection:
+		return [][]Block{b.Children}
+	case *Repeat:
+		return [][]Block{b.Children}
+	case *Concat:
+		return b.Branches
+	case *Branch:
+		return [][]Block{b.Children}
+	}
+	return nil
+}
+
+// A WalkStep describes a block visited by Walk.
+type WalkStep struct {
+	Block Block
+
+	// In is the input dimensions of the block.
+	In Dims
+
+	// Depth is the nesting depth of the block, where the
+	// block passed to Walk has depth 0.
+	Depth int
+
+	// Count is the number of times the block is applied in
+	// a single forward pass, taking enclosing Repeat blocks
+	// into account.
+	Count int
+}
+
+// Walk visits a block and all of its sub-blocks in order,
+// calling f for each one.
+// A block is visited before its sub-blocks.
+//
+// The in argument specifies the input dimensions of b.
+//
+// If f returns an error, the walk is stopped and the error
+// is returned.
+func Walk(b Block, in Dims, f func(step WalkStep) error) error {
+	return walk(WalkStep{Block: b, In: in, Count: 1}, f)
+}
+
+func walk(step WalkStep, f func(step WalkStep) error) error {
+	if err := f(step); err != nil {
+		return err
+	}
+	count := step.Count
+	if r, ok := step.Block.(*Repeat); ok {
+		count *= r.N
+	}
+	for _, seq := range SubBlocks(step.Block) {
+		in := step.In
+		for _, child := range seq {
+			err := walk(WalkStep{
+				Block: child,
+				In:    in,
+				Depth: step.Depth + 1,
+				Count: count,
+			}, f)
+			if err != nil {
+				return err
+			}
+			in = child.OutDims()
+		}
+	}
+	return nil
+}